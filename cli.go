@@ -3,6 +3,8 @@ package main
 import (
 	"fmt"
 
+	"github.com/chenjiandongx/sniffer/pkg/capture"
+	"github.com/chenjiandongx/sniffer/pkg/stats"
 	"github.com/spf13/cobra"
 )
 
@@ -14,6 +16,8 @@ func NewApp() *cobra.Command {
 	opt := Options{}
 	var mode int
 	var unit string
+	var countLayer string
+	var sortKey string
 	var list bool
 
 	app := &cobra.Command{
@@ -22,7 +26,7 @@ func NewApp() *cobra.Command {
 		Version: version,
 		Run: func(cmd *cobra.Command, args []string) {
 			if list {
-				devices, err := ListAllDevices()
+				devices, err := capture.ListAllDevices()
 				if err != nil {
 					exit(err.Error())
 				}
@@ -32,7 +36,13 @@ func NewApp() *cobra.Command {
 				return
 			}
 			opt.ViewMode = ViewMode(mode)
+			opt.Application = defaultOpts.Application
 			opt.Unit = Unit(unit)
+			opt.CountLayer = capture.CountLayer(countLayer)
+			var err error
+			if opt.SortKey, err = stats.ParseSortKey(sortKey); err != nil {
+				exit(err.Error())
+			}
 			if err := opt.Validate(); err != nil {
 				exit(err.Error())
 			}
@@ -48,7 +58,41 @@ func NewApp() *cobra.Command {
   $ sniffer -u MB
 
   # only capture the TCP protocol packets with lo,eth prefixed devices
-  $ sniffer -b tcp -d lo -d eth`,
+  $ sniffer -b tcp -d lo -d eth
+
+  # headless mode, write the stats as JSON lines to stdout every 5 seconds
+  $ sniffer -j - -i 5 | jq .totals
+
+  # headless mode, write a row per connection every minute into daily rotated CSV files
+  $ sniffer --csv report.csv -i 60
+
+  # headless mode, serve the Prometheus metrics on :9100/metrics
+  $ sniffer --exporter :9100 --exporter-top-n 50
+
+  # keep the terminal UI while sending the gauges to StatsD and the logs to syslog
+  $ sniffer --tui --statsd 127.0.0.1:8125 --syslog local
+
+  # record the packets into pcapng files rotated every 100MB, keep the latest 5 files
+  $ sniffer -w dump.pcapng --write-max-size 100 --write-max-files 5
+
+  # replay a capture file as fast as possible with the socket snapshot of its host
+  $ sniffer -r dump.pcapng --replay-fast --sockets ss.txt
+
+  # capture inside the network namespace of a process, or all the namespaces (Linux only)
+  $ sniffer --netns 1234
+  $ sniffer --all-netns -d eth -d veth
+
+  # count the frames with the Ethernet headers to agree with the 'ip -s link' counters
+  $ sniffer --count-layer l2
+
+  # record the owners of the sockets by eBPF instead of walking /proc (Linux only)
+  $ sniffer --backend ebpf
+
+  # rank the tables by the download and only show the rows matching curl or nginx
+  $ sniffer --sort download --filter 'curl|nginx'
+
+  # plot the top processes along with nginx and the traffic to 10.0.0.2
+  $ sniffer -m 2 --pin nginx --pin 10.0.0.2`,
 	}
 
 	app.Flags().BoolVarP(&list, "list", "l", false, "list all devices name")
@@ -58,6 +102,31 @@ func NewApp() *cobra.Command {
 	app.Flags().StringArrayVarP(&opt.DevicesPrefix, "devices-prefix", "d", defaultOpts.DevicesPrefix, "prefixed devices to monitor")
 	app.Flags().BoolVarP(&opt.DisableDNSResolve, "no-dns-resolve", "n", defaultOpts.DisableDNSResolve, "disable the DNS resolution")
 	app.Flags().IntVarP(&mode, "mode", "m", int(defaultOpts.ViewMode), "view mode of sniffer (0: bytes 1: packets 2: plot)")
+	app.Flags().StringVarP(&opt.ReadFile, "read", "r", "", "replay packets from the pcap/pcapng capture file instead of the live capture")
+	app.Flags().BoolVar(&opt.ReplayFast, "replay-fast", false, "replay the capture file as fast as possible rather than at the recorded speed")
+	app.Flags().StringVar(&opt.SocketsFile, "sockets", "", "output of 'ss -tunap' taken on the capture host to attribute replayed packets to processes")
+	app.Flags().StringVarP(&opt.JSONOutput, "json", "j", "", "headless mode, write the stats as JSON lines to the file, '-' for stdout")
+	app.Flags().StringVar(&opt.CSVOutput, "csv", "", "headless mode, write a row per connection into the daily rotated file, '-' for stdout, .tsv for tabs")
+	app.Flags().StringVar(&opt.ExporterAddr, "exporter", "", "headless mode, serve the Prometheus metrics on the address, eg. :9100")
+	app.Flags().IntVar(&opt.ExporterTopN, "exporter-top-n", defaultOpts.ExporterTopN, "number of the exported series per label, the rest are rolled into 'other'")
+	app.Flags().StringVar(&opt.StatsDAddr, "statsd", "", "headless mode, send the stats as StatsD gauges to the UDP address, eg. 127.0.0.1:8125")
+	app.Flags().StringVar(&opt.StatsDPrefix, "statsd-prefix", defaultOpts.StatsDPrefix, "prefix of the StatsD gauge names")
+	app.Flags().StringVar(&opt.SyslogAddr, "syslog", "", "headless mode, write the stats to syslog, 'local' or the address like udp://host:514")
+	app.Flags().BoolVar(&opt.TUI, "tui", false, "keep the terminal UI along with the headless mode outputs")
+	app.Flags().StringVarP(&opt.WriteFile, "write", "w", "", "record the captured packets into the pcapng file")
+	app.Flags().IntVar(&opt.WriteMaxSize, "write-max-size", 0, "rotate the recording file once it exceeds the size in MB")
+	app.Flags().DurationVar(&opt.WriteRotateInterval, "write-rotate-interval", 0, "rotate the recording file once it is older than the interval, eg. 10m")
+	app.Flags().IntVar(&opt.WriteMaxFiles, "write-max-files", defaultOpts.WriteMaxFiles, "number of the rotated recording files to keep, 0 means unlimited")
+	app.Flags().StringVar(&opt.Netns, "netns", "", "capture inside the network namespace specified by a path or the pid of a process in it (Linux only)")
+	app.Flags().BoolVar(&opt.AllNetns, "all-netns", false, "capture inside all the network namespaces found under /proc/*/ns/net on start (Linux only)")
+	app.Flags().StringVar(&countLayer, "count-layer", string(defaultOpts.CountLayer), "layer which the traffic is measured from, optional: l2, l3, l4, payload")
+	app.Flags().StringVar(&opt.SocketBackend, "backend", defaultOpts.SocketBackend, "backend to find the owners of the sockets by, 'native' walking /proc or 'ebpf' recording them in the kernel which falls back to native if unavailable (Linux only)")
+	app.Flags().BoolVar(&opt.DisablePayloadInspect, "no-payload-inspect", false, "disable extracting the TLS SNI and HTTP Host from the first packets of connections")
+	app.Flags().BoolVar(&opt.Averages, "averages", false, "show the rates averaged over 1s/10s/60s and the peaks in the tables, toggled by <a>")
+	app.Flags().BoolVar(&opt.Cumulative, "cumulative", false, "show the traffic accumulated since shown along with the rates, toggled by <c> and reset by <r>")
+	app.Flags().StringVar(&sortKey, "sort", defaultOpts.SortKey.String(), "field to rank the tables by, optional: total, upload, download, connections, name, cycled by <o>")
+	app.Flags().StringVar(&opt.Filter, "filter", "", "keep the rows whose process, address or port match the regular expression or substring, edited by </>")
+	app.Flags().StringArrayVar(&opt.Pins, "pin", nil, "process or remote address always plotted in the plot mode besides the top processes, toggled by <p>")
 	app.Flags().StringVarP(&unit, "unit", "u", defaultOpts.Unit.String(), "unit of traffic stats, optional: B, Kb, KB, Mb, MB, Gb, GB")
 
 	app.Flags().PrintDefaults()
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chenjiandongx/sniffer/pkg/socket"
+	"github.com/chenjiandongx/sniffer/pkg/stats"
+)
+
+var csvHeader = []string{
+	"timestamp",
+	"interval",
+	"interface",
+	"protocol",
+	"local",
+	"remote",
+	"remote_name",
+	"process",
+	"pid",
+	"upload_bytes",
+	"download_bytes",
+	"upload_packets",
+	"download_packets",
+}
+
+// CSVWriter writes one row per connection of each Snapshot. The traffic is the raw counts
+// of the interval rather than the rates, so that the rows sum up without the rounding and
+// the rates are the counts divided by the interval column. The rows are written into one
+// file per day named with the date suffix, or the stdout without rotation if the name is
+// "-". The values are separated by tabs if the file extension is .tsv.
+type CSVWriter struct {
+	path     string
+	interval int
+	comma    rune
+
+	day  string
+	w    io.WriteCloser
+	rows *csv.Writer
+}
+
+func NewCSVWriter(name string, opt Options) (*CSVWriter, error) {
+	cw := &CSVWriter{path: name, interval: opt.Interval, comma: ','}
+	if strings.EqualFold(filepath.Ext(name), ".tsv") {
+		cw.comma = '\t'
+	}
+
+	if name == "-" {
+		cw.setWriter(os.Stdout)
+		if err := cw.rows.Write(csvHeader); err != nil {
+			return nil, err
+		}
+	}
+	return cw, nil
+}
+
+func (cw *CSVWriter) setWriter(w io.WriteCloser) {
+	cw.w = w
+	cw.rows = csv.NewWriter(w)
+	cw.rows.Comma = cw.comma
+}
+
+// dailyPath returns the path of the file for the day of t.
+func (cw *CSVWriter) dailyPath(t time.Time) string {
+	ext := filepath.Ext(cw.path)
+	base := strings.TrimSuffix(cw.path, ext)
+	return fmt.Sprintf("%s-%s%s", base, t.Format("20060102"), ext)
+}
+
+// rotate switches to the file of the day of t. The file is appended if it exists, the
+// header is written only into the empty file.
+func (cw *CSVWriter) rotate(t time.Time) error {
+	day := t.Format("20060102")
+	if cw.path == "-" || day == cw.day {
+		return nil
+	}
+
+	if err := cw.closeFile(); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(cw.dailyPath(t), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	cw.setWriter(f)
+	cw.day = day
+	if fi.Size() == 0 {
+		return cw.rows.Write(csvHeader)
+	}
+	return nil
+}
+
+func (cw *CSVWriter) Report(snapshot *stats.Snapshot) error {
+	if err := cw.rotate(snapshot.Time); err != nil {
+		return err
+	}
+
+	timestamp := snapshot.Time.Format(time.RFC3339)
+	interval := strconv.Itoa(cw.interval)
+	for _, r := range snapshot.TopNConnections(len(snapshot.Connections), stats.OrderBytes) {
+		info, ok := snapshot.Stat.Utilization[r.Conn]
+		if !ok {
+			continue
+		}
+		process, pid := socket.UnknownProcessName, ""
+		if procInfo, ok := snapshot.Stat.OpenSockets.Lookup(r.Conn.Local, info.Inbound); ok {
+			process, pid = procInfo.Name, strconv.Itoa(procInfo.Pid)
+		}
+
+		err := cw.rows.Write([]string{
+			timestamp,
+			interval,
+			r.Data.InterfaceName,
+			string(r.Conn.Local.Protocol),
+			joinHostPort(r.Conn.Local.IP, r.Conn.Local.Port),
+			joinHostPort(r.Conn.Remote.IP, r.Conn.Remote.Port),
+			r.Data.RemoteName,
+			process,
+			pid,
+			strconv.Itoa(info.UploadBytes),
+			strconv.Itoa(info.DownloadBytes),
+			strconv.Itoa(info.UploadPackets),
+			strconv.Itoa(info.DownloadPackets),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.rows.Flush()
+	return cw.rows.Error()
+}
+
+func joinHostPort(ip string, port uint16) string {
+	return net.JoinHostPort(ip, strconv.Itoa(int(port)))
+}
+
+// closeFile flushes and closes the file of the day. The file is dropped even if it fails
+// to close, so that the next Report opens the file again rather than closing it twice.
+func (cw *CSVWriter) closeFile() error {
+	if cw.w == nil {
+		return nil
+	}
+	cw.rows.Flush()
+	err := cw.rows.Error()
+	if cw.w == os.Stdout {
+		return err
+	}
+
+	if cerr := cw.w.Close(); err == nil {
+		err = cerr
+	}
+	cw.w, cw.rows, cw.day = nil, nil, ""
+	return err
+}
+
+func (cw *CSVWriter) Close() error {
+	return cw.closeFile()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/chenjiandongx/sniffer/pkg/capture"
+	"github.com/chenjiandongx/sniffer/pkg/socket"
+	"github.com/chenjiandongx/sniffer/pkg/stats"
+	"github.com/stretchr/testify/assert"
+)
+
+type failingCloser struct {
+	bytes.Buffer
+	closed int
+}
+
+func (c *failingCloser) Close() error {
+	c.closed++
+	return errors.New("input/output error")
+}
+
+func TestCSVWriterRotate(t *testing.T) {
+	dir := t.TempDir()
+	opts := DefaultOptions()
+	opts.Interval = 3
+	cw, err := NewCSVWriter(filepath.Join(dir, "report.csv"), opts)
+	assert.NoError(t, err)
+
+	conn := capture.Connection{
+		Local:  capture.LocalSocket{IP: "10.0.0.1", Port: 50000, Protocol: capture.ProtoTCP},
+		Remote: capture.RemoteSocket{IP: "2001:db8::1", Port: 443},
+	}
+	// The rates of the snapshot are divided by the interval, the rows keep the raw counts.
+	newSnapshot := func(t time.Time) *stats.Snapshot {
+		return &stats.Snapshot{
+			Time: t,
+			Connections: map[capture.Connection]*stats.ConnectionData{
+				conn: {InterfaceName: "eth0", RemoteName: "example.com", UploadBytes: 10, DownloadBytes: 20},
+			},
+			Stat: stats.Stat{
+				OpenSockets: socket.OpenSockets{conn.Local: {Pid: 42, Name: "curl"}},
+				Utilization: capture.Utilization{
+					conn: {Interface: "eth0", UploadBytes: 30, DownloadBytes: 61, UploadPackets: 1, DownloadPackets: 2},
+				},
+			},
+		}
+	}
+
+	day := time.Date(2020, 1, 1, 23, 59, 59, 0, time.Local)
+	assert.NoError(t, cw.Report(newSnapshot(day)))
+	assert.NoError(t, cw.Report(newSnapshot(day.Add(time.Second))))
+
+	// The file failing to close is dropped, the next report opens the file of the day.
+	broken := &failingCloser{}
+	cw.setWriter(broken)
+	assert.Error(t, cw.Report(newSnapshot(day.Add(48*time.Hour))))
+	assert.NoError(t, cw.Report(newSnapshot(day.Add(48*time.Hour))))
+	assert.Equal(t, 1, broken.closed)
+	assert.NoError(t, cw.Close())
+
+	header := "timestamp,interval,interface,protocol,local,remote,remote_name,process,pid,upload_bytes,download_bytes,upload_packets,download_packets\n"
+	row := func(t time.Time) string {
+		return t.Format(time.RFC3339) + ",3,eth0,tcp,10.0.0.1:50000,[2001:db8::1]:443,example.com,curl,42,30,61,1,2\n"
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "report-20200101.csv"))
+	assert.NoError(t, err)
+	assert.Equal(t, header+row(day), string(content))
+
+	content, err = ioutil.ReadFile(filepath.Join(dir, "report-20200102.csv"))
+	assert.NoError(t, err)
+	assert.Equal(t, header+row(day.Add(time.Second)), string(content))
+
+	content, err = ioutil.ReadFile(filepath.Join(dir, "report-20200103.csv"))
+	assert.NoError(t, err)
+	assert.Equal(t, header+row(day.Add(48*time.Hour)), string(content))
+}
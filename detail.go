@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/chenjiandongx/sniffer/pkg/stats"
+	"github.com/chenjiandongx/termui/v3"
+	"github.com/chenjiandongx/termui/v3/widgets"
+	"github.com/gammazero/deque"
+)
+
+const detailFooterText = "<esc> Back. <space> Pause. <q> Exit. <tab> Rearrange tables. <o> Sort. <b> BPF filter"
+
+// DetailViewer drills down from a row of the Process table, it shows the connections, the
+// remote addresses and the interfaces of the process along with the plot of its traffic.
+type DetailViewer struct {
+	process string
+
+	// tables is the viewer which the detail is opened from, the rows are formatted and
+	// ranked like the ones of it.
+	tables *TableViewer
+
+	header      *widgets.Paragraph
+	footer      *widgets.Paragraph
+	connections *widgets.Table
+	remoteAddrs *widgets.Table
+	interfaces  *widgets.Table
+	plot        *widgets.Plot
+	upList      *queue
+	downList    *queue
+	widgetRef   []termui.Drawable
+	grid        *termui.Grid
+	shiftIdx    int
+	last        *stats.Snapshot
+}
+
+func newDetailViewer(process string, tables *TableViewer) *DetailViewer {
+	title := fmt.Sprintf("Bytes: <Unit %sps> Blue Up / Green Down", tables.unit.String())
+	if tables.mode == ModeTablePackets {
+		title = "Packets: Blue Up / Green Down"
+	}
+	return &DetailViewer{
+		process:     process,
+		tables:      tables,
+		footer:      newParagraph(detailFooterText),
+		connections: newTable("Connections of " + process),
+		remoteAddrs: newTable("Remote Address"),
+		interfaces:  newTable("Interfaces"),
+		plot:        newPlot(title, 2),
+	}
+}
+
+func (dv *DetailViewer) Setup() {
+	dv.header = newParagraph(dv.getHeaderText(time.Now(), 0, "", ""))
+	dv.widgetRef = []termui.Drawable{dv.connections, dv.remoteAddrs, dv.interfaces, dv.plot}
+	width, height := termui.TerminalDimensions()
+
+	dv.upList = &queue{size: width/2 - padding, deque: deque.New()}
+	dv.downList = &queue{size: width/2 - padding, deque: deque.New()}
+	dv.grid = dv.newGrid(width, height)
+}
+
+func (dv *DetailViewer) getHeaderText(t time.Time, conn int, up, down string) string {
+	return fmt.Sprintf("[Process] %s Time: %s  [Total] Conn:%d Up:%s Down:%s", dv.process, t.Format(timeFormat), conn, up, down)
+}
+
+func (dv *DetailViewer) updateHeader(snapshot *stats.Snapshot) {
+	tv := dv.tables
+	up, down := tv.upDown(traffic{
+		snapshot.TotalUploadBytes, snapshot.TotalDownloadBytes,
+		snapshot.TotalUploadPackets, snapshot.TotalDownloadPackets,
+	})
+	dv.header.Text = dv.getHeaderText(snapshot.Time, snapshot.TotalConnections, tv.humanizeRate(up), tv.humanizeRate(down))
+}
+
+func (dv *DetailViewer) updateConnections(snapshot *stats.Snapshot) {
+	tv := dv.format()
+	rows := make([][]string, 0)
+	for _, r := range tv.source(snapshot).TopNConnectionsBy(maxRows, tv.order(), tv.sortKey, tv.matcher) {
+		conn := fmt.Sprintf("<%s>:%d => %s:%d (%s)",
+			r.Data.InterfaceName,
+			r.Conn.Local.Port,
+			r.Data.RemoteName,
+			r.Conn.Remote.Port,
+			r.Conn.Local.Protocol,
+		)
+		get := func(s *stats.Snapshot) traffic { return connectionTraffic(s.Connections[r.Conn]) }
+		rows = append(rows, tv.row(snapshot, get, conn))
+	}
+	tv.setRows(dv.connections, []string{"Connections", "Up / Down"}, rows)
+}
+
+func (dv *DetailViewer) updateRemoteAddrs(snapshot *stats.Snapshot) {
+	tv := dv.format()
+	rows := make([][]string, 0)
+	for _, r := range tv.source(snapshot).TopNRemoteAddrsBy(maxRows, tv.order(), tv.sortKey, tv.matcher) {
+		get := func(s *stats.Snapshot) traffic { return networkTraffic(s.RemoteAddrs[r.Addr]) }
+		rows = append(rows, tv.row(snapshot, get, r.Addr, strconv.Itoa(r.Data.ConnCount)))
+	}
+	tv.setRows(dv.remoteAddrs, []string{"Remote Address", "Connections", "Up / Down"}, rows)
+}
+
+// format returns the viewer which formats the rows like the tables without the averaged
+// columns, the averages and the peaks are not split by processes.
+func (dv *DetailViewer) format() *TableViewer {
+	tv := *dv.tables
+	tv.averages = false
+	return &tv
+}
+
+func (dv *DetailViewer) updateInterfaces(snapshot *stats.Snapshot) {
+	tv := dv.format()
+	rows := make([][]string, 0)
+	for _, r := range tv.source(snapshot).TopNInterfacesBy(maxRows, tv.order(), tv.sortKey, tv.matcher) {
+		get := func(s *stats.Snapshot) traffic { return networkTraffic(s.Interfaces[r.Interface]) }
+		rows = append(rows, tv.row(snapshot, get, r.Interface, strconv.Itoa(r.Data.ConnCount)))
+	}
+	tv.setRows(dv.interfaces, []string{"Interface", "Connections", "Up / Down"}, rows)
+}
+
+func (dv *DetailViewer) updatePlot(snapshot *stats.Snapshot) {
+	up, down := dv.tables.upDown(traffic{
+		snapshot.TotalUploadBytes, snapshot.TotalDownloadBytes,
+		snapshot.TotalUploadPackets, snapshot.TotalDownloadPackets,
+	})
+	dv.upList.Put(float64(up))
+	dv.downList.Put(float64(down))
+
+	ratio := dv.tables.unit.Ratio()
+	if dv.tables.mode == ModeTablePackets {
+		ratio = 1
+	}
+	for i, q := range []*queue{dv.upList, dv.downList} {
+		data := q.Get(ratio)
+		// A line is drawn between two points at least, the first sample is drawn flat.
+		if len(data) == 1 {
+			data = append(data, data[0])
+		}
+		dv.plot.Data[i] = data
+	}
+}
+
+// widths returns the column widths of the table, or nil for the plot.
+func (dv *DetailViewer) widths(widget termui.Drawable, width int) []int {
+	switch widget {
+	case dv.connections:
+		return dv.format().columnWidths(width, 3, 2)
+	case dv.remoteAddrs, dv.interfaces:
+		return dv.format().columnWidths(width, 2, 1, 1)
+	}
+	return nil
+}
+
+func (dv *DetailViewer) newGrid(width, height int) *termui.Grid {
+	grid := termui.NewGrid()
+	grid.SetRect(0, 0, width, height)
+
+	num := len(dv.widgetRef)
+	w := (width) / 12
+	spans := []int{8, 4, 4, 8}
+	for i, span := range spans {
+		widget := dv.widgetRef[(dv.shiftIdx+i+1)%num]
+		if table, ok := widget.(*widgets.Table); ok {
+			table.ColumnWidths = dv.widths(widget, w*span)
+			continue
+		}
+		dv.upList.Resize(w*span - padding)
+		dv.downList.Resize(w*span - padding)
+	}
+
+	grid.Set(
+		termui.NewRow(0.03, termui.NewCol(1.0, dv.header)),
+		termui.NewRow(0.47,
+			termui.NewCol(2.0/3, dv.widgetRef[(dv.shiftIdx+1)%num]),
+			termui.NewCol(1.0/3, dv.widgetRef[(dv.shiftIdx+2)%num]),
+		),
+		termui.NewRow(0.47,
+			termui.NewCol(1.0/3, dv.widgetRef[(dv.shiftIdx+3)%num]),
+			termui.NewCol(2.0/3, dv.widgetRef[(dv.shiftIdx+4)%num]),
+		),
+		termui.NewRow(0.03, termui.NewCol(1.0, dv.footer)),
+	)
+	return grid
+}
+
+func (dv *DetailViewer) Shift() {
+	dv.shiftIdx++
+	width, height := termui.TerminalDimensions()
+	dv.grid = dv.newGrid(width, height)
+	termui.Render(dv.grid)
+}
+
+func (dv *DetailViewer) Resize(width, height int) {
+	dv.grid = dv.newGrid(width, height)
+	termui.Render(dv.grid)
+}
+
+func (dv *DetailViewer) SetFooter(text string) {
+	if text == "" {
+		text = detailFooterText
+	}
+	dv.footer.Text = text
+	termui.Render(dv.grid)
+}
+
+func (dv *DetailViewer) Render(snapshot *stats.Snapshot) {
+	if snapshot == nil {
+		return
+	}
+	dv.last = snapshot
+	dv.updatePlot(snapshot.ProcessSnapshot(dv.process))
+	dv.refresh()
+}
+
+// refresh renders the tables of the last snapshot, the plot is left as is.
+func (dv *DetailViewer) refresh() {
+	if dv.last != nil {
+		process := dv.last.ProcessSnapshot(dv.process)
+		dv.updateHeader(process)
+		dv.updateConnections(process)
+		dv.updateRemoteAddrs(process)
+		dv.updateInterfaces(process)
+	}
+	termui.Render(dv.grid)
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chenjiandongx/sniffer/pkg/capture"
+	"github.com/chenjiandongx/sniffer/pkg/socket"
+	"github.com/chenjiandongx/sniffer/pkg/stats"
+)
+
+const (
+	otherLabelValue = "other"
+
+	// exporterIdleTimeout is how long an exported series keeps its slot without traffic.
+	exporterIdleTimeout = 5 * time.Minute
+)
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+type trafficCounter struct {
+	UploadBytes     uint64
+	DownloadBytes   uint64
+	UploadPackets   uint64
+	DownloadPackets uint64
+}
+
+func (c *trafficCounter) add(o trafficCounter) {
+	c.UploadBytes += o.UploadBytes
+	c.DownloadBytes += o.DownloadBytes
+	c.UploadPackets += o.UploadPackets
+	c.DownloadPackets += o.DownloadPackets
+}
+
+func (c trafficCounter) total() uint64 {
+	return c.UploadBytes + c.DownloadBytes
+}
+
+// counterGroup accumulates the traffic counters keyed by the rendered label pairs. At most
+// N keys are exported with their own series, the traffic of the rest is rolled into the
+// other series. A key takes a free slot when it ranks high by the bytes of the interval and
+// counts from then on, and it keeps the slot until idle for exporterIdleTimeout, so that the
+// series never move traffic between each other and all of them stay monotonic.
+type counterGroup struct {
+	topN        int
+	otherLabels string
+	exported    map[string]*exportedCounter
+	other       trafficCounter
+}
+
+type exportedCounter struct {
+	trafficCounter
+	lastSeen time.Time
+}
+
+func newCounterGroup(topN int, otherLabels string) *counterGroup {
+	return &counterGroup{
+		topN:        topN,
+		otherLabels: otherLabels,
+		exported:    make(map[string]*exportedCounter),
+	}
+}
+
+func (g *counterGroup) add(t time.Time, deltas map[string]trafficCounter) {
+	var candidates []string
+	for labels, delta := range deltas {
+		c, ok := g.exported[labels]
+		if !ok {
+			candidates = append(candidates, labels)
+			continue
+		}
+		c.add(delta)
+		c.lastSeen = t
+	}
+
+	// The idle series are dropped to free the slots, Prometheus marks them as stale and a
+	// key exported again later starts from zero like a restarted counter.
+	for labels, c := range g.exported {
+		if t.Sub(c.lastSeen) > exporterIdleTimeout {
+			delete(g.exported, labels)
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		ti, tj := deltas[candidates[i]].total(), deltas[candidates[j]].total()
+		if ti != tj {
+			return ti > tj
+		}
+		return candidates[i] < candidates[j]
+	})
+	for _, labels := range candidates {
+		if len(g.exported) < g.topN {
+			g.exported[labels] = &exportedCounter{trafficCounter: deltas[labels], lastSeen: t}
+			continue
+		}
+		g.other.add(deltas[labels])
+	}
+}
+
+// series returns the exported label pairs with their counters in a stable order.
+func (g *counterGroup) series() ([]string, map[string]trafficCounter) {
+	counters := make(map[string]trafficCounter, len(g.exported)+1)
+	var keys []string
+	for labels, c := range g.exported {
+		keys = append(keys, labels)
+		counters[labels] = c.trafficCounter
+	}
+	sort.Strings(keys)
+
+	if g.other != (trafficCounter{}) {
+		keys = append(keys, g.otherLabels)
+		counters[g.otherLabels] = g.other
+	}
+	return keys, counters
+}
+
+// Exporter serves the cumulative traffic counters of processes, remote addresses and
+// interfaces in the Prometheus text format.
+type Exporter struct {
+	mut         sync.Mutex
+	processes   *counterGroup
+	remoteAddrs *counterGroup
+	interfaces  *counterGroup
+	server      *http.Server
+}
+
+func NewExporter(opt Options) (*Exporter, error) {
+	e := &Exporter{
+		processes:   newCounterGroup(opt.ExporterTopN, labelPairs("process", otherLabelValue, "pid", "")),
+		remoteAddrs: newCounterGroup(opt.ExporterTopN, labelPairs("addr", otherLabelValue)),
+		interfaces:  newCounterGroup(opt.ExporterTopN, labelPairs("interface", otherLabelValue)),
+	}
+
+	ln, err := net.Listen("tcp", opt.ExporterAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", e)
+	e.server = &http.Server{Handler: mux}
+	go e.server.Serve(ln)
+
+	return e, nil
+}
+
+// labelPairs renders the label names and values like `name1="value1",name2="value2"`.
+func labelPairs(nameValues ...string) string {
+	var pairs []string
+	for i := 0; i+1 < len(nameValues); i += 2 {
+		pairs = append(pairs, nameValues[i]+`="`+labelValueEscaper.Replace(nameValues[i+1])+`"`)
+	}
+	return strings.Join(pairs, ",")
+}
+
+// Collect accumulates the utilization of an interval, the traffic is not divided by
+// the interval so that the counters are cumulative.
+func (e *Exporter) Collect(stat stats.Stat) {
+	processes := map[string]trafficCounter{}
+	remoteAddrs := map[string]trafficCounter{}
+	interfaces := map[string]trafficCounter{}
+
+	add := func(m map[string]trafficCounter, labels string, info *capture.ConnectionInfo) {
+		c := m[labels]
+		c.add(trafficCounter{
+			UploadBytes:     uint64(info.UploadBytes),
+			DownloadBytes:   uint64(info.DownloadBytes),
+			UploadPackets:   uint64(info.UploadPackets),
+			DownloadPackets: uint64(info.DownloadPackets),
+		})
+		m[labels] = c
+	}
+
+	for conn, info := range stat.Utilization {
+		procLabels := labelPairs("process", socket.UnknownProcessName, "pid", "")
+		if procInfo, ok := stat.OpenSockets.Lookup(conn.Local, info.Inbound); ok {
+			procLabels = labelPairs("process", procInfo.Name, "pid", strconv.Itoa(procInfo.Pid))
+		}
+
+		add(processes, procLabels, info)
+		add(remoteAddrs, labelPairs("addr", conn.Remote.IP), info)
+		add(interfaces, labelPairs("interface", info.Interface), info)
+	}
+
+	e.mut.Lock()
+	defer e.mut.Unlock()
+
+	e.processes.add(stat.Time, processes)
+	e.remoteAddrs.add(stat.Time, remoteAddrs)
+	e.interfaces.add(stat.Time, interfaces)
+}
+
+// Report collects the raw utilization which the snapshot is taken from.
+func (e *Exporter) Report(snapshot *stats.Snapshot) error {
+	e.Collect(snapshot.Stat)
+	return nil
+}
+
+func writeCounters(w io.Writer, name, subject string, group *counterGroup) {
+	keys, counters := group.series()
+
+	fmt.Fprintf(w, "# HELP sniffer_%s_bytes_total Bytes transferred by the %s.\n", name, subject)
+	fmt.Fprintf(w, "# TYPE sniffer_%s_bytes_total counter\n", name)
+	for _, labels := range keys {
+		fmt.Fprintf(w, "sniffer_%s_bytes_total{%s,direction=\"upload\"} %d\n", name, labels, counters[labels].UploadBytes)
+		fmt.Fprintf(w, "sniffer_%s_bytes_total{%s,direction=\"download\"} %d\n", name, labels, counters[labels].DownloadBytes)
+	}
+
+	fmt.Fprintf(w, "# HELP sniffer_%s_packets_total Packets transferred by the %s.\n", name, subject)
+	fmt.Fprintf(w, "# TYPE sniffer_%s_packets_total counter\n", name)
+	for _, labels := range keys {
+		fmt.Fprintf(w, "sniffer_%s_packets_total{%s,direction=\"upload\"} %d\n", name, labels, counters[labels].UploadPackets)
+		fmt.Fprintf(w, "sniffer_%s_packets_total{%s,direction=\"download\"} %d\n", name, labels, counters[labels].DownloadPackets)
+	}
+}
+
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writeCounters(w, "process", "process", e.processes)
+	writeCounters(w, "remote", "remote address", e.remoteAddrs)
+	writeCounters(w, "interface", "interface", e.interfaces)
+}
+
+func (e *Exporter) Close() error {
+	return e.server.Close()
+}
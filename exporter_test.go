@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/chenjiandongx/sniffer/pkg/capture"
+	"github.com/chenjiandongx/sniffer/pkg/socket"
+	"github.com/chenjiandongx/sniffer/pkg/stats"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExporterTopN(t *testing.T) {
+	e := &Exporter{
+		processes:   newCounterGroup(1, labelPairs("process", otherLabelValue, "pid", "")),
+		remoteAddrs: newCounterGroup(1, labelPairs("addr", otherLabelValue)),
+		interfaces:  newCounterGroup(1, labelPairs("interface", otherLabelValue)),
+	}
+
+	nginx := capture.Connection{
+		Local:  capture.LocalSocket{IP: "10.0.0.1", Port: 80, Protocol: capture.ProtoTCP},
+		Remote: capture.RemoteSocket{IP: "10.0.0.2", Port: 40000},
+	}
+	curl := capture.Connection{
+		Local:  capture.LocalSocket{IP: "10.0.0.1", Port: 50000, Protocol: capture.ProtoTCP},
+		Remote: capture.RemoteSocket{IP: "10.0.0.3", Port: 443},
+	}
+	openSockets := socket.OpenSockets{
+		{IP: "*", Port: 80, Protocol: capture.ProtoTCP}:           {Pid: 1, Name: "nginx"},
+		{IP: "10.0.0.1", Port: 50000, Protocol: capture.ProtoTCP}: {Pid: 2, Name: "curl"},
+	}
+
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 2; i++ {
+		e.Collect(stats.Stat{
+			Time:        start,
+			OpenSockets: openSockets,
+			Utilization: capture.Utilization{
+				nginx: {Interface: "eth0", UploadBytes: 100, UploadPackets: 1},
+				curl:  {Interface: "eth0", DownloadBytes: 10, DownloadPackets: 1},
+			},
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+
+	assert.Contains(t, body, `sniffer_process_bytes_total{process="nginx",pid="1",direction="upload"} 200`)
+	assert.Contains(t, body, `sniffer_process_bytes_total{process="other",pid="",direction="download"} 20`)
+	assert.Contains(t, body, `sniffer_remote_packets_total{addr="other",direction="download"} 2`)
+	assert.Contains(t, body, `sniffer_interface_bytes_total{interface="eth0",direction="download"} 20`)
+	assert.NotContains(t, body, `process="curl"`)
+
+	// The exported series keep their slots while curl outgrows nginx, once nginx is idle
+	// curl takes the slot and counts from then on.
+	collect := func(t time.Time, utilization capture.Utilization) string {
+		e.Collect(stats.Stat{Time: t, OpenSockets: openSockets, Utilization: utilization})
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		return rec.Body.String()
+	}
+	body = collect(start, capture.Utilization{curl: {Interface: "eth0", DownloadBytes: 1000, DownloadPackets: 1}})
+	assert.Contains(t, body, `sniffer_process_bytes_total{process="nginx",pid="1",direction="upload"} 200`)
+	assert.Contains(t, body, `sniffer_process_bytes_total{process="other",pid="",direction="download"} 1020`)
+
+	body = collect(start.Add(exporterIdleTimeout+time.Second), capture.Utilization{curl: {Interface: "eth0", DownloadBytes: 5, DownloadPackets: 1}})
+	assert.NotContains(t, body, `process="nginx"`)
+	assert.Contains(t, body, `sniffer_process_bytes_total{process="curl",pid="2",direction="download"} 5`)
+	assert.Contains(t, body, `sniffer_process_bytes_total{process="other",pid="",direction="download"} 1020`)
+}
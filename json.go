@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+
+	"github.com/chenjiandongx/sniffer/pkg/capture"
+	"github.com/chenjiandongx/sniffer/pkg/stats"
+)
+
+type jsonTraffic struct {
+	UploadBytes     int `json:"upload_bytes"`
+	DownloadBytes   int `json:"download_bytes"`
+	UploadPackets   int `json:"upload_packets"`
+	DownloadPackets int `json:"download_packets"`
+}
+
+type jsonTotals struct {
+	jsonTraffic
+	Connections int `json:"connections"`
+}
+
+type jsonProcess struct {
+	Name string `json:"name"`
+	jsonTraffic
+	Connections int `json:"connections"`
+}
+
+type jsonRemoteAddr struct {
+	Addr string `json:"addr"`
+	jsonTraffic
+	Connections int `json:"connections"`
+}
+
+type jsonContainer struct {
+	Container string `json:"container"`
+	jsonTraffic
+	Connections int `json:"connections"`
+}
+
+type jsonConnection struct {
+	Interface  string           `json:"interface"`
+	Protocol   capture.Protocol `json:"protocol"`
+	LocalIP    string           `json:"local_ip"`
+	LocalPort  uint16           `json:"local_port"`
+	RemoteIP   string           `json:"remote_ip"`
+	RemotePort uint16           `json:"remote_port"`
+	RemoteName string           `json:"remote_name"`
+	Process    string           `json:"process"`
+	jsonTraffic
+}
+
+type jsonSnapshot struct {
+	Time        time.Time        `json:"time"`
+	Interval    int              `json:"interval"`
+	Totals      jsonTotals       `json:"totals"`
+	Processes   []jsonProcess    `json:"processes"`
+	RemoteAddrs []jsonRemoteAddr `json:"remote_addrs"`
+	Containers  []jsonContainer  `json:"containers"`
+	Connections []jsonConnection `json:"connections"`
+}
+
+// JSONWriter writes each Snapshot as one JSON object per line. The traffic of processes,
+// remote addresses, containers and connections are the rates per second in the interval.
+type JSONWriter struct {
+	w        io.WriteCloser
+	encoder  *json.Encoder
+	interval int
+}
+
+// NewJSONWriter creates the JSONWriter which writes to the file in the append mode, or
+// the stdout if the name is "-".
+func NewJSONWriter(name string, opt Options) (*JSONWriter, error) {
+	var w io.WriteCloser = os.Stdout
+	if name != "-" {
+		f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		w = f
+	}
+
+	return &JSONWriter{
+		w:        w,
+		encoder:  json.NewEncoder(w),
+		interval: opt.Interval,
+	}, nil
+}
+
+func (jw *JSONWriter) Report(snapshot *stats.Snapshot) error {
+	output := jsonSnapshot{
+		Time:     snapshot.Time,
+		Interval: jw.interval,
+		Totals: jsonTotals{
+			jsonTraffic: jsonTraffic{
+				UploadBytes:     snapshot.TotalUploadBytes,
+				DownloadBytes:   snapshot.TotalDownloadBytes,
+				UploadPackets:   snapshot.TotalUploadPackets,
+				DownloadPackets: snapshot.TotalDownloadPackets,
+			},
+			Connections: snapshot.TotalConnections,
+		},
+		Processes:   make([]jsonProcess, 0, len(snapshot.Processes)),
+		RemoteAddrs: make([]jsonRemoteAddr, 0, len(snapshot.RemoteAddrs)),
+		Containers:  make([]jsonContainer, 0, len(snapshot.Containers)),
+		Connections: make([]jsonConnection, 0, len(snapshot.Connections)),
+	}
+
+	for _, r := range snapshot.TopNProcesses(len(snapshot.Processes), stats.OrderBytes) {
+		output.Processes = append(output.Processes, jsonProcess{
+			Name:        r.ProcessName,
+			jsonTraffic: newJSONTraffic(r.Data),
+			Connections: r.Data.ConnCount,
+		})
+	}
+
+	for _, r := range snapshot.TopNRemoteAddrs(len(snapshot.RemoteAddrs), stats.OrderBytes) {
+		output.RemoteAddrs = append(output.RemoteAddrs, jsonRemoteAddr{
+			Addr:        r.Addr,
+			jsonTraffic: newJSONTraffic(r.Data),
+			Connections: r.Data.ConnCount,
+		})
+	}
+
+	for _, r := range snapshot.TopNContainers(len(snapshot.Containers), stats.OrderBytes) {
+		output.Containers = append(output.Containers, jsonContainer{
+			Container:   r.Container,
+			jsonTraffic: newJSONTraffic(r.Data),
+			Connections: r.Data.ConnCount,
+		})
+	}
+
+	for _, r := range snapshot.TopNConnections(len(snapshot.Connections), stats.OrderBytes) {
+		output.Connections = append(output.Connections, jsonConnection{
+			Interface:  r.Data.InterfaceName,
+			Protocol:   r.Conn.Local.Protocol,
+			LocalIP:    r.Conn.Local.IP,
+			LocalPort:  r.Conn.Local.Port,
+			RemoteIP:   r.Conn.Remote.IP,
+			RemotePort: r.Conn.Remote.Port,
+			RemoteName: r.Data.RemoteName,
+			Process:    r.Data.ProcessName,
+			jsonTraffic: jsonTraffic{
+				UploadBytes:     r.Data.UploadBytes,
+				DownloadBytes:   r.Data.DownloadBytes,
+				UploadPackets:   r.Data.UploadPackets,
+				DownloadPackets: r.Data.DownloadPackets,
+			},
+		})
+	}
+
+	return jw.encoder.Encode(output)
+}
+
+func newJSONTraffic(data *stats.NetworkData) jsonTraffic {
+	return jsonTraffic{
+		UploadBytes:     data.UploadBytes,
+		DownloadBytes:   data.DownloadBytes,
+		UploadPackets:   data.UploadPackets,
+		DownloadPackets: data.DownloadPackets,
+	}
+}
+
+func (jw *JSONWriter) Close() error {
+	if jw.w == os.Stdout {
+		return nil
+	}
+	return jw.w.Close()
+}
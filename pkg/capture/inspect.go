@@ -0,0 +1,195 @@
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+)
+
+const (
+	// inspectMaxPackets is the number of the upload packets with payload to inspect per
+	// connection before giving up.
+	inspectMaxPackets = 4
+
+	// inspectMaxBytes bounds the payload buffered for a ClientHello spanning segments.
+	inspectMaxBytes = 16 * 1024
+
+	// inspectKeepIntervals is the number of the idle intervals to keep the names for.
+	inspectKeepIntervals = 60
+)
+
+var httpMethods = [][]byte{
+	[]byte("GET "), []byte("POST "), []byte("PUT "), []byte("HEAD "), []byte("DELETE "),
+	[]byte("OPTIONS "), []byte("PATCH "), []byte("CONNECT "), []byte("TRACE "),
+}
+
+// serverNameState tracks the inspection of the first upload packets of a connection.
+type serverNameState struct {
+	name       string
+	buf        []byte
+	packets    int
+	done       bool
+	generation int
+}
+
+// feed inspects the upload payload and returns the server name found so far.
+func (s *serverNameState) feed(payload []byte) string {
+	if s.done || len(payload) == 0 {
+		return s.name
+	}
+
+	s.packets++
+	if len(s.buf)+len(payload) > inspectMaxBytes {
+		payload = payload[:inspectMaxBytes-len(s.buf)]
+	}
+	// The payload may point to the ring buffer of the capture, copy it.
+	s.buf = append(s.buf, payload...)
+
+	name, more := parseServerName(s.buf)
+	if name != "" || !more || s.packets >= inspectMaxPackets || len(s.buf) >= inspectMaxBytes {
+		s.name, s.done, s.buf = name, true, nil
+	}
+	return s.name
+}
+
+// parseServerName extracts the TLS SNI or the HTTP/1.x Host from the first bytes sent by
+// the client. more is true if the data is a prefix of a message which may carry one.
+func parseServerName(data []byte) (name string, more bool) {
+	if len(data) == 0 {
+		return "", true
+	}
+	if data[0] == 0x16 {
+		return parseTLSServerName(data)
+	}
+	return parseHTTPHost(data)
+}
+
+// parseTLSServerName parses the server_name extension of the ClientHello in the first
+// TLS record, RFC 8446 4.1.2 and RFC 6066 3.
+func parseTLSServerName(data []byte) (string, bool) {
+	// ContentType(1) | ProtocolVersion(2) | Length(2)
+	if len(data) < 5 {
+		return "", true
+	}
+	recordLen := int(binary.BigEndian.Uint16(data[3:5]))
+	if len(data) < 5+recordLen {
+		return "", true
+	}
+
+	r := tlsReader(data[5 : 5+recordLen])
+	// HandshakeType(1) | Length(3) | ProtocolVersion(2) | Random(32)
+	if typ, ok := r.next(1); !ok || typ[0] != 0x01 {
+		return "", false
+	}
+	if _, ok := r.next(3 + 2 + 32); !ok {
+		return "", false
+	}
+	// legacy_session_id<0..32> | cipher_suites<2..2^16-2> | legacy_compression_methods<1..2^8-1>
+	for _, lenBytes := range []int{1, 2, 1} {
+		if _, ok := r.vector(lenBytes); !ok {
+			return "", false
+		}
+	}
+
+	extensions, ok := r.vector(2)
+	if !ok {
+		return "", false
+	}
+	for len(extensions) > 0 {
+		typ, ok := extensions.next(2)
+		if !ok {
+			return "", false
+		}
+		ext, ok := extensions.vector(2)
+		if !ok {
+			return "", false
+		}
+		if binary.BigEndian.Uint16(typ) != 0x0000 {
+			continue
+		}
+
+		// ServerNameList: server_name_list<1..2^16-1> of NameType(1) | HostName<1..2^16-1>
+		names, ok := ext.vector(2)
+		if !ok {
+			return "", false
+		}
+		for len(names) > 0 {
+			nameType, ok := names.next(1)
+			if !ok {
+				return "", false
+			}
+			host, ok := names.vector(2)
+			if !ok {
+				return "", false
+			}
+			if nameType[0] == 0x00 {
+				return string(host), false
+			}
+		}
+	}
+	return "", false
+}
+
+type tlsReader []byte
+
+func (r *tlsReader) next(n int) ([]byte, bool) {
+	if len(*r) < n {
+		return nil, false
+	}
+	b := (*r)[:n]
+	*r = (*r)[n:]
+	return b, true
+}
+
+// vector reads the variable-length vector prefixed by its length in lenBytes.
+func (r *tlsReader) vector(lenBytes int) (tlsReader, bool) {
+	b, ok := r.next(lenBytes)
+	if !ok {
+		return nil, false
+	}
+
+	var n int
+	for _, v := range b {
+		n = n<<8 | int(v)
+	}
+	v, ok := r.next(n)
+	return tlsReader(v), ok
+}
+
+// parseHTTPHost parses the Host header of the HTTP/1.x request, the port is stripped.
+func parseHTTPHost(data []byte) (string, bool) {
+	isRequest := false
+	for _, method := range httpMethods {
+		if bytes.HasPrefix(data, method) || bytes.HasPrefix(method, data) {
+			isRequest = true
+			break
+		}
+	}
+	if !isRequest {
+		return "", false
+	}
+
+	end := bytes.Index(data, []byte("\r\n\r\n"))
+	headers := data
+	if end >= 0 {
+		headers = data[:end]
+	}
+
+	for _, line := range bytes.Split(headers, []byte("\r\n"))[1:] {
+		colon := bytes.IndexByte(line, ':')
+		if colon < 0 || !bytes.EqualFold(bytes.TrimSpace(line[:colon]), []byte("Host")) {
+			continue
+		}
+		// The header is complete only if it is followed by another line.
+		if end < 0 && bytes.HasSuffix(headers, line) {
+			break
+		}
+
+		host := string(bytes.TrimSpace(line[colon+1:]))
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		return host, false
+	}
+	return "", end < 0
+}
@@ -0,0 +1,81 @@
+package capture
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// clientHello captures the first flight of a TLS client connecting to serverName.
+func clientHello(t *testing.T, serverName string) []byte {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	go func() {
+		conn := tls.Client(client, &tls.Config{ServerName: serverName})
+		conn.Handshake()
+		conn.Close()
+	}()
+
+	buf := make([]byte, 64*1024)
+	n, err := server.Read(buf)
+	assert.NoError(t, err)
+	return buf[:n]
+}
+
+func TestParseServerName(t *testing.T) {
+	hello := clientHello(t, "api.example.com")
+
+	tests := []struct {
+		name         string
+		data         []byte
+		expectedName string
+		expectedMore bool
+	}{
+		{name: "tls client hello", data: hello, expectedName: "api.example.com"},
+		{name: "tls partial record", data: hello[:len(hello)/2], expectedMore: true},
+		{name: "tls server hello", data: []byte{0x16, 0x03, 0x03, 0x00, 0x04, 0x02, 0x00, 0x00, 0x00}},
+		{
+			name:         "http request",
+			data:         []byte("GET / HTTP/1.1\r\nUser-Agent: curl\r\nhost: www.example.com:8080\r\n\r\n"),
+			expectedName: "www.example.com",
+		},
+		{name: "http partial method", data: []byte("PO"), expectedMore: true},
+		{name: "http partial host", data: []byte("GET / HTTP/1.1\r\nHost: www.exa"), expectedMore: true},
+		{name: "http without host", data: []byte("GET / HTTP/1.0\r\nAccept: */*\r\n\r\n")},
+		{name: "ssh", data: []byte("SSH-2.0-OpenSSH_9.0\r\n")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, more := parseServerName(tt.data)
+			assert.Equal(t, tt.expectedName, name)
+			assert.Equal(t, tt.expectedMore, more)
+		})
+	}
+}
+
+func TestSinkerServerName(t *testing.T) {
+	hello := clientHello(t, "api.example.com")
+	conn := Connection{
+		Local:  LocalSocket{IP: "10.0.0.1", Port: 50000, Protocol: ProtoTCP},
+		Remote: RemoteSocket{IP: "10.0.0.2", Port: 443},
+	}
+
+	sinker := NewSinker(true)
+	sinker.Fetch(Segment{Connection: conn, Direction: DirectionUpload})
+	sinker.Fetch(Segment{Connection: conn, Direction: DirectionUpload, Payload: hello[:10]})
+	sinker.Fetch(Segment{Connection: conn, Direction: DirectionDownload, Payload: []byte("ack")})
+	sinker.Fetch(Segment{Connection: conn, Direction: DirectionUpload, Payload: hello[10:]})
+	assert.Equal(t, "api.example.com", sinker.GetUtilization()[conn].ServerName)
+
+	// The name is kept for the later intervals of the connection.
+	sinker.Fetch(Segment{Connection: conn, Direction: DirectionDownload})
+	assert.Equal(t, "api.example.com", sinker.GetUtilization()[conn].ServerName)
+
+	disabled := NewSinker(false)
+	disabled.Fetch(Segment{Connection: conn, Direction: DirectionUpload, Payload: hello})
+	assert.Empty(t, disabled.GetUtilization()[conn].ServerName)
+}
@@ -0,0 +1,58 @@
+package capture
+
+import (
+	"time"
+
+	"github.com/chenjiandongx/sniffer/pkg/netns"
+)
+
+// Options is the options of the PcapClient and the ReplayClient.
+type Options struct {
+	// BPFFilter is the string pcap filter with the BPF syntax
+	// eg. "tcp and port 80"
+	BPFFilter string
+
+	// DevicesPrefix represents prefixed devices to monitor
+	DevicesPrefix []string
+
+	// AllDevices specifies whether to listen all devices or not
+	AllDevices bool
+
+	// Namespaces are the network namespaces listed by netns.List to capture in, the
+	// namespace of sniffer itself if empty. They are closed by the caller after the
+	// client, Linux only
+	Namespaces []netns.Namespace
+
+	// DisablePayloadInspect decides whether to disable extracting the TLS SNI and the
+	// HTTP Host from the first packets of the connections
+	DisablePayloadInspect bool
+
+	// CountLayer is the layer which the length of the packets is measured from,
+	// CountLayerL4 if empty
+	CountLayer CountLayer
+
+	// WriteFile is the pcapng file to record the captured packets into
+	WriteFile string
+
+	// WriteMaxSize is the size in MB to rotate the recording file, 0 means unlimited
+	WriteMaxSize int
+
+	// WriteRotateInterval is the age to rotate the recording file, 0 means unlimited
+	WriteRotateInterval time.Duration
+
+	// WriteMaxFiles is the number of the rotated recording files to keep, 0 means unlimited
+	WriteMaxFiles int
+
+	// Application is written into the section header of the recording files
+	Application string
+
+	// ReadFile is the pcap/pcapng capture file to replay
+	ReadFile string
+
+	// ReplayFast decides whether to replay the capture file as fast as possible
+	// rather than at the recorded speed
+	ReplayFast bool
+
+	// Interval is the length of the replayed buckets in seconds
+	Interval int
+}
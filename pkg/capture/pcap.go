@@ -0,0 +1,437 @@
+// Package capture captures the packets from the devices or replays them from the capture
+// files, and accumulates the traffic of the connections.
+package capture
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+)
+
+type RemoteSocket struct {
+	IP   string
+	Port uint16
+}
+
+type LocalSocket struct {
+	IP       string
+	Port     uint16
+	Protocol Protocol
+	// Netns labels the network namespace of the socket, it is empty for the namespace
+	// which sniffer runs in.
+	Netns string
+}
+
+type Connection struct {
+	Local  LocalSocket
+	Remote RemoteSocket
+}
+
+type Utilization map[Connection]*ConnectionInfo
+
+// DNSObserve receives the DNS responses sniffed by the capture clients.
+type DNSObserve func(msg *layers.DNS)
+
+type Protocol string
+
+const (
+	ProtoTCP Protocol = "tcp"
+	ProtoUDP Protocol = "udp"
+)
+
+// CountLayer is the layer which the length of the packets is measured from.
+type CountLayer string
+
+const (
+	// CountLayerL2 counts the frames on the wire including the link layer headers and
+	// the VLAN tags, which agrees with the interface counters of `ip -s link`.
+	CountLayerL2 CountLayer = "l2"
+
+	// CountLayerL3 counts the IP packets including the IP headers.
+	CountLayerL3 CountLayer = "l3"
+
+	// CountLayerL4 counts the TCP/UDP segments including their headers.
+	CountLayerL4 CountLayer = "l4"
+
+	// CountLayerPayload counts the TCP/UDP payload only.
+	CountLayerPayload CountLayer = "payload"
+)
+
+// Valid reports whether the layer is one of the known ones.
+func (l CountLayer) Valid() bool {
+	switch l {
+	case CountLayerL2, CountLayerL3, CountLayerL4, CountLayerPayload:
+		return true
+	}
+	return false
+}
+
+type Direction uint8
+
+const (
+	DirectionUpload Direction = iota
+	DirectionDownload
+)
+
+type ConnectionInfo struct {
+	Interface       string
+	UploadPackets   int
+	DownloadPackets int
+	UploadBytes     int
+	DownloadBytes   int
+
+	// ServerName is the TLS SNI or the HTTP Host which the local client asked for.
+	ServerName string
+
+	// Inbound is true if the TCP connection is seen opened by the remote.
+	Inbound bool
+}
+
+type Segment struct {
+	Interface string
+
+	// DataLen is the length of the packet measured from the CountLayer.
+	DataLen    int
+	Connection Connection
+	Direction  Direction
+
+	// Open is true for the TCP SYN without ACK which opens the connection.
+	Open bool
+
+	// Payload is the transport payload, it is only valid until the Sinker returns.
+	Payload []byte
+}
+
+type Sinker struct {
+	mut         sync.Mutex
+	utilization Utilization
+	inspect     bool
+	generation  int
+	serverNames map[Connection]*serverNameState
+
+	// inbound are the connections opened by the remote with the generation of their last
+	// packet, they are kept as long as the server names.
+	inbound map[Connection]int
+}
+
+// NewSinker creates the Sinker, the first upload packets of the TCP connections are
+// inspected for the server names if inspect is true.
+func NewSinker(inspect bool) *Sinker {
+	return &Sinker{
+		utilization: make(Utilization),
+		inspect:     inspect,
+		serverNames: make(map[Connection]*serverNameState),
+		inbound:     make(map[Connection]int),
+	}
+}
+
+func (c *Sinker) inspectServerName(seg Segment) string {
+	state, ok := c.serverNames[seg.Connection]
+	if !ok {
+		if seg.Direction != DirectionUpload || len(seg.Payload) == 0 {
+			return ""
+		}
+		state = &serverNameState{}
+		c.serverNames[seg.Connection] = state
+	}
+
+	state.generation = c.generation
+	if seg.Direction != DirectionUpload {
+		return state.name
+	}
+	return state.feed(seg.Payload)
+}
+
+func (c *Sinker) Fetch(seg Segment) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	if _, ok := c.utilization[seg.Connection]; !ok {
+		c.utilization[seg.Connection] = &ConnectionInfo{
+			Interface: seg.Interface,
+		}
+	}
+
+	switch seg.Direction {
+	case DirectionUpload:
+		c.utilization[seg.Connection].UploadBytes += seg.DataLen
+		c.utilization[seg.Connection].UploadPackets += 1
+
+	case DirectionDownload:
+		c.utilization[seg.Connection].DownloadBytes += seg.DataLen
+		c.utilization[seg.Connection].DownloadPackets += 1
+	}
+
+	if c.inspect && seg.Connection.Local.Protocol == ProtoTCP {
+		c.utilization[seg.Connection].ServerName = c.inspectServerName(seg)
+	}
+
+	if seg.Open {
+		if seg.Direction == DirectionDownload {
+			c.inbound[seg.Connection] = c.generation
+		} else {
+			delete(c.inbound, seg.Connection)
+		}
+	}
+	if _, ok := c.inbound[seg.Connection]; ok {
+		c.inbound[seg.Connection] = c.generation
+		c.utilization[seg.Connection].Inbound = true
+	}
+}
+
+func (c *Sinker) GetUtilization() Utilization {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	utilization := c.utilization
+	c.utilization = make(Utilization)
+
+	c.generation++
+	for conn, state := range c.serverNames {
+		if c.generation-state.generation > inspectKeepIntervals {
+			delete(c.serverNames, conn)
+		}
+	}
+	for conn, generation := range c.inbound {
+		if c.generation-generation > inspectKeepIntervals {
+			delete(c.inbound, conn)
+		}
+	}
+	return utilization
+}
+
+// packetMeta is the summary of the network and transport layers of a packet.
+type packetMeta struct {
+	SrcIP    string
+	DstIP    string
+	SrcPort  uint16
+	DstPort  uint16
+	Protocol Protocol
+
+	// FrameLen and NetworkLen are the lengths of the frame and the IP packet on the
+	// wire, DataLen is the length of the captured TCP/UDP segment.
+	FrameLen   int
+	NetworkLen int
+	DataLen    int
+	Payload    []byte
+
+	// Open is true for the TCP SYN without ACK.
+	Open bool
+}
+
+// length returns the length of the packet measured from the layer.
+func (m packetMeta) length(layer CountLayer) int {
+	switch layer {
+	case CountLayerL2:
+		return m.FrameLen
+	case CountLayerL3:
+		return m.NetworkLen
+	case CountLayerPayload:
+		return len(m.Payload)
+	}
+	return m.DataLen
+}
+
+// parseLayers extracts the packetMeta from the decoded layers of the frame whose length
+// on the wire is frameLen, false is returned if the packet is not a TCP/UDP one.
+func parseLayers(decoded []gopacket.Layer, frameLen int) (packetMeta, bool) {
+	meta := packetMeta{FrameLen: frameLen}
+	for _, layerType := range decoded {
+		switch lyr := layerType.(type) {
+		case *layers.IPv4:
+			meta.SrcIP = lyr.SrcIP.String()
+			meta.DstIP = lyr.DstIP.String()
+			if meta.NetworkLen == 0 {
+				meta.NetworkLen = int(lyr.Length)
+			}
+
+		case *layers.IPv6:
+			meta.SrcIP = lyr.SrcIP.String()
+			meta.DstIP = lyr.DstIP.String()
+			if meta.NetworkLen == 0 {
+				meta.NetworkLen = ipv6Length(lyr)
+			}
+
+		case *layers.TCP:
+			meta.Protocol = ProtoTCP
+			meta.SrcPort = parsePort(lyr.SrcPort.String())
+			meta.DstPort = parsePort(lyr.DstPort.String())
+			meta.DataLen = len(lyr.Contents) + len(lyr.Payload)
+			meta.Payload = lyr.Payload
+			meta.Open = lyr.SYN && !lyr.ACK
+
+		case *layers.UDP:
+			meta.Protocol = ProtoUDP
+			meta.SrcPort = parsePort(lyr.SrcPort.String())
+			meta.DstPort = parsePort(lyr.DstPort.String())
+			meta.DataLen = len(lyr.Contents) + len(lyr.Payload)
+			meta.Payload = lyr.Payload
+		}
+	}
+
+	// unknown packets, skip it.
+	if meta.Protocol == "" || meta.SrcIP == "" {
+		return meta, false
+	}
+	return meta, true
+}
+
+// ipv6Length returns the length of the IPv6 packet including the fixed header, the length
+// of the jumbogram is not in the header but in the Hop-by-Hop option.
+func ipv6Length(ipv6 *layers.IPv6) int {
+	if ipv6.Length == 0 && ipv6.HopByHop != nil {
+		return len(ipv6.Contents) + len(ipv6.HopByHop.Contents) + len(ipv6.Payload)
+	}
+	return len(ipv6.Contents) + int(ipv6.Length)
+}
+
+// toSegment builds the Segment seen from the local side. The remote IP is kept as is,
+// it is resolved to the domain on display so that the capture never waits for DNS.
+func (m packetMeta) toSegment(device string, direction Direction, layer CountLayer) *Segment {
+	seg := &Segment{
+		Interface: device,
+		DataLen:   m.length(layer),
+		Direction: direction,
+		Payload:   m.Payload,
+		Open:      m.Open,
+	}
+
+	switch direction {
+	case DirectionUpload:
+		seg.Connection = Connection{
+			Local:  LocalSocket{IP: m.SrcIP, Port: m.SrcPort, Protocol: m.Protocol},
+			Remote: RemoteSocket{IP: m.DstIP, Port: m.DstPort},
+		}
+
+	case DirectionDownload:
+		seg.Connection = Connection{
+			Local:  LocalSocket{IP: m.DstIP, Port: m.DstPort, Protocol: m.Protocol},
+			Remote: RemoteSocket{IP: m.SrcIP, Port: m.SrcPort},
+		}
+	}
+
+	return seg
+}
+
+// observeDNS passes the DNS response carried by the packet to observe, the TCP segment
+// is skipped unless it holds a whole message.
+func (m packetMeta) observeDNS(observe DNSObserve) {
+	if observe == nil || m.SrcPort != 53 {
+		return
+	}
+
+	payload := m.Payload
+	if m.Protocol == ProtoTCP {
+		// Length(2) | Message, RFC 1035 4.2.2
+		if len(payload) < 2 || len(payload)-2 < int(binary.BigEndian.Uint16(payload)) {
+			return
+		}
+		payload = payload[2 : 2+binary.BigEndian.Uint16(payload)]
+	}
+
+	msg := &layers.DNS{}
+	if err := msg.DecodeFromBytes(payload, gopacket.NilDecodeFeedback); err != nil || !msg.QR {
+		return
+	}
+	observe(msg)
+}
+
+// GetUtilization returns the utilization since the last call.
+func (c *PcapClient) GetUtilization() Utilization {
+	return c.sinker.GetUtilization()
+}
+
+// record writes the packet into the recorder. The error is kept until a later packet is
+// written successfully, the value is only stored when it changes.
+func (c *PcapClient) record(device string, ci gopacket.CaptureInfo, data []byte) {
+	var msg string
+	if err := c.recorder.Write(device, ci, data); err != nil {
+		msg = "record packets: " + err.Error()
+	}
+	if prev, _ := c.recordErr.Load().(string); prev != msg {
+		c.recordErr.Store(msg)
+	}
+}
+
+// setBPFFilterError reports the device failing to set the BPF filter, along with the
+// ones failing to be restored to the previous filter.
+func setBPFFilterError(device string, err error, restoreErrs []string) error {
+	if len(restoreErrs) == 0 {
+		return fmt.Errorf("set the BPF filter of %s: %w", device, err)
+	}
+	return fmt.Errorf("set the BPF filter of %s: %w, restore the previous filter of %s", device, err, strings.Join(restoreErrs, ", "))
+}
+
+// RecordErr returns the error of writing the captured packets into the files, nil if
+// the latest packet is recorded.
+func (c *PcapClient) RecordErr() error {
+	if msg, _ := c.recordErr.Load().(string); msg != "" {
+		return errors.New(msg)
+	}
+	return nil
+}
+
+func (c *PcapClient) parsePacket(ph *pcapHandler, decoded []gopacket.Layer, frameLen int) *Segment {
+	meta, ok := parseLayers(decoded, frameLen)
+	if !ok {
+		return nil
+	}
+	meta.observeDNS(c.observeDNS)
+
+	// The addresses are local only to the namespace which they are bound in, eg. the IP of
+	// a pod is remote to the devices of the host.
+	direction := DirectionDownload
+	if c.bindIPs[ph.netns][meta.SrcIP] {
+		direction = DirectionUpload
+	}
+
+	seg := meta.toSegment(ph.device, direction, c.countLayer)
+	seg.Connection.Local.Netns = ph.netns
+	return seg
+}
+
+func ListAllDevices() ([]pcap.Interface, error) {
+	return pcap.FindAllDevs()
+}
+
+func listPrefixDevices(prefix []string, allowAll bool) ([]pcap.Interface, error) {
+	all, err := ListAllDevices()
+	if err != nil {
+		return nil, err
+	}
+
+	var devs []pcap.Interface
+	for _, device := range all {
+		if allowAll {
+			devs = append(devs, device)
+			continue
+		}
+
+		for _, pre := range prefix {
+			if strings.HasPrefix(device.Name, pre) {
+				devs = append(devs, device)
+				break
+			}
+		}
+	}
+
+	return devs, nil
+}
+
+func parsePort(s string) uint16 {
+	idx := strings.Index(s, "(")
+	if idx == -1 {
+		i, _ := strconv.Atoi(s)
+		return uint16(i)
+	}
+
+	i, _ := strconv.Atoi(s[:idx])
+	return uint16(i)
+}
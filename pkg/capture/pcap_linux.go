@@ -0,0 +1,396 @@
+//go:build linux
+// +build linux
+
+package capture
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/chenjiandongx/sniffer/pkg/netns"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/afpacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+	"golang.org/x/net/bpf"
+)
+
+type pcapHandler struct {
+	device string
+	netns  string
+	handle *afpacket.TPacket
+
+	// linkType is the link layer of the frames read from the handle, the AF_PACKET
+	// sockets deliver the Ethernet frames.
+	linkType layers.LinkType
+}
+
+type PcapClient struct {
+	ctx           context.Context
+	cancel        context.CancelFunc
+	bindIPs       map[string]map[string]bool
+	handlers      []*pcapHandler
+	bpfFilter     string
+	filterMut     sync.Mutex
+	sinker        *Sinker
+	devicesPrefix []string
+	allDevices    bool
+	namespaces    []netns.Namespace
+	wg            sync.WaitGroup
+	observeDNS    DNSObserve
+	countLayer    CountLayer
+	recorder      *Recorder
+	recordErr     atomic.Value
+}
+
+func NewPcapClient(observeDNS DNSObserve, opt Options) (*PcapClient, error) {
+	client := &PcapClient{
+		bindIPs:       make(map[string]map[string]bool),
+		sinker:        NewSinker(!opt.DisablePayloadInspect),
+		bpfFilter:     opt.BPFFilter,
+		devicesPrefix: opt.DevicesPrefix,
+		allDevices:    opt.AllDevices,
+		observeDNS:    observeDNS,
+		countLayer:    opt.CountLayer,
+	}
+
+	client.namespaces = opt.Namespaces
+	if len(client.namespaces) == 0 {
+		client.namespaces = []netns.Namespace{{}}
+	}
+
+	client.ctx, client.cancel = context.WithCancel(context.Background())
+	if err := client.getAvailableDevices(); err != nil {
+		return nil, err
+	}
+
+	if opt.WriteFile != "" {
+		var interfaces []RecordInterface
+		for _, handler := range client.handlers {
+			interfaces = append(interfaces, RecordInterface{Name: handler.device, LinkType: handler.linkType})
+		}
+
+		recorder, err := NewRecorder(interfaces, opt)
+		if err != nil {
+			client.Close()
+			return nil, err
+		}
+		client.recorder = recorder
+	}
+
+	for _, handler := range client.handlers {
+		go client.listen(handler)
+	}
+
+	return client, nil
+}
+
+func (c *PcapClient) getAvailableDevices() error {
+	for _, ns := range c.namespaces {
+		err := ns.Do(func() error { return c.getNamespaceDevices(ns) })
+		if err != nil && len(c.namespaces) == 1 {
+			return err
+		}
+	}
+
+	if len(c.handlers) == 0 {
+		return errors.New("no available devices found")
+	}
+	return nil
+}
+
+// getNamespaceDevices opens the handlers of the devices in the namespace, the devices
+// are labeled like <netns>/<device> unless it is the namespace of sniffer itself.
+func (c *PcapClient) getNamespaceDevices(ns netns.Namespace) error {
+	devs, err := listPrefixDevices(c.devicesPrefix, c.allDevices)
+	if err != nil {
+		return err
+	}
+
+	for _, device := range devs {
+		handler, err := c.getHandler(device.Name)
+		if err != nil {
+			continue
+		}
+
+		if c.bpfFilter != "" {
+			if err = c.setBPFFilter(handler, c.bpfFilter); err != nil {
+				continue
+			}
+		}
+
+		name := device.Name
+		if ns.Name != "" {
+			name = ns.Name + "/" + device.Name
+		}
+		c.handlers = append(c.handlers, &pcapHandler{device: name, netns: ns.Name, handle: handler, linkType: layers.LinkTypeEthernet})
+		if c.bindIPs[ns.Name] == nil {
+			c.bindIPs[ns.Name] = make(map[string]bool)
+		}
+		for _, addr := range device.Addresses {
+			c.bindIPs[ns.Name][addr.IP.String()] = true
+		}
+	}
+	return nil
+}
+
+func (c *PcapClient) getHandler(device string) (*afpacket.TPacket, error) {
+	return afpacket.NewTPacket(afpacket.OptInterface(device))
+}
+
+func (c *PcapClient) setBPFFilter(h *afpacket.TPacket, filter string) error {
+	bpfIns, err := compileBPFFilter(layers.LinkTypeEthernet, filter)
+	if err != nil {
+		return err
+	}
+	return h.SetBPF(bpfIns)
+}
+
+func compileBPFFilter(linkType layers.LinkType, filter string) ([]bpf.RawInstruction, error) {
+	pcapBPF, err := pcap.CompileBPFFilter(linkType, 65535, filter)
+	if err != nil {
+		return nil, err
+	}
+	var bpfIns []bpf.RawInstruction
+	for _, ins := range pcapBPF {
+		bpfIns = append(bpfIns, bpf.RawInstruction{
+			Op: ins.Code,
+			Jt: ins.Jt,
+			Jf: ins.Jf,
+			K:  ins.K,
+		})
+	}
+	return bpfIns, nil
+}
+
+// compileBPFFilters compiles the filter once for each link type of the handlers.
+func (c *PcapClient) compileBPFFilters(filter string) (map[layers.LinkType][]bpf.RawInstruction, error) {
+	compiled := make(map[layers.LinkType][]bpf.RawInstruction)
+	for _, handler := range c.handlers {
+		if _, ok := compiled[handler.linkType]; ok {
+			continue
+		}
+		bpfIns, err := compileBPFFilter(handler.linkType, filter)
+		if err != nil {
+			return nil, err
+		}
+		compiled[handler.linkType] = bpfIns
+	}
+	return compiled, nil
+}
+
+// SetBPFFilter replaces the BPF filter of all the handlers, the empty filter accepts all
+// the packets. Both the filter and the previous one are compiled for the link types of
+// all the handlers before any of them is changed, and the handlers are restored to the
+// previous filter if any of them fails, so that they always share the same one.
+func (c *PcapClient) SetBPFFilter(filter string) error {
+	c.filterMut.Lock()
+	defer c.filterMut.Unlock()
+
+	compiled, err := c.compileBPFFilters(filter)
+	if err != nil {
+		return err
+	}
+	previous, err := c.compileBPFFilters(c.bpfFilter)
+	if err != nil {
+		return err
+	}
+
+	for i, handler := range c.handlers {
+		if err := handler.handle.SetBPF(compiled[handler.linkType]); err != nil {
+			var restoreErrs []string
+			for _, applied := range c.handlers[:i] {
+				if err := applied.handle.SetBPF(previous[applied.linkType]); err != nil {
+					restoreErrs = append(restoreErrs, fmt.Sprintf("%s: %v", applied.device, err))
+				}
+			}
+			return setBPFFilterError(handler.device, err, restoreErrs)
+		}
+	}
+	c.bpfFilter = filter
+	return nil
+}
+
+// ipv6Transport walks through the IPv6 extension headers and returns the upper-layer
+// protocol with its payload. ok is false if the payload does not carry a transport
+// header, eg. a non-first fragment.
+func ipv6Transport(ipv6 *layers.IPv6) (proto layers.IPProtocol, payload []byte, ok bool) {
+	proto = ipv6.NextHeader
+	payload = ipv6.Payload
+
+	// The Hop-by-Hop options header has been consumed by the IPv6 decoder already.
+	if ipv6.HopByHop != nil {
+		proto = ipv6.HopByHop.NextHeader
+	}
+
+	for {
+		switch proto {
+		case layers.IPProtocolIPv6HopByHop, layers.IPProtocolIPv6Routing, layers.IPProtocolIPv6Destination:
+			// Next Header(1) | Hdr Ext Len(1) | ... in 8-octet units, not including the first 8 octets.
+			if len(payload) < 8 {
+				return proto, nil, false
+			}
+			length := (int(payload[1]) + 1) * 8
+			if len(payload) < length {
+				return proto, nil, false
+			}
+			proto = layers.IPProtocol(payload[0])
+			payload = payload[length:]
+
+		case layers.IPProtocolIPv6Fragment:
+			// Next Header(1) | Reserved(1) | Fragment Offset(13 bits) Res(2 bits) M(1 bit) | Identification(4)
+			if len(payload) < 8 {
+				return proto, nil, false
+			}
+			if binary.BigEndian.Uint16(payload[2:4])>>3 != 0 {
+				return proto, nil, false
+			}
+			proto = layers.IPProtocol(payload[0])
+			payload = payload[8:]
+
+		default:
+			return proto, payload, true
+		}
+	}
+}
+
+// packetDecoder decodes the raw frames into the layers which parsePacket cares about.
+// The layers are reused between frames to avoid allocations in the capture loop.
+type packetDecoder struct {
+	ether   layers.Ethernet
+	dot1q   layers.Dot1Q
+	ipv4    layers.IPv4
+	ipv6    layers.IPv6
+	tcp     layers.TCP
+	udp     layers.UDP
+	decoded []gopacket.Layer
+}
+
+func newPacketDecoder() *packetDecoder {
+	return &packetDecoder{decoded: make([]gopacket.Layer, 0, 2)}
+}
+
+// Decode decodes the Ethernet frame and returns the network and transport layers of it.
+// nil is returned if the frame is not a TCP/UDP packet over IPv4 or IPv6.
+func (d *packetDecoder) Decode(pkt []byte) []gopacket.Layer {
+	d.decoded = d.decoded[:0]
+	if err := d.ether.DecodeFromBytes(pkt, gopacket.NilDecodeFeedback); err != nil {
+		return nil
+	}
+
+	// The VLAN tag is stripped by the kernel, only the inner ones of the stacked tags
+	// are left in the frame.
+	etherType, network := d.ether.EthernetType, d.ether.Payload
+	for etherType == layers.EthernetTypeDot1Q || etherType == layers.EthernetTypeQinQ {
+		if err := d.dot1q.DecodeFromBytes(network, gopacket.NilDecodeFeedback); err != nil {
+			return nil
+		}
+		etherType, network = d.dot1q.Type, d.dot1q.Payload
+	}
+
+	var proto layers.IPProtocol
+	var payload []byte
+
+	switch etherType {
+	case layers.EthernetTypeIPv4:
+		if err := d.ipv4.DecodeFromBytes(network, gopacket.NilDecodeFeedback); err != nil {
+			return nil
+		}
+		proto, payload = d.ipv4.Protocol, d.ipv4.Payload
+		d.decoded = append(d.decoded, &d.ipv4)
+
+	case layers.EthernetTypeIPv6:
+		if err := d.ipv6.DecodeFromBytes(network, gopacket.NilDecodeFeedback); err != nil {
+			return nil
+		}
+		var ok bool
+		if proto, payload, ok = ipv6Transport(&d.ipv6); !ok {
+			return nil
+		}
+		d.decoded = append(d.decoded, &d.ipv6)
+
+	default:
+		return nil
+	}
+
+	switch proto {
+	case layers.IPProtocolTCP:
+		if err := d.tcp.DecodeFromBytes(payload, gopacket.NilDecodeFeedback); err != nil {
+			return nil
+		}
+		d.decoded = append(d.decoded, &d.tcp)
+
+	case layers.IPProtocolUDP:
+		if err := d.udp.DecodeFromBytes(payload, gopacket.NilDecodeFeedback); err != nil {
+			return nil
+		}
+		d.decoded = append(d.decoded, &d.udp)
+
+	default:
+		return nil
+	}
+
+	return d.decoded
+}
+
+// frameLength returns the length of the frame on the wire, the VLAN tag stripped by the
+// kernel is added back as libpcap does.
+func frameLength(ci gopacket.CaptureInfo) int {
+	length := ci.Length
+	for _, data := range ci.AncillaryData {
+		if _, ok := data.(afpacket.AncillaryVLAN); ok {
+			length += 4
+		}
+	}
+	return length
+}
+
+func (c *PcapClient) listen(ph *pcapHandler) {
+	c.wg.Add(1)
+	defer c.wg.Done()
+
+	decoder := newPacketDecoder()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+
+		default:
+			pkt, ci, err := ph.handle.ZeroCopyReadPacketData()
+			if err != nil {
+				continue
+			}
+
+			if c.recorder != nil {
+				c.record(ph.device, ci, pkt)
+			}
+
+			decoded := decoder.Decode(pkt)
+			if decoded == nil {
+				continue
+			}
+
+			seg := c.parsePacket(ph, decoded, frameLength(ci))
+			if seg != nil {
+				c.sinker.Fetch(*seg)
+			}
+		}
+	}
+}
+
+func (c *PcapClient) Close() {
+	c.cancel()
+	c.wg.Wait()
+
+	for _, handler := range c.handlers {
+		handler.handle.Close()
+	}
+
+	if c.recorder != nil {
+		c.recorder.Close()
+	}
+}
@@ -0,0 +1,314 @@
+//go:build linux
+// +build linux
+
+package capture
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/afpacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	localIPv6  = net.ParseIP("2001:db8::1")
+	remoteIPv6 = net.ParseIP("2001:db8::2")
+)
+
+func serializeIPv6Packet(t *testing.T, nextHeader layers.IPProtocol, extHeaders []byte, transport gopacket.SerializableLayer) []byte {
+	buf := gopacket.NewSerializeBuffer()
+	err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, transport, gopacket.Payload("hello"))
+	assert.NoError(t, err)
+	payload := append(extHeaders, buf.Bytes()...)
+
+	buf = gopacket.NewSerializeBuffer()
+	err = gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true},
+		&layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 1},
+			DstMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 2},
+			EthernetType: layers.EthernetTypeIPv6,
+		},
+		&layers.IPv6{
+			Version:    6,
+			NextHeader: nextHeader,
+			HopLimit:   64,
+			SrcIP:      localIPv6,
+			DstIP:      remoteIPv6,
+		},
+		gopacket.Payload(payload),
+	)
+	assert.NoError(t, err)
+	return buf.Bytes()
+}
+
+func newTestPcapClient() *PcapClient {
+	return &PcapClient{
+		bindIPs: map[string]map[string]bool{"": {localIPv6.String(): true}},
+	}
+}
+
+func TestParseIPv6Packet(t *testing.T) {
+	tcp := &layers.TCP{SrcPort: 40000, DstPort: 443}
+	udp := &layers.UDP{SrcPort: 50000, DstPort: 53}
+
+	tests := []struct {
+		name       string
+		nextHeader layers.IPProtocol
+		extHeaders []byte
+		transport  gopacket.SerializableLayer
+		expected   *Segment
+	}{
+		{
+			name:       "tcp",
+			nextHeader: layers.IPProtocolTCP,
+			transport:  tcp,
+			expected: &Segment{
+				Interface: "eth0",
+				DataLen:   25,
+				Direction: DirectionUpload,
+				Payload:   []byte("hello"),
+				Connection: Connection{
+					Local:  LocalSocket{IP: "2001:db8::1", Port: 40000, Protocol: ProtoTCP},
+					Remote: RemoteSocket{IP: "2001:db8::2", Port: 443},
+				},
+			},
+		},
+		{
+			name:       "udp",
+			nextHeader: layers.IPProtocolUDP,
+			transport:  udp,
+			expected: &Segment{
+				Interface: "eth0",
+				DataLen:   13,
+				Direction: DirectionUpload,
+				Payload:   []byte("hello"),
+				Connection: Connection{
+					Local:  LocalSocket{IP: "2001:db8::1", Port: 50000, Protocol: ProtoUDP},
+					Remote: RemoteSocket{IP: "2001:db8::2", Port: 53},
+				},
+			},
+		},
+		{
+			name:       "hop-by-hop, routing and destination options",
+			nextHeader: layers.IPProtocolIPv6HopByHop,
+			extHeaders: []byte{
+				// Hop-by-Hop: PadN option
+				byte(layers.IPProtocolIPv6Routing), 0, 1, 4, 0, 0, 0, 0,
+				// Routing: type 0, segments left 0, one reserved word and one address
+				byte(layers.IPProtocolIPv6Destination), 2, 0, 0, 0, 0, 0, 0,
+				0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 3,
+				// Destination options: PadN option
+				byte(layers.IPProtocolTCP), 0, 1, 4, 0, 0, 0, 0,
+			},
+			transport: tcp,
+			expected: &Segment{
+				Interface: "eth0",
+				DataLen:   25,
+				Direction: DirectionUpload,
+				Payload:   []byte("hello"),
+				Connection: Connection{
+					Local:  LocalSocket{IP: "2001:db8::1", Port: 40000, Protocol: ProtoTCP},
+					Remote: RemoteSocket{IP: "2001:db8::2", Port: 443},
+				},
+			},
+		},
+		{
+			name:       "first fragment",
+			nextHeader: layers.IPProtocolIPv6Fragment,
+			extHeaders: []byte{byte(layers.IPProtocolUDP), 0, 0x00, 0x01, 0, 0, 0, 1},
+			transport:  udp,
+			expected: &Segment{
+				Interface: "eth0",
+				DataLen:   13,
+				Direction: DirectionUpload,
+				Payload:   []byte("hello"),
+				Connection: Connection{
+					Local:  LocalSocket{IP: "2001:db8::1", Port: 50000, Protocol: ProtoUDP},
+					Remote: RemoteSocket{IP: "2001:db8::2", Port: 53},
+				},
+			},
+		},
+		{
+			name:       "non-first fragment",
+			nextHeader: layers.IPProtocolIPv6Fragment,
+			extHeaders: []byte{byte(layers.IPProtocolUDP), 0, 0x00, 0xb8, 0, 0, 0, 1},
+			transport:  udp,
+		},
+		{
+			name:       "truncated extension header",
+			nextHeader: layers.IPProtocolIPv6Routing,
+			extHeaders: []byte{byte(layers.IPProtocolTCP), 8, 0, 0, 0, 0, 0, 0},
+			transport:  tcp,
+		},
+	}
+
+	client := newTestPcapClient()
+	ph := &pcapHandler{device: "eth0"}
+	decoder := newPacketDecoder()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pkt := serializeIPv6Packet(t, tt.nextHeader, tt.extHeaders, tt.transport)
+			decoded := decoder.Decode(pkt)
+			if tt.expected == nil {
+				assert.Nil(t, decoded)
+				return
+			}
+
+			assert.Len(t, decoded, 2)
+			assert.Equal(t, tt.expected, client.parsePacket(ph, decoded, len(pkt)))
+		})
+	}
+}
+
+func TestParseIPv6PacketDownload(t *testing.T) {
+	ipv6 := &layers.IPv6{SrcIP: remoteIPv6, DstIP: localIPv6}
+	tcp := &layers.TCP{SrcPort: 443, DstPort: 40000}
+
+	seg := newTestPcapClient().parsePacket(&pcapHandler{device: "eth0"}, []gopacket.Layer{ipv6, tcp}, 0)
+	assert.Equal(t, &Segment{
+		Interface: "eth0",
+		Direction: DirectionDownload,
+		Connection: Connection{
+			Local:  LocalSocket{IP: "2001:db8::1", Port: 40000, Protocol: ProtoTCP},
+			Remote: RemoteSocket{IP: "2001:db8::2", Port: 443},
+		},
+	}, seg)
+}
+
+func TestCountLayer(t *testing.T) {
+	ether := func(etherType layers.EthernetType) *layers.Ethernet {
+		return &layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 1},
+			DstMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 2},
+			EthernetType: etherType,
+		}
+	}
+	ipv4 := &layers.IPv4{Version: 4, IHL: 5, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 2}}
+	ipv6 := &layers.IPv6{Version: 6, NextHeader: layers.IPProtocolIPv6HopByHop, HopLimit: 64, SrcIP: localIPv6, DstIP: remoteIPv6}
+	hopByHop := gopacket.Payload{byte(layers.IPProtocolUDP), 0, 1, 4, 0, 0, 0, 0}
+	tcp := &layers.TCP{SrcPort: 40000, DstPort: 443}
+	udp := &layers.UDP{SrcPort: 50000, DstPort: 53}
+
+	serialize := func(lyrs ...gopacket.SerializableLayer) []byte {
+		buf := gopacket.NewSerializeBuffer()
+		assert.NoError(t, gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, lyrs...))
+		return buf.Bytes()
+	}
+	ipv6Frame := func(payload []byte) []byte {
+		// The IPv6 layer fixes the next header to the one of the Hop-by-Hop option.
+		ipv6.Length = uint16(len(hopByHop) + len(payload))
+		return serialize(ether(layers.EthernetTypeIPv6), ipv6, append(hopByHop, payload...))
+	}
+
+	tests := []struct {
+		name string
+		// frame is seen by libpcap, the VLAN tag of it is stripped into the ancillary
+		// data by the kernel for AF_PACKET.
+		frame    []byte
+		stripped []byte
+		expected map[CountLayer]int
+	}{
+		{
+			name:     "tcp over vlan",
+			frame:    serialize(ether(layers.EthernetTypeDot1Q), &layers.Dot1Q{VLANIdentifier: 100, Type: layers.EthernetTypeIPv4}, ipv4, tcp, gopacket.Payload("GET / HTTP/1.1\r\n\r\n")),
+			stripped: serialize(ether(layers.EthernetTypeIPv4), ipv4, tcp, gopacket.Payload("GET / HTTP/1.1\r\n\r\n")),
+			expected: map[CountLayer]int{CountLayerL2: 76, CountLayerL3: 58, CountLayerL4: 38, CountLayerPayload: 18},
+		},
+		{
+			name:     "udp over ipv6 with hop-by-hop options",
+			frame:    ipv6Frame(serialize(udp, gopacket.Payload("hello"))),
+			expected: map[CountLayer]int{CountLayerL2: 75, CountLayerL3: 61, CountLayerL4: 13, CountLayerPayload: 5},
+		},
+		{
+			// The Ethernet layer pads the frame to the minimum size of 60 bytes.
+			name:     "padded short frame",
+			frame:    serialize(ether(layers.EthernetTypeIPv4), &layers.IPv4{Version: 4, IHL: 5, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 2}}, udp, gopacket.Payload("x")),
+			expected: map[CountLayer]int{CountLayerL2: 60, CountLayerL3: 29, CountLayerL4: 9, CountLayerPayload: 1},
+		},
+	}
+
+	ph := &pcapHandler{device: "eth0"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ci := gopacket.CaptureInfo{Length: len(tt.frame)}
+			if tt.stripped != nil {
+				ci = gopacket.CaptureInfo{Length: len(tt.stripped), AncillaryData: []interface{}{afpacket.AncillaryVLAN{VLAN: 100}}}
+			}
+
+			for layer, expected := range tt.expected {
+				client := &PcapClient{bindIPs: map[string]map[string]bool{"": {"10.0.0.1": true, localIPv6.String(): true}}, countLayer: layer}
+
+				// AF_PACKET
+				pkt := tt.frame
+				if tt.stripped != nil {
+					pkt = tt.stripped
+				}
+				seg := client.parsePacket(ph, newPacketDecoder().Decode(pkt), frameLength(ci))
+				if assert.NotNil(t, seg) {
+					assert.Equal(t, expected, seg.DataLen, "afpacket %s", layer)
+				}
+
+				// libpcap
+				packet := gopacket.NewPacket(tt.frame, layers.LinkTypeEthernet, gopacket.Default)
+				seg = client.parsePacket(ph, packet.Layers(), len(tt.frame))
+				if assert.NotNil(t, seg) {
+					assert.Equal(t, expected, seg.DataLen, "libpcap %s", layer)
+				}
+			}
+		})
+	}
+}
+
+func TestParsePacketNamespaceBindIPs(t *testing.T) {
+	// 10.0.0.1 is the IP of a pod, it is local inside the pod but remote to the host.
+	buf := gopacket.NewSerializeBuffer()
+	err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true},
+		&layers.Ethernet{SrcMAC: net.HardwareAddr{0, 0, 0, 0, 0, 1}, DstMAC: net.HardwareAddr{0, 0, 0, 0, 0, 2}, EthernetType: layers.EthernetTypeIPv4},
+		&layers.IPv4{Version: 4, IHL: 5, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 2}},
+		&layers.TCP{SrcPort: 40000, DstPort: 443},
+	)
+	assert.NoError(t, err)
+
+	client := &PcapClient{bindIPs: map[string]map[string]bool{
+		"":        {"10.0.0.2": true},
+		"netns:1": {"10.0.0.1": true},
+	}}
+	pod := client.parsePacket(&pcapHandler{device: "netns:1/eth0", netns: "netns:1"}, newPacketDecoder().Decode(buf.Bytes()), len(buf.Bytes()))
+	host := client.parsePacket(&pcapHandler{device: "veth0"}, newPacketDecoder().Decode(buf.Bytes()), len(buf.Bytes()))
+	if assert.NotNil(t, pod) && assert.NotNil(t, host) {
+		assert.Equal(t, DirectionUpload, pod.Direction)
+		assert.Equal(t, LocalSocket{IP: "10.0.0.1", Port: 40000, Protocol: ProtoTCP, Netns: "netns:1"}, pod.Connection.Local)
+		assert.Equal(t, DirectionDownload, host.Direction)
+		assert.Equal(t, LocalSocket{IP: "10.0.0.2", Port: 443, Protocol: ProtoTCP}, host.Connection.Local)
+	}
+}
+
+func TestSetBPFFilter(t *testing.T) {
+	err := setBPFFilterError("eth1", errors.New("invalid argument"), []string{"eth0: bad file descriptor"})
+	assert.EqualError(t, err, "set the BPF filter of eth1: invalid argument, restore the previous filter of eth0: bad file descriptor")
+
+	if _, err := compileBPFFilter(layers.LinkTypeEthernet, "tcp"); err != nil {
+		t.Skipf("libpcap is unavailable: %v", err)
+	}
+	handle, err := afpacket.NewTPacket(afpacket.OptInterface("lo"))
+	if err != nil {
+		t.Skipf("AF_PACKET is unavailable: %v", err)
+	}
+	defer handle.Close()
+
+	client := &PcapClient{
+		bpfFilter: "tcp",
+		handlers:  []*pcapHandler{{device: "lo", handle: handle, linkType: layers.LinkTypeEthernet}},
+	}
+	assert.NoError(t, client.SetBPFFilter("udp"))
+	assert.Equal(t, "udp", client.bpfFilter)
+
+	// The invalid filter is rejected before any handler is changed.
+	assert.Error(t, client.SetBPFFilter("udp and"))
+	assert.Equal(t, "udp", client.bpfFilter)
+}
@@ -0,0 +1,202 @@
+//go:build !linux
+// +build !linux
+
+package capture
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+)
+
+type pcapHandler struct {
+	device string
+	netns  string
+	handle *pcap.Handle
+}
+
+type PcapClient struct {
+	bindIPs       map[string]map[string]bool
+	handlers      []*pcapHandler
+	bpfFilter     string
+	filterMut     sync.Mutex
+	sinker        *Sinker
+	devicesPrefix []string
+	allDevices    bool
+	wg            sync.WaitGroup
+	observeDNS    DNSObserve
+	countLayer    CountLayer
+	recorder      *Recorder
+	recordErr     atomic.Value
+}
+
+func NewPcapClient(observeDNS DNSObserve, opt Options) (*PcapClient, error) {
+	client := &PcapClient{
+		bindIPs:       map[string]map[string]bool{"": {}},
+		handlers:      make([]*pcapHandler, 0),
+		sinker:        NewSinker(!opt.DisablePayloadInspect),
+		bpfFilter:     opt.BPFFilter,
+		devicesPrefix: opt.DevicesPrefix,
+		allDevices:    opt.AllDevices,
+		observeDNS:    observeDNS,
+		countLayer:    opt.CountLayer,
+	}
+
+	if err := client.getAvailableDevices(); err != nil {
+		return nil, err
+	}
+
+	if opt.WriteFile != "" {
+		var interfaces []RecordInterface
+		for _, handler := range client.handlers {
+			interfaces = append(interfaces, RecordInterface{Name: handler.device, LinkType: handler.handle.LinkType()})
+		}
+
+		recorder, err := NewRecorder(interfaces, opt)
+		if err != nil {
+			client.Close()
+			return nil, err
+		}
+		client.recorder = recorder
+	}
+
+	for _, handler := range client.handlers {
+		go client.listen(handler)
+	}
+
+	return client, nil
+}
+
+func (c *PcapClient) getAvailableDevices() error {
+	devs, err := listPrefixDevices(c.devicesPrefix, c.allDevices)
+	if err != nil {
+		return err
+	}
+
+	for _, device := range devs {
+		handler, err := c.getHandler(device.Name, c.bpfFilter)
+		if err != nil {
+			continue
+		}
+		c.handlers = append(c.handlers, &pcapHandler{
+			device: device.Name,
+			handle: handler,
+		})
+		for _, addr := range device.Addresses {
+			c.bindIPs[""][addr.IP.String()] = true
+		}
+	}
+
+	if len(c.handlers) == 0 {
+		return errors.New("no available devices found")
+	}
+
+	return nil
+}
+
+func (c *PcapClient) getHandler(device, filter string) (*pcap.Handle, error) {
+	handle, err := pcap.OpenLive(device, 65535, false, pcap.BlockForever)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.bpfFilter != "" {
+		if err := handle.SetBPFFilter(filter); err != nil {
+			handle.Close()
+			return nil, err
+		}
+	}
+
+	return handle, nil
+}
+
+// SetBPFFilter replaces the BPF filter of all the handlers, the empty filter accepts all
+// the packets. Both the filter and the previous one are compiled for the link types of
+// all the handlers before any of them is changed, and the handlers are restored to the
+// previous filter if any of them fails, so that they always share the same one.
+func (c *PcapClient) SetBPFFilter(filter string) error {
+	c.filterMut.Lock()
+	defer c.filterMut.Unlock()
+
+	compiled, err := c.compileBPFFilters(filter)
+	if err != nil {
+		return err
+	}
+	previous, err := c.compileBPFFilters(c.bpfFilter)
+	if err != nil {
+		return err
+	}
+
+	for i, handler := range c.handlers {
+		if err := handler.handle.SetBPFInstructionFilter(compiled[handler.handle.LinkType()]); err != nil {
+			var restoreErrs []string
+			for _, applied := range c.handlers[:i] {
+				if err := applied.handle.SetBPFInstructionFilter(previous[applied.handle.LinkType()]); err != nil {
+					restoreErrs = append(restoreErrs, fmt.Sprintf("%s: %v", applied.device, err))
+				}
+			}
+			return setBPFFilterError(handler.device, err, restoreErrs)
+		}
+	}
+	c.bpfFilter = filter
+	return nil
+}
+
+// compileBPFFilters compiles the filter once for each link type of the handlers.
+func (c *PcapClient) compileBPFFilters(filter string) (map[layers.LinkType][]pcap.BPFInstruction, error) {
+	compiled := make(map[layers.LinkType][]pcap.BPFInstruction)
+	for _, handler := range c.handlers {
+		linkType := handler.handle.LinkType()
+		if _, ok := compiled[linkType]; ok {
+			continue
+		}
+		bpfIns, err := pcap.CompileBPFFilter(linkType, handler.handle.SnapLen(), filter)
+		if err != nil {
+			return nil, err
+		}
+		compiled[linkType] = bpfIns
+	}
+	return compiled, nil
+}
+
+func (c *PcapClient) listen(ph *pcapHandler) {
+	c.wg.Add(1)
+	defer c.wg.Done()
+
+	packetSource := gopacket.NewPacketSource(ph.handle, ph.handle.LinkType())
+	packetSource.Lazy = true
+	packetSource.NoCopy = true
+
+	for {
+		select {
+		case packet, ok := <-packetSource.Packets():
+			if !ok {
+				return
+			}
+			if c.recorder != nil {
+				c.record(ph.device, packet.Metadata().CaptureInfo, packet.Data())
+			}
+			seg := c.parsePacket(ph, packet.Layers(), packet.Metadata().Length)
+			if seg == nil {
+				continue
+			}
+			c.sinker.Fetch(*seg)
+		}
+	}
+}
+
+func (c *PcapClient) Close() {
+	for _, handler := range c.handlers {
+		handler.handle.Close()
+	}
+	c.wg.Wait()
+
+	if c.recorder != nil {
+		c.recorder.Close()
+	}
+}
@@ -0,0 +1,90 @@
+package capture
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObserveDNS(t *testing.T) {
+	msg := &layers.DNS{
+		ID:      1,
+		QR:      true,
+		QDCount: 1,
+		Questions: []layers.DNSQuestion{
+			{Name: []byte("api.example.com"), Type: layers.DNSTypeA, Class: layers.DNSClassIN},
+		},
+	}
+	buf := gopacket.NewSerializeBuffer()
+	assert.NoError(t, msg.SerializeTo(buf, gopacket.SerializeOptions{FixLengths: true}))
+	payload := buf.Bytes()
+
+	tcpPayload := make([]byte, 2, 2+len(payload))
+	binary.BigEndian.PutUint16(tcpPayload, uint16(len(payload)))
+	tcpPayload = append(tcpPayload, payload...)
+
+	tests := []struct {
+		name     string
+		meta     packetMeta
+		expected bool
+	}{
+		{
+			name:     "udp",
+			meta:     packetMeta{Protocol: ProtoUDP, SrcPort: 53, DstPort: 40000, Payload: payload},
+			expected: true,
+		},
+		{
+			name:     "tcp",
+			meta:     packetMeta{Protocol: ProtoTCP, SrcPort: 53, DstPort: 40000, Payload: tcpPayload},
+			expected: true,
+		},
+		{
+			name: "truncated tcp",
+			meta: packetMeta{Protocol: ProtoTCP, SrcPort: 53, DstPort: 40000, Payload: tcpPayload[:len(tcpPayload)-1]},
+		},
+		{
+			name: "query",
+			meta: packetMeta{Protocol: ProtoUDP, SrcPort: 40000, DstPort: 53, Payload: payload},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var observed *layers.DNS
+			tt.meta.observeDNS(func(msg *layers.DNS) { observed = msg })
+			if !tt.expected {
+				assert.Nil(t, observed)
+				return
+			}
+			assert.Equal(t, "api.example.com", string(observed.Questions[0].Name))
+		})
+	}
+}
+
+func TestSinkerInbound(t *testing.T) {
+	inbound := Connection{
+		Local:  LocalSocket{IP: "10.0.0.1", Port: 80, Protocol: ProtoTCP},
+		Remote: RemoteSocket{IP: "10.0.0.2", Port: 50000},
+	}
+	outbound := Connection{
+		Local:  LocalSocket{IP: "10.0.0.1", Port: 50001, Protocol: ProtoTCP},
+		Remote: RemoteSocket{IP: "10.0.0.2", Port: 443},
+	}
+
+	sinker := NewSinker(false)
+	sinker.Fetch(Segment{Connection: inbound, Direction: DirectionDownload, Open: true})
+	sinker.Fetch(Segment{Connection: outbound, Direction: DirectionUpload, Open: true})
+	sinker.Fetch(Segment{Connection: outbound, Direction: DirectionDownload})
+	utilization := sinker.GetUtilization()
+	assert.True(t, utilization[inbound].Inbound)
+	assert.False(t, utilization[outbound].Inbound)
+
+	// The direction is kept for the later intervals until the connection is opened again.
+	sinker.Fetch(Segment{Connection: inbound, Direction: DirectionUpload})
+	assert.True(t, sinker.GetUtilization()[inbound].Inbound)
+	sinker.Fetch(Segment{Connection: inbound, Direction: DirectionUpload, Open: true})
+	assert.False(t, sinker.GetUtilization()[inbound].Inbound)
+}
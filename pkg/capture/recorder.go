@@ -0,0 +1,180 @@
+package capture
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+// RecordInterface is the capturing device which the recorded packets come from.
+type RecordInterface struct {
+	Name     string
+	LinkType layers.LinkType
+}
+
+// Recorder writes the captured packets into the pcapng files, one interface block is
+// written for each device. The file is rotated by size or age if either of them is
+// specified, and only the latest MaxFiles files are kept.
+type Recorder struct {
+	mut         sync.Mutex
+	path        string
+	interfaces  []RecordInterface
+	ifIndex     map[string]int
+	maxSize     int64
+	maxAge      time.Duration
+	maxFiles    int
+	application string
+	file        *os.File
+	writer      *pcapgo.NgWriter
+	written     int64
+	openedAt    time.Time
+	retryAt     time.Time
+	files       []string
+}
+
+func NewRecorder(interfaces []RecordInterface, opt Options) (*Recorder, error) {
+	r := &Recorder{
+		path:        opt.WriteFile,
+		interfaces:  interfaces,
+		ifIndex:     make(map[string]int),
+		maxSize:     int64(opt.WriteMaxSize) * 1024 * 1024,
+		maxAge:      opt.WriteRotateInterval,
+		maxFiles:    opt.WriteMaxFiles,
+		application: opt.Application,
+	}
+
+	for idx, intf := range interfaces {
+		r.ifIndex[intf.Name] = idx
+	}
+
+	if err := r.rotate(time.Now()); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Recorder) rotatable() bool {
+	return r.maxSize > 0 || r.maxAge > 0
+}
+
+// nextPath returns the path of the next file. The path is used as it is if rotation is
+// disabled, otherwise a timestamp suffix is appended to the file name.
+func (r *Recorder) nextPath(t time.Time) string {
+	if !r.rotatable() {
+		return r.path
+	}
+
+	ext := filepath.Ext(r.path)
+	base := strings.TrimSuffix(r.path, ext)
+	name := fmt.Sprintf("%s-%s%s", base, t.Format("20060102-150405"), ext)
+	for i := 1; ; i++ {
+		if _, err := os.Stat(name); os.IsNotExist(err) {
+			return name
+		}
+		name = fmt.Sprintf("%s-%s.%d%s", base, t.Format("20060102-150405"), i, ext)
+	}
+}
+
+func (r *Recorder) closeFile() error {
+	if r.file == nil {
+		return nil
+	}
+
+	err := r.writer.Flush()
+	if cerr := r.file.Close(); err == nil {
+		err = cerr
+	}
+	r.file, r.writer = nil, nil
+	return err
+}
+
+// rotate opens the next file before closing the current one, so that the packets keep
+// going into the current file if the next one cannot be opened.
+func (r *Recorder) rotate(t time.Time) error {
+	name := r.nextPath(t)
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+
+	var w *pcapgo.NgWriter
+	for idx, intf := range r.interfaces {
+		ngIntf := pcapgo.DefaultNgInterface
+		ngIntf.Name = intf.Name
+		ngIntf.LinkType = intf.LinkType
+
+		if idx == 0 {
+			options := pcapgo.DefaultNgWriterOptions
+			options.SectionInfo.Application = r.application
+			w, err = pcapgo.NewNgWriterInterface(f, ngIntf, options)
+		} else {
+			_, err = w.AddInterface(ngIntf)
+		}
+		if err != nil {
+			f.Close()
+			os.Remove(name)
+			return err
+		}
+	}
+
+	err = r.closeFile()
+	r.file, r.writer = f, w
+	r.written = 0
+	r.openedAt = t
+	r.files = append(r.files, name)
+
+	if r.maxFiles > 0 {
+		for len(r.files) > r.maxFiles {
+			os.Remove(r.files[0])
+			r.files = r.files[1:]
+		}
+	}
+	return err
+}
+
+// Write writes the packet data captured by the device. If the next file cannot be opened
+// the packets keep going into the current one, and the rotation is retried a second later.
+func (r *Recorder) Write(device string, ci gopacket.CaptureInfo, data []byte) error {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	idx, ok := r.ifIndex[device]
+	if !ok || r.writer == nil {
+		return nil
+	}
+
+	var rotateErr error
+	if r.rotatable() && r.written > 0 && !ci.Timestamp.Before(r.retryAt) {
+		if (r.maxSize > 0 && r.written >= r.maxSize) || (r.maxAge > 0 && ci.Timestamp.Sub(r.openedAt) >= r.maxAge) {
+			if rotateErr = r.rotate(ci.Timestamp); rotateErr != nil {
+				r.retryAt = ci.Timestamp.Add(time.Second)
+			}
+		}
+	}
+
+	ci.InterfaceIndex = idx
+	if ci.CaptureLength == 0 || ci.CaptureLength > len(data) {
+		ci.CaptureLength = len(data)
+	}
+	if err := r.writer.WritePacket(ci, data[:ci.CaptureLength]); err != nil {
+		return err
+	}
+
+	// Enhanced Packet Block: 32 bytes of fields plus the padded packet data.
+	r.written += int64(32 + (ci.CaptureLength+3)&^3)
+	return rotateErr
+}
+
+func (r *Recorder) Close() error {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	return r.closeFile()
+}
@@ -0,0 +1,98 @@
+package capture
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecorderRotate(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{}
+	opts.WriteFile = filepath.Join(dir, "dump.pcapng")
+	opts.WriteRotateInterval = time.Minute
+	opts.WriteMaxFiles = 2
+
+	interfaces := []RecordInterface{
+		{Name: "eth0", LinkType: layers.LinkTypeEthernet},
+		{Name: "lo", LinkType: layers.LinkTypeEthernet},
+	}
+	recorder, err := NewRecorder(interfaces, opts)
+	assert.NoError(t, err)
+
+	start := time.Now()
+	data := make([]byte, 60)
+	for i := 0; i < 3; i++ {
+		ci := gopacket.CaptureInfo{Timestamp: start.Add(time.Duration(i) * time.Minute), CaptureLength: len(data), Length: len(data)}
+		assert.NoError(t, recorder.Write("lo", ci, data))
+	}
+	assert.NoError(t, recorder.Close())
+
+	files, err := filepath.Glob(filepath.Join(dir, "dump-*.pcapng"))
+	assert.NoError(t, err)
+	assert.Len(t, files, 2)
+
+	f, err := os.Open(recorder.files[1])
+	assert.NoError(t, err)
+	defer f.Close()
+
+	r, err := pcapgo.NewNgReader(f, pcapgo.DefaultNgReaderOptions)
+	assert.NoError(t, err)
+
+	_, ci, err := r.ReadPacketData()
+	assert.NoError(t, err)
+	intf, err := r.Interface(ci.InterfaceIndex)
+	assert.NoError(t, err)
+	assert.Equal(t, "lo", intf.Name)
+	assert.Equal(t, 2, r.NInterfaces())
+}
+
+func TestRecorderRotateFailed(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{}
+	opts.WriteFile = filepath.Join(dir, "dump.pcapng")
+	opts.WriteRotateInterval = time.Minute
+
+	recorder, err := NewRecorder([]RecordInterface{{Name: "lo", LinkType: layers.LinkTypeEthernet}}, opts)
+	assert.NoError(t, err)
+	first := recorder.files[0]
+
+	// The next file cannot be created once the directory is gone.
+	recorder.path = filepath.Join(dir, "missing", "dump.pcapng")
+
+	start := time.Now()
+	data := make([]byte, 60)
+	write := func(offset time.Duration) error {
+		ci := gopacket.CaptureInfo{Timestamp: start.Add(offset), CaptureLength: len(data), Length: len(data)}
+		return recorder.Write("lo", ci, data)
+	}
+	assert.NoError(t, write(0))
+	assert.Error(t, write(time.Minute))
+	// The rotation is not retried within a second.
+	assert.NoError(t, write(time.Minute+time.Millisecond))
+	assert.Error(t, write(time.Minute+time.Second))
+	assert.NoError(t, recorder.Close())
+	assert.Equal(t, []string{first}, recorder.files)
+
+	f, err := os.Open(first)
+	assert.NoError(t, err)
+	defer f.Close()
+
+	r, err := pcapgo.NewNgReader(f, pcapgo.DefaultNgReaderOptions)
+	assert.NoError(t, err)
+
+	var packets int
+	for {
+		if _, _, err := r.ReadPacketData(); err != nil {
+			break
+		}
+		packets++
+	}
+	assert.Equal(t, 4, packets)
+}
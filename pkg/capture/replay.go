@@ -0,0 +1,230 @@
+package capture
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+// pcapngMagic is the block type of the pcapng Section Header Block.
+const pcapngMagic = 0x0A0D0D0A
+
+// Bucket is the utilization of the packets whose timestamps fall in (Time-Interval, Time].
+type Bucket struct {
+	Time        time.Time
+	Utilization Utilization
+}
+
+// ReplayClient reads the packets from a pcap/pcapng capture file and groups them into
+// buckets by the packet timestamps.
+type ReplayClient struct {
+	ctx        context.Context
+	cancel     context.CancelFunc
+	file       *os.File
+	reader     *pcapgo.Reader
+	ngReader   *pcapgo.NgReader
+	sinker     *Sinker
+	buckets    chan Bucket
+	interval   time.Duration
+	fast       bool
+	lastEmit   time.Time
+	bindIPs    map[string]bool
+	wg         sync.WaitGroup
+	observeDNS DNSObserve
+	countLayer CountLayer
+
+	// err is the error which ended the replay before the end of the capture file.
+	err error
+}
+
+// NewReplayClient creates the ReplayClient, the localIPs are the addresses of the host
+// where the capture file was recorded.
+func NewReplayClient(observeDNS DNSObserve, localIPs []string, opt Options) (*ReplayClient, error) {
+	client := &ReplayClient{
+		bindIPs:    make(map[string]bool),
+		sinker:     NewSinker(!opt.DisablePayloadInspect),
+		buckets:    make(chan Bucket),
+		interval:   time.Duration(opt.Interval) * time.Second,
+		fast:       opt.ReplayFast,
+		observeDNS: observeDNS,
+		countLayer: opt.CountLayer,
+	}
+
+	for _, ip := range localIPs {
+		client.bindIPs[ip] = true
+	}
+
+	if err := client.open(opt.ReadFile); err != nil {
+		return nil, err
+	}
+
+	client.ctx, client.cancel = context.WithCancel(context.Background())
+	client.wg.Add(1)
+	go client.replay()
+
+	return client, nil
+}
+
+func (c *ReplayClient) open(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(f)
+	magic, err := r.Peek(4)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("read capture file %s: %w", name, err)
+	}
+
+	// The block type of the Section Header Block is a palindrome, so the byte order
+	// does not matter here.
+	if binary.BigEndian.Uint32(magic) == pcapngMagic {
+		c.ngReader, err = pcapgo.NewNgReader(r, pcapgo.DefaultNgReaderOptions)
+	} else {
+		c.reader, err = pcapgo.NewReader(r)
+	}
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("read capture file %s: %w", name, err)
+	}
+
+	c.file = f
+	return nil
+}
+
+// Buckets returns the channel of the replayed buckets, it will be closed once the end
+// of the capture file is reached or the file fails to read, see Err.
+func (c *ReplayClient) Buckets() <-chan Bucket {
+	return c.buckets
+}
+
+// Err returns the error which ended the replay before the end of the capture file, such
+// as the file being truncated. It is valid once the channel of Buckets is closed.
+func (c *ReplayClient) Err() error {
+	return c.err
+}
+
+func (c *ReplayClient) readPacket() ([]byte, gopacket.CaptureInfo, layers.LinkType, string, error) {
+	if c.reader != nil {
+		data, ci, err := c.reader.ReadPacketData()
+		return data, ci, c.reader.LinkType(), "if0", err
+	}
+
+	data, ci, err := c.ngReader.ReadPacketData()
+	if err != nil {
+		return data, ci, 0, "", err
+	}
+
+	iface, err := c.ngReader.Interface(ci.InterfaceIndex)
+	if err != nil {
+		return data, ci, 0, "", err
+	}
+
+	device := iface.Name
+	if device == "" {
+		device = fmt.Sprintf("if%d", ci.InterfaceIndex)
+	}
+	return data, ci, iface.LinkType, device, nil
+}
+
+// direction decides the direction of the packet. The localIPs are treated as the local
+// ones, otherwise the side with the higher port is assumed to
+// be the local client.
+func (c *ReplayClient) direction(meta packetMeta) Direction {
+	switch {
+	case c.bindIPs[meta.SrcIP]:
+		return DirectionUpload
+	case c.bindIPs[meta.DstIP]:
+		return DirectionDownload
+	case meta.SrcPort > meta.DstPort:
+		return DirectionUpload
+	}
+	return DirectionDownload
+}
+
+func (c *ReplayClient) parsePacket(device string, decoded []gopacket.Layer, frameLen int) *Segment {
+	meta, ok := parseLayers(decoded, frameLen)
+	if !ok {
+		return nil
+	}
+
+	meta.observeDNS(c.observeDNS)
+	return meta.toSegment(device, c.direction(meta), c.countLayer)
+}
+
+func (c *ReplayClient) replay() {
+	defer c.wg.Done()
+	defer close(c.buckets)
+
+	var bucketEnd time.Time
+	for {
+		data, ci, linkType, device, err := c.readPacket()
+		if err != nil {
+			if err != io.EOF {
+				c.err = fmt.Errorf("read capture file: %w", err)
+			}
+			break
+		}
+
+		if bucketEnd.IsZero() {
+			bucketEnd = ci.Timestamp.Add(c.interval)
+		}
+		for !ci.Timestamp.Before(bucketEnd) {
+			if !c.emit(bucketEnd) {
+				return
+			}
+			bucketEnd = bucketEnd.Add(c.interval)
+		}
+
+		packet := gopacket.NewPacket(data, linkType, gopacket.DecodeOptions{Lazy: true, NoCopy: true})
+		seg := c.parsePacket(device, packet.Layers(), ci.Length)
+		if seg == nil {
+			continue
+		}
+		c.sinker.Fetch(*seg)
+	}
+
+	if !bucketEnd.IsZero() {
+		c.emit(bucketEnd)
+	}
+}
+
+// emit sends the bucket ended at t. In the recorded speed mode, it waits for an interval
+// since the previous bucket was consumed.
+func (c *ReplayClient) emit(t time.Time) bool {
+	if !c.fast && !c.lastEmit.IsZero() {
+		timer := time.NewTimer(time.Until(c.lastEmit.Add(c.interval)))
+		select {
+		case <-c.ctx.Done():
+			timer.Stop()
+			return false
+		case <-timer.C:
+		}
+	}
+
+	select {
+	case <-c.ctx.Done():
+		return false
+	case c.buckets <- Bucket{Time: t, Utilization: c.sinker.GetUtilization()}:
+	}
+
+	c.lastEmit = time.Now()
+	return true
+}
+
+func (c *ReplayClient) Close() {
+	c.cancel()
+	c.wg.Wait()
+	c.file.Close()
+}
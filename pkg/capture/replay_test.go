@@ -0,0 +1,109 @@
+package capture
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTestCapture(t *testing.T, name string, start time.Time) {
+	f, err := os.Create(name)
+	assert.NoError(t, err)
+	defer f.Close()
+
+	w := pcapgo.NewWriter(f)
+	assert.NoError(t, w.WriteFileHeader(65535, layers.LinkTypeEthernet))
+
+	offsets := []time.Duration{0, 500 * time.Millisecond, 2500 * time.Millisecond}
+	for _, offset := range offsets {
+		buf := gopacket.NewSerializeBuffer()
+		err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true},
+			&layers.Ethernet{
+				SrcMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 1},
+				DstMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 2},
+				EthernetType: layers.EthernetTypeIPv4,
+			},
+			&layers.IPv4{
+				Version:  4,
+				TTL:      64,
+				Protocol: layers.IPProtocolUDP,
+				SrcIP:    net.IPv4(10, 0, 0, 1),
+				DstIP:    net.IPv4(10, 0, 0, 2),
+			},
+			&layers.UDP{SrcPort: 50000, DstPort: 53},
+			gopacket.Payload("hello"),
+		)
+		assert.NoError(t, err)
+
+		data := buf.Bytes()
+		ci := gopacket.CaptureInfo{Timestamp: start.Add(offset), CaptureLength: len(data), Length: len(data)}
+		assert.NoError(t, w.WritePacket(ci, data))
+	}
+}
+
+func TestReplayBuckets(t *testing.T) {
+	dir := t.TempDir()
+	capture := filepath.Join(dir, "dump.pcap")
+	start := time.Unix(1600000000, 0)
+	writeTestCapture(t, capture, start)
+
+	opts := Options{
+		ReadFile:   capture,
+		ReplayFast: true,
+		Interval:   1,
+	}
+
+	client, err := NewReplayClient(nil, []string{"10.0.0.1"}, opts)
+	assert.NoError(t, err)
+	defer client.Close()
+
+	conn := Connection{
+		Local:  LocalSocket{IP: "10.0.0.1", Port: 50000, Protocol: ProtoUDP},
+		Remote: RemoteSocket{IP: "10.0.0.2", Port: 53},
+	}
+
+	var buckets []Bucket
+	for bucket := range client.Buckets() {
+		buckets = append(buckets, bucket)
+	}
+
+	assert.Len(t, buckets, 3)
+	assert.True(t, start.Add(time.Second).Equal(buckets[0].Time))
+	assert.Equal(t, 2, buckets[0].Utilization[conn].UploadPackets)
+	assert.Equal(t, 26, buckets[0].Utilization[conn].UploadBytes)
+	assert.Empty(t, buckets[1].Utilization)
+	assert.Equal(t, 1, buckets[2].Utilization[conn].UploadPackets)
+	assert.NoError(t, client.Err())
+}
+
+func TestReplayTruncated(t *testing.T) {
+	dir := t.TempDir()
+	capture := filepath.Join(dir, "dump.pcap")
+	start := time.Unix(1600000000, 0)
+	writeTestCapture(t, capture, start)
+
+	// The last packet is cut in the middle.
+	info, err := os.Stat(capture)
+	assert.NoError(t, err)
+	assert.NoError(t, os.Truncate(capture, info.Size()-10))
+
+	client, err := NewReplayClient(nil, []string{"10.0.0.1"}, Options{ReadFile: capture, ReplayFast: true, Interval: 1})
+	assert.NoError(t, err)
+	defer client.Close()
+
+	var buckets []Bucket
+	for bucket := range client.Buckets() {
+		buckets = append(buckets, bucket)
+	}
+
+	// The packets read before are still replayed.
+	assert.Len(t, buckets, 1)
+	assert.EqualError(t, client.Err(), "read capture file: unexpected EOF")
+}
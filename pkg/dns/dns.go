@@ -0,0 +1,223 @@
+// Package dns resolves the remote addresses to the domains.
+package dns
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+const (
+	dnsWorkers     = 4
+	dnsQueueSize   = 1024
+	dnsTimeout     = 2 * time.Second
+	dnsPositiveTTL = 10 * time.Minute
+	dnsNegativeTTL = 2 * time.Minute
+)
+
+type dnsEntry struct {
+	name     string
+	expireAt time.Time
+}
+
+// Resolver resolves the remote IPs to the domains in the background. Lookup never
+// blocks, it returns the cached name or the IP itself while the resolution is pending.
+// The failed resolutions are cached as the IP for a shorter while.
+//
+// The names queried by the applications are learnt from the sniffed DNS responses as
+// well, they take precedence over the PTR records which are usually missing or generic
+// for the cloud endpoints.
+type Resolver struct {
+	mut        sync.Mutex
+	passive    map[string]dnsEntry
+	cache      map[string]dnsEntry
+	pending    map[string]bool
+	queue      chan string
+	done       chan struct{}
+	wg         sync.WaitGroup
+	lookupAddr func(ctx context.Context, ip string) ([]string, error)
+}
+
+func NewResolver() *Resolver {
+	r := &Resolver{
+		passive:    make(map[string]dnsEntry),
+		cache:      make(map[string]dnsEntry),
+		pending:    make(map[string]bool),
+		queue:      make(chan string, dnsQueueSize),
+		done:       make(chan struct{}),
+		lookupAddr: net.DefaultResolver.LookupAddr,
+	}
+	r.start()
+	return r
+}
+
+func (c *Resolver) start() {
+	for i := 0; i < dnsWorkers; i++ {
+		c.wg.Add(1)
+		go c.work()
+	}
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		t := time.NewTicker(dnsNegativeTTL)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-c.done:
+				return
+			case now := <-t.C:
+				c.evict(now)
+			}
+		}
+	}()
+}
+
+func (c *Resolver) work() {
+	defer c.wg.Done()
+	for {
+		select {
+		case <-c.done:
+			return
+		case ip := <-c.queue:
+			name, ttl := c.resolve(ip)
+
+			c.mut.Lock()
+			c.cache[ip] = dnsEntry{name: name, expireAt: time.Now().Add(ttl)}
+			delete(c.pending, ip)
+			c.mut.Unlock()
+		}
+	}
+}
+
+func (c *Resolver) resolve(ip string) (string, time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), dnsTimeout)
+	defer cancel()
+
+	addrs, err := c.lookupAddr(ctx, ip)
+	if err != nil || len(addrs) == 0 {
+		return ip, dnsNegativeTTL
+	}
+
+	sort.Strings(addrs)
+	return addrs[0], dnsPositiveTTL
+}
+
+// evict drops the expired entries which have not been looked up again since.
+func (c *Resolver) evict(now time.Time) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	for ip, entry := range c.cache {
+		if now.After(entry.expireAt.Add(dnsPositiveTTL)) {
+			delete(c.cache, ip)
+		}
+	}
+	for ip, entry := range c.passive {
+		if now.After(entry.expireAt) {
+			delete(c.passive, ip)
+		}
+	}
+}
+
+type dnsAnswer struct {
+	IP   string
+	Name string
+	TTL  time.Duration
+}
+
+// parseDNSAnswers maps the addresses in the A/AAAA answers to the queried name, the
+// CNAME chain starting from the question is followed.
+func parseDNSAnswers(msg *layers.DNS) []dnsAnswer {
+	if len(msg.Questions) == 0 {
+		return nil
+	}
+
+	queried := strings.ToLower(string(msg.Questions[0].Name))
+	aliases := map[string]bool{queried: true}
+
+	var answers []dnsAnswer
+	for _, rr := range msg.Answers {
+		if !aliases[strings.ToLower(string(rr.Name))] {
+			continue
+		}
+
+		switch rr.Type {
+		case layers.DNSTypeCNAME:
+			aliases[strings.ToLower(string(rr.CNAME))] = true
+		case layers.DNSTypeA, layers.DNSTypeAAAA:
+			answers = append(answers, dnsAnswer{
+				IP:   rr.IP.String(),
+				Name: queried,
+				TTL:  time.Duration(rr.TTL) * time.Second,
+			})
+		}
+	}
+	return answers
+}
+
+// Observe learns the queried names from the DNS response. The names are kept for at
+// least dnsPositiveTTL since the connections usually outlive the records.
+func (c *Resolver) Observe(msg *layers.DNS) {
+	answers := parseDNSAnswers(msg)
+	if len(answers) == 0 {
+		return
+	}
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	now := time.Now()
+	for _, answer := range answers {
+		ttl := answer.TTL
+		if ttl < dnsPositiveTTL {
+			ttl = dnsPositiveTTL
+		}
+		c.passive[answer.IP] = dnsEntry{name: answer.Name, expireAt: now.Add(ttl)}
+	}
+}
+
+func (c *Resolver) Close() {
+	close(c.done)
+	c.wg.Wait()
+}
+
+// Lookup returns the learnt or the cached domain of the remote ip, the ip is queued to
+// resolve if it is unknown or expired, the stale cached name is kept until the resolution
+// finishes while the expired learnt name is dropped.
+func (c *Resolver) Lookup(ip string) string {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	if entry, ok := c.passive[ip]; ok {
+		if time.Now().Before(entry.expireAt) {
+			return entry.name
+		}
+		delete(c.passive, ip)
+	}
+
+	entry, ok := c.cache[ip]
+	if ok && time.Now().Before(entry.expireAt) {
+		return entry.name
+	}
+
+	if !c.pending[ip] {
+		select {
+		case c.queue <- ip:
+			c.pending[ip] = true
+		default:
+			// The queue is full, try again on the next lookup.
+		}
+	}
+
+	if ok {
+		return entry.name
+	}
+	return ip
+}
@@ -0,0 +1,84 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolverLookup(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	r := &Resolver{
+		passive: make(map[string]dnsEntry),
+		cache:   make(map[string]dnsEntry),
+		pending: make(map[string]bool),
+		queue:   make(chan string, dnsQueueSize),
+		done:    make(chan struct{}),
+		lookupAddr: func(ctx context.Context, ip string) ([]string, error) {
+			atomic.AddInt32(&calls, 1)
+			<-release
+			if ip == "10.0.0.1" {
+				return []string{"b.example.com.", "a.example.com."}, nil
+			}
+			return nil, errors.New("no such host")
+		},
+	}
+	r.start()
+	defer r.Close()
+
+	// The lookups return the IP immediately while the resolutions are blocked.
+	assert.Equal(t, "10.0.0.1", r.Lookup("10.0.0.1"))
+	assert.Equal(t, "10.0.0.2", r.Lookup("10.0.0.2"))
+	assert.Equal(t, "10.0.0.1", r.Lookup("10.0.0.1"))
+	close(release)
+
+	assert.Eventually(t, func() bool {
+		return r.Lookup("10.0.0.1") == "a.example.com."
+	}, time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		r.mut.Lock()
+		defer r.mut.Unlock()
+		_, ok := r.cache["10.0.0.2"]
+		return ok
+	}, time.Second, 10*time.Millisecond)
+
+	// The failed resolution is cached as the IP itself and not retried until it expires.
+	assert.Equal(t, "10.0.0.2", r.Lookup("10.0.0.2"))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestParseDNSAnswers(t *testing.T) {
+	msg := &layers.DNS{
+		QR: true,
+		Questions: []layers.DNSQuestion{
+			{Name: []byte("api.example.com"), Type: layers.DNSTypeA, Class: layers.DNSClassIN},
+		},
+		Answers: []layers.DNSResourceRecord{
+			{Name: []byte("api.example.com"), Type: layers.DNSTypeCNAME, Class: layers.DNSClassIN, TTL: 60, CNAME: []byte("lb.cloud.net")},
+			{Name: []byte("lb.cloud.net"), Type: layers.DNSTypeA, Class: layers.DNSClassIN, TTL: 60, IP: net.ParseIP("203.0.113.10").To4()},
+			{Name: []byte("lb.cloud.net"), Type: layers.DNSTypeAAAA, Class: layers.DNSClassIN, TTL: 60, IP: net.ParseIP("2001:db8::10")},
+			{Name: []byte("unrelated.net"), Type: layers.DNSTypeA, Class: layers.DNSClassIN, TTL: 60, IP: net.ParseIP("198.51.100.1").To4()},
+		},
+	}
+
+	assert.Equal(t, []dnsAnswer{
+		{IP: "203.0.113.10", Name: "api.example.com", TTL: time.Minute},
+		{IP: "2001:db8::10", Name: "api.example.com", TTL: time.Minute},
+	}, parseDNSAnswers(msg))
+
+	r := &Resolver{passive: make(map[string]dnsEntry)}
+	r.Observe(msg)
+	assert.Equal(t, "api.example.com", r.Lookup("203.0.113.10"))
+
+	// The expired name is not returned even before it is evicted.
+	r.passive["203.0.113.10"] = dnsEntry{name: "api.example.com", expireAt: time.Now().Add(-time.Second)}
+	assert.Equal(t, "203.0.113.10", r.Lookup("203.0.113.10"))
+	assert.NotContains(t, r.passive, "203.0.113.10")
+}
@@ -0,0 +1,3 @@
+// Package netns enters the Linux network namespaces, so that the packets and the sockets
+// inside them can be captured and fetched.
+package netns
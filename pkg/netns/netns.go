@@ -0,0 +1,19 @@
+package netns
+
+import "os"
+
+// Namespace is a network namespace which the packets are captured and the sockets are
+// fetched in.
+type Namespace struct {
+	// Name labels the namespace, it is empty for the namespace of sniffer itself.
+	Name string
+	file *os.File
+}
+
+// Close releases the namespace, it can not be entered any more.
+func (ns Namespace) Close() error {
+	if ns.file == nil {
+		return nil
+	}
+	return ns.file.Close()
+}
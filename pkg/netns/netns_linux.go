@@ -0,0 +1,125 @@
+//go:build linux
+// +build linux
+
+package netns
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Do runs fn on a thread which has entered the namespace. The sockets created in fn stay
+// in the namespace after it returns.
+func (ns Namespace) Do(fn func() error) error {
+	if ns.file == nil {
+		return fn()
+	}
+
+	runtime.LockOSThread()
+	origin, err := os.Open(fmt.Sprintf("/proc/self/task/%d/ns/net", unix.Gettid()))
+	if err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+	defer origin.Close()
+
+	if err = unix.Setns(int(ns.file.Fd()), unix.CLONE_NEWNET); err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("enter netns %s: %w", ns.Name, err)
+	}
+
+	fnErr := fn()
+
+	// The thread is left locked if it fails to switch back, so that it will be terminated
+	// instead of being reused by the other goroutines.
+	if err = unix.Setns(int(origin.Fd()), unix.CLONE_NEWNET); err != nil {
+		return fmt.Errorf("leave netns %s: %w", ns.Name, err)
+	}
+	runtime.UnlockOSThread()
+	return fnErr
+}
+
+func netnsInode(path string) (uint64, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	return fi.Sys().(*syscall.Stat_t).Ino, nil
+}
+
+// openNetNamespace opens the namespace specified by a path like /var/run/netns/<name>
+// or the pid of a process in it.
+func openNetNamespace(spec string) (Namespace, error) {
+	path, name := spec, filepath.Base(spec)
+	if pid, err := strconv.Atoi(spec); err == nil {
+		path, name = fmt.Sprintf("/proc/%d/ns/net", pid), fmt.Sprintf("pid:%d", pid)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return Namespace{}, err
+	}
+	return Namespace{Name: name, file: f}, nil
+}
+
+// walkNetNamespaces finds all the namespaces under /proc/*/ns/net, the one which sniffer
+// runs in comes first.
+func walkNetNamespaces() ([]Namespace, error) {
+	self, err := netnsInode("/proc/self/ns/net")
+	if err != nil {
+		return nil, err
+	}
+
+	fnames, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+
+	namespaces := []Namespace{{}}
+	visited := map[uint64]bool{self: true}
+	for _, fname := range fnames {
+		if _, err := strconv.Atoi(fname.Name()); err != nil {
+			continue
+		}
+
+		path := filepath.Join("/proc", fname.Name(), "ns/net")
+		ino, err := netnsInode(path)
+		if err != nil || visited[ino] {
+			continue
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		visited[ino] = true
+		namespaces = append(namespaces, Namespace{Name: fmt.Sprintf("netns:%d", ino), file: f})
+	}
+
+	return namespaces, nil
+}
+
+// List returns the namespaces to work in. All the namespaces are walked if all is true,
+// otherwise the one specified by spec, or the namespace of sniffer itself if it is empty.
+// The namespaces are found once, the ones created later are not listed, and they are
+// closed by the caller once done.
+func List(spec string, all bool) ([]Namespace, error) {
+	switch {
+	case all:
+		return walkNetNamespaces()
+	case spec != "":
+		ns, err := openNetNamespace(spec)
+		if err != nil {
+			return nil, err
+		}
+		return []Namespace{ns}, nil
+	}
+	return []Namespace{{}}, nil
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+// +build !linux
+
+package netns
+
+import "errors"
+
+// Do runs fn, there is only the namespace of sniffer itself out of Linux.
+func (ns Namespace) Do(fn func() error) error {
+	return fn()
+}
+
+// List returns the namespace of sniffer itself, the other namespaces are only available
+// on Linux.
+func List(spec string, all bool) ([]Namespace, error) {
+	if spec != "" || all {
+		return nil, errors.New("network namespaces are only available on Linux")
+	}
+	return []Namespace{{}}, nil
+}
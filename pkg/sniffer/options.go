@@ -0,0 +1,149 @@
+package sniffer
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"time"
+
+	"github.com/chenjiandongx/sniffer/pkg/capture"
+	"github.com/chenjiandongx/sniffer/pkg/netns"
+	"github.com/chenjiandongx/sniffer/pkg/socket"
+)
+
+// Options is the options set for the sniffer instance.
+type Options struct {
+	// BPFFilter is the string pcap filter with the BPF syntax
+	// eg. "tcp and port 80"
+	BPFFilter string
+
+	// Interval is the interval for refresh rate in seconds
+	Interval int
+
+	// DevicesPrefix represents prefixed devices to monitor
+	DevicesPrefix []string
+
+	// DisableDNSResolve decides whether if disable the DNS resolution
+	DisableDNSResolve bool
+
+	// AllDevices specifies whether to listen all devices or not
+	AllDevices bool
+
+	// ReadFile is the pcap/pcapng capture file to replay instead of the live capture
+	ReadFile string
+
+	// ReplayFast decides whether to replay the capture file as fast as possible
+	// rather than at the recorded speed
+	ReplayFast bool
+
+	// SocketsFile is the output of `ss -tunap` taken on the host where the capture
+	// file was recorded, it is used to attribute the replayed packets to the processes
+	SocketsFile string
+
+	// WriteFile is the pcapng file to record the captured packets into
+	WriteFile string
+
+	// WriteMaxSize is the size in MB to rotate the recording file, 0 means unlimited
+	WriteMaxSize int
+
+	// WriteRotateInterval is the age to rotate the recording file, 0 means unlimited
+	WriteRotateInterval time.Duration
+
+	// WriteMaxFiles is the number of the rotated recording files to keep, 0 means unlimited
+	WriteMaxFiles int
+
+	// Application is written into the section header of the recording files
+	Application string
+
+	// Netns is the network namespace to capture in, specified by a path like
+	// /var/run/netns/<name> or the pid of a process in it, Linux only
+	Netns string
+
+	// AllNetns decides whether to capture in all the network namespaces found under
+	// /proc/*/ns/net, Linux only. The namespaces are found once on start, the ones
+	// created later are not captured until restarted
+	AllNetns bool
+
+	// CountLayer is the layer which the length of the packets is measured from,
+	// eg. capture.CountLayerL2 to agree with the interface counters
+	CountLayer capture.CountLayer
+
+	// SocketBackend is the backend to fetch the sockets by, socket.BackendNative or
+	// socket.BackendEBPF which falls back to the native one if eBPF is unavailable
+	SocketBackend string
+
+	// DisablePayloadInspect decides whether to disable extracting the TLS SNI and the
+	// HTTP Host from the first packets of the connections
+	DisablePayloadInspect bool
+}
+
+func (o Options) Validate() error {
+	if o.Interval <= 0 {
+		return fmt.Errorf("invalid interval %d", o.Interval)
+	}
+	if o.ReadFile == "" && (o.ReplayFast || o.SocketsFile != "") {
+		return errors.New("replay options require a capture file to read")
+	}
+	if o.ReadFile != "" && o.WriteFile != "" {
+		return errors.New("recording is not available in the replay mode")
+	}
+	if o.Netns != "" && o.AllNetns {
+		return errors.New("netns and all-netns are mutually exclusive")
+	}
+	if (o.Netns != "" || o.AllNetns) && (o.ReadFile != "" || runtime.GOOS != "linux") {
+		return errors.New("network namespaces are only available in the live capture on Linux")
+	}
+	if o.CountLayer != "" && !o.CountLayer.Valid() {
+		return fmt.Errorf("invalid count layer %q", o.CountLayer)
+	}
+	switch o.SocketBackend {
+	case "", socket.BackendNative:
+	case socket.BackendEBPF:
+		if o.Netns != "" || o.AllNetns {
+			return errors.New("the ebpf backend is not available with network namespaces")
+		}
+	default:
+		return fmt.Errorf("invalid socket backend %q", o.SocketBackend)
+	}
+	return nil
+}
+
+func DefaultOptions() Options {
+	return Options{
+		BPFFilter:         "tcp or udp",
+		Interval:          1,
+		DevicesPrefix:     []string{"en", "lo", "eth", "em", "bond"},
+		DisableDNSResolve: false,
+		AllDevices:        false,
+		WriteMaxFiles:     10,
+		Application:       "sniffer",
+		CountLayer:        capture.CountLayerL4,
+		SocketBackend:     socket.BackendNative,
+	}
+}
+
+func (o Options) captureOptions(namespaces []netns.Namespace) capture.Options {
+	return capture.Options{
+		BPFFilter:             o.BPFFilter,
+		DevicesPrefix:         o.DevicesPrefix,
+		AllDevices:            o.AllDevices,
+		Namespaces:            namespaces,
+		DisablePayloadInspect: o.DisablePayloadInspect,
+		CountLayer:            o.CountLayer,
+		WriteFile:             o.WriteFile,
+		WriteMaxSize:          o.WriteMaxSize,
+		WriteRotateInterval:   o.WriteRotateInterval,
+		WriteMaxFiles:         o.WriteMaxFiles,
+		Application:           o.Application,
+		ReadFile:              o.ReadFile,
+		ReplayFast:            o.ReplayFast,
+		Interval:              o.Interval,
+	}
+}
+
+func (o Options) socketOptions(namespaces []netns.Namespace) socket.Options {
+	return socket.Options{
+		Backend:    o.SocketBackend,
+		Namespaces: namespaces,
+	}
+}
@@ -0,0 +1,294 @@
+// Package sniffer wires the capture, the socket fetching and the stats together, the
+// aggregated snapshots are delivered to the subscribers on every interval.
+//
+//	s, err := sniffer.NewSniffer(sniffer.DefaultOptions())
+//	if err != nil {
+//		return err
+//	}
+//	defer s.Close()
+//
+//	for snapshot := range s.Subscribe() {
+//		fmt.Println(snapshot.TotalUploadBytes, snapshot.TotalDownloadBytes)
+//	}
+package sniffer
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/chenjiandongx/sniffer/pkg/capture"
+	"github.com/chenjiandongx/sniffer/pkg/dns"
+	"github.com/chenjiandongx/sniffer/pkg/netns"
+	"github.com/chenjiandongx/sniffer/pkg/socket"
+	"github.com/chenjiandongx/sniffer/pkg/stats"
+)
+
+type Sniffer struct {
+	opts          Options
+	dnsResolver   *dns.Resolver
+	pcapClient    *capture.PcapClient
+	replayClient  *capture.ReplayClient
+	statsManager  *stats.Manager
+	socketFetcher socket.Fetcher
+	namespaces    []netns.Namespace
+	warning       error
+
+	mut         sync.Mutex
+	subscribers []chan *stats.Snapshot
+	closed      bool
+	once        sync.Once
+	done        chan struct{}
+	closeOnce   sync.Once
+	wg          sync.WaitGroup
+}
+
+// NewSniffer starts capturing the packets, the snapshots are not taken until Subscribe
+// is called.
+func NewSniffer(opts Options) (*Sniffer, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	s := &Sniffer{opts: opts, done: make(chan struct{})}
+	if !opts.DisableDNSResolve {
+		s.dnsResolver = dns.NewResolver()
+	}
+	s.statsManager = stats.NewManager(opts.Interval, s.lookup())
+
+	var err error
+	if opts.ReadFile != "" {
+		err = s.setupReplay()
+	} else {
+		err = s.setupLive()
+	}
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// setupLive lists the network namespaces once, the capture and the socket fetching share
+// them so that the sockets are looked up in the namespaces where the packets come from.
+func (s *Sniffer) setupLive() error {
+	namespaces, err := netns.List(s.opts.Netns, s.opts.AllNetns)
+	if err != nil {
+		return err
+	}
+	s.namespaces = namespaces
+
+	if s.pcapClient, err = capture.NewPcapClient(s.observeDNS(), s.opts.captureOptions(namespaces)); err != nil {
+		return err
+	}
+	s.socketFetcher, err = socket.NewFetcher(s.opts.socketOptions(namespaces))
+	var fallback *socket.FallbackError
+	if errors.As(err, &fallback) {
+		s.warning = err
+		return nil
+	}
+	return err
+}
+
+// Warning returns the error which the sniffer has worked around, such as the socket
+// backend falling back to the native one on start or the captured packets failing to be
+// recorded, it is nil if there is none.
+func (s *Sniffer) Warning() error {
+	if s.pcapClient != nil {
+		if err := s.pcapClient.RecordErr(); err != nil {
+			return err
+		}
+	}
+	return s.warning
+}
+
+func (s *Sniffer) setupReplay() error {
+	openSockets := make(socket.OpenSockets)
+	if s.opts.SocketsFile != "" {
+		var err error
+		if openSockets, err = socket.LoadSnapshot(s.opts.SocketsFile); err != nil {
+			return err
+		}
+	}
+
+	replayClient, err := capture.NewReplayClient(s.observeDNS(), openSockets.LocalIPs(), s.opts.captureOptions(nil))
+	if err != nil {
+		return err
+	}
+
+	s.replayClient = replayClient
+	s.socketFetcher = socket.NewStaticFetcher(openSockets)
+	return nil
+}
+
+// lookup returns the Lookup which resolves the remote IPs on display, it is nil if the
+// DNS resolution is disabled.
+func (s *Sniffer) lookup() stats.Lookup {
+	if s.dnsResolver == nil {
+		return nil
+	}
+	return s.dnsResolver.Lookup
+}
+
+// observeDNS returns the DNSObserve which learns the names from the sniffed DNS
+// responses, it is nil if the DNS resolution is disabled.
+func (s *Sniffer) observeDNS() capture.DNSObserve {
+	if s.dnsResolver == nil {
+		return nil
+	}
+	return s.dnsResolver.Observe
+}
+
+// Subscribe returns the channel which receives a snapshot on every interval, or on every
+// bucket of the packet timestamps in the replay mode. The snapshots are taken once the
+// first subscriber comes, and the delivery waits for every subscriber to receive, so a
+// slow subscriber holds the others as well as the replay. The channel is closed when the
+// sniffer is closed or the replay is finished.
+func (s *Sniffer) Subscribe() <-chan *stats.Snapshot {
+	ch := make(chan *stats.Snapshot)
+
+	s.mut.Lock()
+	if s.closed {
+		close(ch)
+	} else {
+		s.subscribers = append(s.subscribers, ch)
+	}
+	s.mut.Unlock()
+
+	s.once.Do(func() {
+		s.wg.Add(1)
+		go s.run()
+	})
+	return ch
+}
+
+// Err returns the error which ended the replay before the end of the capture file, it is
+// valid once the channels of Subscribe are closed.
+func (s *Sniffer) Err() error {
+	if s.replayClient == nil {
+		return nil
+	}
+	return s.replayClient.Err()
+}
+
+func (s *Sniffer) run() {
+	defer s.wg.Done()
+	defer s.closeSubscribers()
+
+	// In the replay mode, the stats are refreshed by the buckets of the packet timestamps
+	// instead of the ticker.
+	if s.replayClient != nil {
+		for {
+			select {
+			case <-s.done:
+				return
+			case bucket, ok := <-s.replayClient.Buckets():
+				if !ok {
+					return
+				}
+				s.refresh(bucket.Time, bucket.Utilization)
+			}
+		}
+	}
+
+	ticker := time.NewTicker(time.Duration(s.opts.Interval) * time.Second)
+	defer ticker.Stop()
+
+	s.refresh(time.Now(), s.pcapClient.GetUtilization())
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.refresh(time.Now(), s.pcapClient.GetUtilization())
+		}
+	}
+}
+
+func (s *Sniffer) refresh(t time.Time, utilization capture.Utilization) {
+	openSockets, err := s.socketFetcher.GetOpenSockets()
+	if err != nil {
+		return
+	}
+
+	s.statsManager.Put(stats.Stat{Time: t, OpenSockets: openSockets, Utilization: utilization})
+	s.publish(s.statsManager.GetSnapshot())
+}
+
+// SetCumulative enables or disables accumulating the traffic in Snapshot.Cumulative, it
+// is left out of the snapshots until enabled.
+func (s *Sniffer) SetCumulative(enabled bool) {
+	s.statsManager.SetCumulative(enabled)
+}
+
+// SetAverages enables or disables the moving averages in Snapshot.Averages and the peak
+// rates in Snapshot.Peaks, they are left out of the snapshots until enabled.
+func (s *Sniffer) SetAverages(enabled bool) {
+	s.statsManager.SetAverages(enabled)
+}
+
+// ResetCumulative discards the traffic accumulated in Snapshot.Cumulative and the peak
+// rates in Snapshot.Peaks, the accumulation starts over from the next snapshot.
+func (s *Sniffer) ResetCumulative() {
+	s.statsManager.Reset()
+}
+
+// SetBPFFilter replaces the BPF filter of the live capture, the previous filter is kept
+// if the new one fails to compile or apply. The stats are kept across the change.
+func (s *Sniffer) SetBPFFilter(filter string) error {
+	if s.pcapClient == nil {
+		return errors.New("the BPF filter can not be changed in the replay mode")
+	}
+	return s.pcapClient.SetBPFFilter(filter)
+}
+
+func (s *Sniffer) publish(snapshot *stats.Snapshot) {
+	s.mut.Lock()
+	subscribers := s.subscribers
+	s.mut.Unlock()
+
+	for _, ch := range subscribers {
+		select {
+		case ch <- snapshot:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *Sniffer) closeSubscribers() {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	for _, ch := range s.subscribers {
+		close(ch)
+	}
+	s.subscribers = nil
+	s.closed = true
+}
+
+// Close stops the capture and closes the channels of the subscribers.
+func (s *Sniffer) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+	s.wg.Wait()
+	s.closeSubscribers()
+
+	if s.pcapClient != nil {
+		s.pcapClient.Close()
+	}
+	if s.replayClient != nil {
+		s.replayClient.Close()
+	}
+	if s.dnsResolver != nil {
+		s.dnsResolver.Close()
+	}
+	if closer, ok := s.socketFetcher.(io.Closer); ok {
+		closer.Close()
+	}
+	for _, ns := range s.namespaces {
+		ns.Close()
+	}
+}
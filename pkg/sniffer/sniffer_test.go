@@ -0,0 +1,88 @@
+package sniffer
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTestCapture(t *testing.T, name string, start time.Time) {
+	f, err := os.Create(name)
+	assert.NoError(t, err)
+	defer f.Close()
+
+	w := pcapgo.NewWriter(f)
+	assert.NoError(t, w.WriteFileHeader(65535, layers.LinkTypeEthernet))
+
+	for _, offset := range []time.Duration{0, 1500 * time.Millisecond} {
+		buf := gopacket.NewSerializeBuffer()
+		err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true},
+			&layers.Ethernet{
+				SrcMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 1},
+				DstMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 2},
+				EthernetType: layers.EthernetTypeIPv4,
+			},
+			&layers.IPv4{
+				Version:  4,
+				TTL:      64,
+				Protocol: layers.IPProtocolUDP,
+				SrcIP:    net.IPv4(10, 0, 0, 1),
+				DstIP:    net.IPv4(10, 0, 0, 2),
+			},
+			&layers.UDP{SrcPort: 50000, DstPort: 8125},
+			gopacket.Payload("hello"),
+		)
+		assert.NoError(t, err)
+
+		data := buf.Bytes()
+		ci := gopacket.CaptureInfo{Timestamp: start.Add(offset), CaptureLength: len(data), Length: len(data)}
+		assert.NoError(t, w.WritePacket(ci, data))
+	}
+}
+
+func TestSnifferSubscribe(t *testing.T) {
+	dir := t.TempDir()
+	capture := filepath.Join(dir, "dump.pcap")
+	sockets := filepath.Join(dir, "ss.txt")
+	writeTestCapture(t, capture, time.Unix(1600000000, 0))
+	ss := `Netid State Recv-Q Send-Q Local Address:Port Peer Address:Port Process
+udp   ESTAB 0      0      10.0.0.1:50000     10.0.0.2:8125      users:(("statsd",pid=42,fd=6))`
+	assert.NoError(t, ioutil.WriteFile(sockets, []byte(ss), 0644))
+
+	opts := DefaultOptions()
+	opts.ReadFile = capture
+	opts.ReplayFast = true
+	opts.SocketsFile = sockets
+	opts.DisableDNSResolve = true
+
+	s, err := NewSniffer(opts)
+	assert.NoError(t, err)
+	defer s.Close()
+
+	// The packets of the capture file are not filtered by the kernel.
+	assert.Error(t, s.SetBPFFilter("tcp"))
+
+	first, second := s.Subscribe(), s.Subscribe()
+	var snapshots int
+	for snapshot := range first {
+		assert.Equal(t, snapshot, <-second)
+		assert.Equal(t, 1, snapshot.Processes["<42>:statsd"].UploadPackets)
+		assert.Equal(t, 1, snapshot.RemoteAddrs["10.0.0.2"].ConnCount)
+		snapshots++
+	}
+	assert.Equal(t, 2, snapshots)
+
+	// Both channels are closed once the replay is finished.
+	_, ok := <-second
+	assert.False(t, ok)
+	_, ok = <-s.Subscribe()
+	assert.False(t, ok)
+}
@@ -0,0 +1,746 @@
+//go:build linux
+// +build linux
+
+package socket
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// The commands, types and helpers of bpf(2), see include/uapi/linux/bpf.h.
+const (
+	bpfMapCreate     = 0
+	bpfMapLookupElem = 1
+	bpfMapDeleteElem = 3
+	bpfMapGetNextKey = 4
+	bpfProgLoad      = 5
+
+	bpfMapTypeLRUHash = 9
+
+	bpfProgTypeKprobe     = 2
+	bpfProgTypeTracepoint = 5
+
+	bpfFuncMapLookupElem      = 1
+	bpfFuncMapUpdateElem      = 2
+	bpfFuncProbeRead          = 4
+	bpfFuncGetCurrentPidTgid  = 14
+	bpfFuncGetCurrentComm     = 16
+	bpfFuncGetCurrentCgroupID = 80
+	bpfFuncProbeReadKernel    = 113
+
+	bpfPseudoMapFd = 1
+
+	bpfSocketMapSize = 65536
+)
+
+// The registers of the eBPF instructions.
+const (
+	r0 uint8 = iota
+	r1
+	r2
+	r3
+	r4
+	r5
+	r6
+	r7
+	r8
+	r9
+	r10
+)
+
+// The opcodes of the eBPF instructions, see Documentation/bpf/instruction-set.rst.
+const (
+	opLdImm64  = 0x18
+	opMovImm   = 0xb7
+	opMovReg   = 0xbf
+	opAddImm   = 0x07
+	opRshImm   = 0x77
+	opLdxH     = 0x69
+	opLdxW     = 0x61
+	opLdxDW    = 0x79
+	opStxH     = 0x6b
+	opStxW     = 0x63
+	opStxDW    = 0x7b
+	opStH      = 0x6a
+	opBe       = 0xdc
+	opJa       = 0x05
+	opJeqImm   = 0x15
+	opJneImm   = 0x55
+	opCall     = 0x85
+	opExit     = 0x95
+	insnLength = 8
+)
+
+// bpfSockKey is the key of the socket map, the layout is shared with the programs. The
+// connections are keyed by both ends so that the ones sharing the local address, eg. the
+// ones accepted by the same listener, are closed apart. The remote end is zero for the
+// listening and the unconnected sockets, and the ports are in the host byte order.
+type bpfSockKey struct {
+	Family     uint16
+	Port       uint16
+	Protocol   uint16
+	RemotePort uint16
+	Addr       [16]byte
+	RemoteAddr [16]byte
+}
+
+// bpfSockValue is the owner of the socket recorded by the programs.
+type bpfSockValue struct {
+	Pid    uint32
+	Closed uint32
+	Cgroup uint64
+	Comm   [16]byte
+}
+
+// The offsets of the key and the value on the stack of the programs.
+const (
+	stackKey   = -40
+	stackValue = -72
+)
+
+type bpfInsn struct {
+	code uint8
+	regs uint8
+	off  int16
+	imm  int32
+}
+
+// bpfAsm assembles the eBPF instructions, the jumps are resolved by the labels.
+type bpfAsm struct {
+	insns  []bpfInsn
+	labels map[string]int
+	jumps  map[int]string
+}
+
+func newBPFAsm() *bpfAsm {
+	return &bpfAsm{labels: make(map[string]int), jumps: make(map[int]string)}
+}
+
+func (a *bpfAsm) emit(code, dst, src uint8, off int16, imm int32) {
+	a.insns = append(a.insns, bpfInsn{code: code, regs: dst | src<<4, off: off, imm: imm})
+}
+
+func (a *bpfAsm) label(name string) {
+	a.labels[name] = len(a.insns)
+}
+
+func (a *bpfAsm) jump(code, dst uint8, imm int32, label string) {
+	a.jumps[len(a.insns)] = label
+	a.emit(code, dst, 0, 0, imm)
+}
+
+func (a *bpfAsm) ldMapFd(dst uint8, fd int) {
+	a.emit(opLdImm64, dst, bpfPseudoMapFd, 0, int32(fd))
+	a.emit(0, 0, 0, 0, 0)
+}
+
+func (a *bpfAsm) call(helper int32) {
+	a.emit(opCall, 0, 0, 0, helper)
+}
+
+// stackPtr sets the register to the address on the stack.
+func (a *bpfAsm) stackPtr(dst uint8, off int32) {
+	a.emit(opMovReg, dst, r10, 0, 0)
+	a.emit(opAddImm, dst, 0, 0, off)
+}
+
+func (a *bpfAsm) assemble() ([]byte, error) {
+	for pc, label := range a.jumps {
+		target, ok := a.labels[label]
+		if !ok {
+			return nil, fmt.Errorf("bpf: undefined label %s", label)
+		}
+		a.insns[pc].off = int16(target - pc - 1)
+	}
+
+	bo := getNativeEndian()
+	b := make([]byte, 0, len(a.insns)*insnLength)
+	for _, insn := range a.insns {
+		regs := insn.regs
+		if bo == binary.BigEndian {
+			regs = insn.regs>>4 | insn.regs<<4
+		}
+		b = append(b, insn.code, regs, 0, 0, 0, 0, 0, 0)
+		bo.PutUint16(b[len(b)-6:], uint16(insn.off))
+		bo.PutUint32(b[len(b)-4:], uint32(insn.imm))
+	}
+	return b, nil
+}
+
+// zeroSockStack zeroes the key and the value on the stack so that they are initialized
+// for the helpers.
+func (a *bpfAsm) zeroSockStack() {
+	a.emit(opMovImm, r1, 0, 0, 0)
+	for off := stackValue; off < 0; off += 8 {
+		a.emit(opStxDW, r10, r1, int16(off), 0)
+	}
+}
+
+// updateOwner records the current process as the owner of the key on the stack.
+func (a *bpfAsm) updateOwner(mapFd int, exit string) {
+	a.call(bpfFuncGetCurrentPidTgid)
+	a.emit(opRshImm, r0, 0, 0, 32)
+	a.jump(opJeqImm, r0, 0, exit)
+	a.emit(opStxW, r10, r0, stackValue, 0)
+
+	a.call(bpfFuncGetCurrentCgroupID)
+	a.emit(opStxDW, r10, r0, stackValue+8, 0)
+
+	a.stackPtr(r1, stackValue+16)
+	a.emit(opMovImm, r2, 0, 0, 16)
+	a.call(bpfFuncGetCurrentComm)
+
+	a.ldMapFd(r1, mapFd)
+	a.stackPtr(r2, stackKey)
+	a.stackPtr(r3, stackValue)
+	a.emit(opMovImm, r4, 0, 0, 0)
+	a.call(bpfFuncMapUpdateElem)
+}
+
+// sockOffsets are the offsets of the members of struct sock_common.
+type sockOffsets struct {
+	family, num, rcvSaddr, v6RcvSaddr int32
+	dport, daddr, v6Daddr             int32
+}
+
+// sockProbe is the probe whose context carries the struct sock of the current process,
+// the protocol is read from the context if protoOffset is not negative.
+type sockProbe struct {
+	progType    uint32
+	skOffset    int16
+	protoOffset int16
+	protocol    int32
+}
+
+// sockProgram records the current process as the owner of the socket which it sends to
+// or receives from.
+func sockProgram(p sockProbe, so sockOffsets, mapFd int, probeRead int32) ([]byte, error) {
+	a := newBPFAsm()
+	a.emit(opMovReg, r6, r1, 0, 0)
+	a.emit(opLdxDW, r7, r6, p.skOffset, 0)
+	a.jump(opJeqImm, r7, 0, "exit")
+	a.zeroSockStack()
+
+	read := func(stackOff int32, size int32, skOff int32) {
+		a.stackPtr(r1, stackOff)
+		a.emit(opMovImm, r2, 0, 0, size)
+		a.emit(opMovReg, r3, r7, 0, 0)
+		a.emit(opAddImm, r3, 0, 0, skOff)
+		a.call(probeRead)
+	}
+
+	read(stackKey, 2, so.family)
+	a.emit(opLdxH, r1, r10, stackKey, 0)
+	a.jump(opJeqImm, r1, unix.AF_INET, "v4")
+	a.jump(opJneImm, r1, unix.AF_INET6, "exit")
+	read(stackKey+8, 16, so.v6RcvSaddr)
+	read(stackKey+24, 16, so.v6Daddr)
+	a.jump(opJa, 0, 0, "port")
+	a.label("v4")
+	read(stackKey+8, 4, so.rcvSaddr)
+	read(stackKey+24, 4, so.daddr)
+
+	a.label("port")
+	read(stackKey+2, 2, so.num)
+	a.emit(opLdxH, r1, r10, stackKey+2, 0)
+	a.jump(opJeqImm, r1, 0, "exit")
+	// skc_dport is in the network byte order unlike skc_num.
+	read(stackKey+6, 2, so.dport)
+	a.emit(opLdxH, r1, r10, stackKey+6, 0)
+	a.emit(opBe, r1, 0, 0, 16)
+	a.emit(opStxH, r10, r1, stackKey+6, 0)
+
+	if p.protoOffset < 0 {
+		a.emit(opStH, r10, 0, stackKey+4, p.protocol)
+	} else {
+		a.emit(opLdxH, r1, r6, p.protoOffset, 0)
+		a.jump(opJeqImm, r1, unix.IPPROTO_TCP, "proto")
+		a.jump(opJneImm, r1, unix.IPPROTO_UDP, "exit")
+		a.label("proto")
+		a.emit(opStxH, r10, r1, stackKey+4, 0)
+	}
+
+	a.updateOwner(mapFd, "exit")
+	a.label("exit")
+	a.emit(opMovImm, r0, 0, 0, 0)
+	a.emit(opExit, 0, 0, 0, 0)
+	return a.assemble()
+}
+
+// stateProgram handles the sock:inet_sock_set_state tracepoint, the closed TCP sockets
+// are marked for removal by both ends, and the listening ones are recorded since
+// listen(2) is called by the owner.
+func stateProgram(fields map[string]traceField, mapFd int) ([]byte, error) {
+	for _, name := range []string{"newstate", "sport", "dport", "family", "protocol", "saddr", "daddr", "saddr_v6", "daddr_v6"} {
+		if _, ok := fields[name]; !ok {
+			return nil, fmt.Errorf("bpf: inet_sock_set_state.%s not found", name)
+		}
+	}
+	off := func(name string) int16 {
+		return int16(fields[name].offset)
+	}
+
+	a := newBPFAsm()
+	a.emit(opMovReg, r6, r1, 0, 0)
+	a.emit(opLdxW, r7, r6, off("newstate"), 0)
+	a.jump(opJeqImm, r7, int32(tcpClose), "key")
+	a.jump(opJneImm, r7, int32(tcpListen), "exit")
+
+	a.label("key")
+	a.zeroSockStack()
+	a.emit(opLdxH, r1, r6, off("protocol"), 0)
+	a.jump(opJneImm, r1, unix.IPPROTO_TCP, "exit")
+	a.emit(opStxH, r10, r1, stackKey+4, 0)
+	a.emit(opLdxH, r1, r6, off("sport"), 0)
+	a.jump(opJeqImm, r1, 0, "exit")
+	a.emit(opStxH, r10, r1, stackKey+2, 0)
+	a.emit(opLdxH, r1, r6, off("dport"), 0)
+	a.emit(opStxH, r10, r1, stackKey+6, 0)
+
+	a.emit(opLdxH, r1, r6, off("family"), 0)
+	a.emit(opStxH, r10, r1, stackKey, 0)
+	a.jump(opJeqImm, r1, unix.AF_INET6, "v6")
+	a.jump(opJneImm, r1, unix.AF_INET, "exit")
+	a.emit(opLdxW, r1, r6, off("saddr"), 0)
+	a.emit(opStxW, r10, r1, stackKey+8, 0)
+	a.emit(opLdxW, r1, r6, off("daddr"), 0)
+	a.emit(opStxW, r10, r1, stackKey+24, 0)
+	a.jump(opJa, 0, 0, "value")
+	a.label("v6")
+	for i := int16(0); i < 16; i += 4 {
+		a.emit(opLdxW, r1, r6, off("saddr_v6")+i, 0)
+		a.emit(opStxW, r10, r1, stackKey+8+i, 0)
+		a.emit(opLdxW, r1, r6, off("daddr_v6")+i, 0)
+		a.emit(opStxW, r10, r1, stackKey+24+i, 0)
+	}
+
+	a.label("value")
+	a.jump(opJneImm, r7, int32(tcpClose), "listen")
+	a.ldMapFd(r1, mapFd)
+	a.stackPtr(r2, stackKey)
+	a.call(bpfFuncMapLookupElem)
+	a.jump(opJeqImm, r0, 0, "exit")
+	a.emit(opMovImm, r1, 0, 0, 1)
+	a.emit(opStxW, r0, r1, 4, 0)
+	a.jump(opJa, 0, 0, "exit")
+
+	a.label("listen")
+	a.updateOwner(mapFd, "exit")
+	a.label("exit")
+	a.emit(opMovImm, r0, 0, 0, 0)
+	a.emit(opExit, 0, 0, 0, 0)
+	return a.assemble()
+}
+
+// bpfPointer is the 64-bit pointer in the attributes of bpf(2). It holds an unsafe.Pointer
+// rather than an uintptr so that the memory pointed to is kept alive and is not moved by
+// the runtime before the syscall returns. On the 32-bit architectures the pointer is
+// followed by a nil one, which is right for the little-endian ones.
+type bpfPointer [8 / unsafe.Sizeof(uintptr(0))]unsafe.Pointer
+
+func newBPFPointer(ptr unsafe.Pointer) bpfPointer {
+	return bpfPointer{ptr}
+}
+
+func bpfSyscall(cmd int, attr unsafe.Pointer, size uintptr) (int, error) {
+	fd, _, errno := unix.Syscall(unix.SYS_BPF, uintptr(cmd), uintptr(attr), size)
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(fd), nil
+}
+
+func bpfCreateSocketMap() (int, error) {
+	attr := struct {
+		mapType, keySize, valueSize, maxEntries, flags uint32
+	}{
+		mapType:    bpfMapTypeLRUHash,
+		keySize:    uint32(unsafe.Sizeof(bpfSockKey{})),
+		valueSize:  uint32(unsafe.Sizeof(bpfSockValue{})),
+		maxEntries: bpfSocketMapSize,
+	}
+	return bpfSyscall(bpfMapCreate, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+}
+
+// kernelVersion returns the LINUX_VERSION_CODE of the running kernel, which the kprobe
+// programs are checked against before Linux 5.0. The distributions patching the sublevel
+// out of the release report the upstream one in /proc/version_signature or the version.
+func kernelVersion() uint32 {
+	if b, err := ioutil.ReadFile("/proc/version_signature"); err == nil {
+		if fields := strings.Fields(string(b)); len(fields) > 0 {
+			return parseKernelVersion(fields[len(fields)-1])
+		}
+	}
+
+	var uts unix.Utsname
+	if err := unix.Uname(&uts); err != nil {
+		return 0
+	}
+	version := unix.ByteSliceToString(uts.Version[:])
+	if i := strings.Index(version, "Debian "); i >= 0 {
+		return parseKernelVersion(version[i+len("Debian "):])
+	}
+	return parseKernelVersion(unix.ByteSliceToString(uts.Release[:]))
+}
+
+// parseKernelVersion parses the version like 4.19.181-1 into KERNEL_VERSION(4, 19, 181),
+// the sublevel is capped at 255 as the kernel does.
+func parseKernelVersion(release string) uint32 {
+	var major, minor, sublevel uint32
+	fmt.Sscanf(release, "%d.%d.%d", &major, &minor, &sublevel)
+	if sublevel > 255 {
+		sublevel = 255
+	}
+	return major<<16 | minor<<8 | sublevel
+}
+
+func bpfLoadProgram(progType uint32, insns []byte) (int, error) {
+	license := []byte("GPL\x00")
+	log := make([]byte, 64*1024)
+	attr := struct {
+		progType, insnCnt  uint32
+		insns, license     bpfPointer
+		logLevel, logSize  uint32
+		logBuf             bpfPointer
+		kernVersion, flags uint32
+	}{
+		progType:    progType,
+		insnCnt:     uint32(len(insns) / insnLength),
+		insns:       newBPFPointer(unsafe.Pointer(&insns[0])),
+		license:     newBPFPointer(unsafe.Pointer(&license[0])),
+		logLevel:    1,
+		logSize:     uint32(len(log)),
+		logBuf:      newBPFPointer(unsafe.Pointer(&log[0])),
+		kernVersion: kernelVersion(),
+	}
+
+	fd, err := bpfSyscall(bpfProgLoad, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	if err != nil {
+		if n := strings.IndexByte(string(log), 0); n > 0 {
+			return -1, fmt.Errorf("bpf: load program: %v: %s", err, strings.TrimSpace(string(log[:n])))
+		}
+		return -1, fmt.Errorf("bpf: load program: %v", err)
+	}
+	return fd, nil
+}
+
+type bpfMapAttr struct {
+	mapFd uint32
+	_     uint32
+	key   bpfPointer
+	value bpfPointer
+	flags uint64
+}
+
+// bpfMap is the socket map created in the kernel, the programs are attached until it
+// is closed.
+type bpfMap struct {
+	fd     int
+	closer []int
+}
+
+func (m *bpfMap) Entries() (map[bpfSockKey]bpfSockValue, error) {
+	entries := make(map[bpfSockKey]bpfSockValue)
+
+	var key, next bpfSockKey
+	attr := bpfMapAttr{mapFd: uint32(m.fd), value: newBPFPointer(unsafe.Pointer(&next))}
+	for first := true; ; first = false {
+		if !first {
+			attr.key = newBPFPointer(unsafe.Pointer(&key))
+		}
+		if _, err := bpfSyscall(bpfMapGetNextKey, unsafe.Pointer(&attr), unsafe.Sizeof(attr)); err != nil {
+			if err == unix.ENOENT {
+				return entries, nil
+			}
+			return entries, err
+		}
+
+		var value bpfSockValue
+		lookup := bpfMapAttr{
+			mapFd: uint32(m.fd),
+			key:   newBPFPointer(unsafe.Pointer(&next)),
+			value: newBPFPointer(unsafe.Pointer(&value)),
+		}
+		// The entry may be evicted between the calls.
+		if _, err := bpfSyscall(bpfMapLookupElem, unsafe.Pointer(&lookup), unsafe.Sizeof(lookup)); err == nil {
+			entries[next] = value
+		}
+		key = next
+	}
+}
+
+func (m *bpfMap) Delete(key bpfSockKey) error {
+	attr := bpfMapAttr{mapFd: uint32(m.fd), key: newBPFPointer(unsafe.Pointer(&key))}
+	_, err := bpfSyscall(bpfMapDeleteElem, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	if err == unix.ENOENT {
+		return nil
+	}
+	return err
+}
+
+func (m *bpfMap) Close() error {
+	for _, fd := range m.closer {
+		unix.Close(fd)
+	}
+	return unix.Close(m.fd)
+}
+
+// attach attaches the program to the perf event and keeps them open with the map.
+func (m *bpfMap) attach(attr *unix.PerfEventAttr, progFd int) error {
+	attr.Size = uint32(unsafe.Sizeof(*attr))
+	efd, err := unix.PerfEventOpen(attr, -1, 0, -1, unix.PERF_FLAG_FD_CLOEXEC)
+	if err != nil {
+		return err
+	}
+	m.closer = append(m.closer, efd)
+
+	if err := unix.IoctlSetInt(efd, unix.PERF_EVENT_IOC_SET_BPF, progFd); err != nil {
+		return err
+	}
+	return unix.IoctlSetInt(efd, unix.PERF_EVENT_IOC_ENABLE, 0)
+}
+
+var tracefsRoots = []string{"/sys/kernel/tracing", "/sys/kernel/debug/tracing"}
+
+// traceField is the field of the tracepoint record.
+type traceField struct {
+	offset, size int
+}
+
+// parseTraceFormat parses the format of the tracepoint, eg.
+// `field:__u16 sport;	offset:24;	size:2;	signed:0;`.
+func parseTraceFormat(content string) map[string]traceField {
+	fields := make(map[string]traceField)
+	for _, line := range strings.Split(content, "\n") {
+		var decl string
+		var field traceField
+		for _, part := range strings.Split(strings.TrimSpace(line), ";") {
+			kv := strings.SplitN(strings.TrimSpace(part), ":", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			switch kv[0] {
+			case "field":
+				decl = kv[1]
+			case "offset":
+				field.offset, _ = strconv.Atoi(kv[1])
+			case "size":
+				field.size, _ = strconv.Atoi(kv[1])
+			}
+		}
+		if decl == "" {
+			continue
+		}
+
+		// The name is the last word of the declaration without the array length.
+		words := strings.Fields(decl)
+		name := words[len(words)-1]
+		if i := strings.IndexByte(name, '['); i >= 0 {
+			name = name[:i]
+		}
+		fields[strings.TrimLeft(name, "*")] = field
+	}
+	return fields
+}
+
+// tracepoint returns the ID and the fields of the tracepoint.
+func tracepoint(category, name string) (uint64, map[string]traceField, error) {
+	for _, root := range tracefsRoots {
+		dir := filepath.Join(root, "events", category, name)
+		b, err := ioutil.ReadFile(filepath.Join(dir, "id"))
+		if err != nil {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+		if err != nil {
+			return 0, nil, err
+		}
+		format, err := ioutil.ReadFile(filepath.Join(dir, "format"))
+		if err != nil {
+			return 0, nil, err
+		}
+		return id, parseTraceFormat(string(format)), nil
+	}
+	return 0, nil, fmt.Errorf("bpf: tracepoint %s:%s not found", category, name)
+}
+
+// kprobeType returns the type of the kprobe PMU.
+func kprobeType() (uint32, error) {
+	b, err := ioutil.ReadFile("/sys/bus/event_source/devices/kprobe/type")
+	if err != nil {
+		return 0, err
+	}
+	t, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 32)
+	return uint32(t), err
+}
+
+func loadSockOffsets() (sockOffsets, error) {
+	b, err := ioutil.ReadFile("/sys/kernel/btf/vmlinux")
+	if err != nil {
+		return sockOffsets{}, err
+	}
+	spec, err := parseBTF(b)
+	if err != nil {
+		return sockOffsets{}, err
+	}
+
+	var so sockOffsets
+	for member, off := range map[string]*int32{
+		"skc_family":       &so.family,
+		"skc_num":          &so.num,
+		"skc_rcv_saddr":    &so.rcvSaddr,
+		"skc_v6_rcv_saddr": &so.v6RcvSaddr,
+		"skc_dport":        &so.dport,
+		"skc_daddr":        &so.daddr,
+		"skc_v6_daddr":     &so.v6Daddr,
+	} {
+		v, err := spec.memberOffset("sock_common", member)
+		if err != nil {
+			return sockOffsets{}, err
+		}
+		*off = int32(v)
+	}
+	return so, nil
+}
+
+// loadSockProgram loads the sockProgram with bpf_probe_read_kernel, bpf_probe_read is
+// tried on the kernels older than 5.5.
+func loadSockProgram(p sockProbe, so sockOffsets, mapFd int) (int, error) {
+	var err error
+	for _, probeRead := range []int32{bpfFuncProbeReadKernel, bpfFuncProbeRead} {
+		var insns []byte
+		if insns, err = sockProgram(p, so, mapFd, probeRead); err != nil {
+			return -1, err
+		}
+		var fd int
+		if fd, err = bpfLoadProgram(p.progType, insns); err == nil {
+			return fd, nil
+		}
+	}
+	return -1, err
+}
+
+// attachSockTracepoints attaches the sockProgram to the sock:sock_send_length and
+// sock:sock_recv_length tracepoints available since Linux 6.4.
+func (m *bpfMap) attachSockTracepoints(so sockOffsets) error {
+	for _, name := range []string{"sock_send_length", "sock_recv_length"} {
+		id, fields, err := tracepoint("sock", name)
+		if err != nil {
+			return err
+		}
+		sk, ok1 := fields["sk"]
+		proto, ok2 := fields["protocol"]
+		if !ok1 || !ok2 {
+			return fmt.Errorf("bpf: unexpected format of sock:%s", name)
+		}
+
+		p := sockProbe{progType: bpfProgTypeTracepoint, skOffset: int16(sk.offset), protoOffset: int16(proto.offset)}
+		progFd, err := loadSockProgram(p, so, m.fd)
+		if err != nil {
+			return err
+		}
+		m.closer = append(m.closer, progFd)
+		if err := m.attach(&unix.PerfEventAttr{Type: unix.PERF_TYPE_TRACEPOINT, Config: id}, progFd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// attachSockKprobes attaches the sockProgram to the kprobes of the functions whose first
+// argument is the struct sock.
+func (m *bpfMap) attachSockKprobes(so sockOffsets) error {
+	if kprobeArg0Offset < 0 {
+		return errors.New("bpf: kprobes are not supported on the architecture")
+	}
+	pmu, err := kprobeType()
+	if err != nil {
+		return err
+	}
+
+	probes := []struct {
+		fn    string
+		proto int32
+	}{
+		{"tcp_sendmsg", unix.IPPROTO_TCP},
+		{"tcp_cleanup_rbuf", unix.IPPROTO_TCP},
+		{"udp_sendmsg", unix.IPPROTO_UDP},
+		{"udpv6_sendmsg", unix.IPPROTO_UDP},
+	}
+	for _, probe := range probes {
+		p := sockProbe{progType: bpfProgTypeKprobe, skOffset: kprobeArg0Offset, protoOffset: -1, protocol: probe.proto}
+		progFd, err := loadSockProgram(p, so, m.fd)
+		if err != nil {
+			return err
+		}
+		m.closer = append(m.closer, progFd)
+
+		fn, err := syscall.BytePtrFromString(probe.fn)
+		if err != nil {
+			return err
+		}
+		attr := &unix.PerfEventAttr{Type: pmu, Ext1: uint64(uintptr(unsafe.Pointer(fn)))}
+		err = m.attach(attr, progFd)
+		// Ext1 is an uint64 in unix.PerfEventAttr, the name is kept alive until attached.
+		runtime.KeepAlive(fn)
+		if err != nil {
+			return fmt.Errorf("bpf: attach kprobe %s: %v", probe.fn, err)
+		}
+	}
+	return nil
+}
+
+// loadBPF loads the programs which record the owners of the sockets into the map. The
+// sock tracepoints are preferred to the kprobes since they are stable across kernels.
+func loadBPF() (bpfSocketMap, error) {
+	so, err := loadSockOffsets()
+	if err != nil {
+		return nil, err
+	}
+
+	fd, err := bpfCreateSocketMap()
+	if err != nil {
+		return nil, err
+	}
+	m := &bpfMap{fd: fd}
+
+	if err := m.attachSockTracepoints(so); err != nil {
+		if kerr := m.attachSockKprobes(so); kerr != nil {
+			m.Close()
+			return nil, fmt.Errorf("%v, %v", err, kerr)
+		}
+	}
+
+	id, fields, err := tracepoint("sock", "inet_sock_set_state")
+	if err != nil {
+		m.Close()
+		return nil, err
+	}
+	insns, err := stateProgram(fields, m.fd)
+	if err != nil {
+		m.Close()
+		return nil, err
+	}
+	progFd, err := bpfLoadProgram(bpfProgTypeTracepoint, insns)
+	if err != nil {
+		m.Close()
+		return nil, err
+	}
+	m.closer = append(m.closer, progFd)
+	if err := m.attach(&unix.PerfEventAttr{Type: unix.PERF_TYPE_TRACEPOINT, Config: id}, progFd); err != nil {
+		m.Close()
+		return nil, err
+	}
+	return m, nil
+}
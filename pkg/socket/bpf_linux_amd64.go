@@ -0,0 +1,4 @@
+package socket
+
+// kprobeArg0Offset is the offset of the first argument in struct pt_regs, which is di.
+const kprobeArg0Offset = 112
@@ -0,0 +1,7 @@
+//go:build linux && !amd64 && !arm64
+// +build linux,!amd64,!arm64
+
+package socket
+
+// kprobeArg0Offset is negative since the kprobes are not supported on the architecture.
+const kprobeArg0Offset = -1
@@ -0,0 +1,173 @@
+//go:build linux
+// +build linux
+
+package socket
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	btfMagic = 0xeb9f
+
+	btfKindInt       = 1
+	btfKindPtr       = 2
+	btfKindArray     = 3
+	btfKindStruct    = 4
+	btfKindUnion     = 5
+	btfKindEnum      = 6
+	btfKindFwd       = 7
+	btfKindTypedef   = 8
+	btfKindVolatile  = 9
+	btfKindConst     = 10
+	btfKindRestrict  = 11
+	btfKindFunc      = 12
+	btfKindFuncProto = 13
+	btfKindVar       = 14
+	btfKindDatasec   = 15
+	btfKindFloat     = 16
+	btfKindDeclTag   = 17
+	btfKindTypeTag   = 18
+	btfKindEnum64    = 19
+)
+
+type btfMember struct {
+	name   string
+	typ    uint32
+	offset uint32 // in bits
+}
+
+type btfType struct {
+	name    string
+	kind    uint8
+	members []btfMember
+}
+
+// btfSpec is the minimal BTF parsed to find the offsets of the struct members, see
+// https://www.kernel.org/doc/html/latest/bpf/btf.html.
+type btfSpec struct {
+	types []btfType // indexed by the type ID, 0 is void
+}
+
+func parseBTF(b []byte) (*btfSpec, error) {
+	if len(b) < 24 {
+		return nil, errors.New("btf: header is too short")
+	}
+
+	var bo binary.ByteOrder = binary.LittleEndian
+	if binary.BigEndian.Uint16(b) == btfMagic {
+		bo = binary.BigEndian
+	} else if bo.Uint16(b) != btfMagic {
+		return nil, errors.New("btf: invalid magic")
+	}
+
+	hdrLen := bo.Uint32(b[4:])
+	typeOff, typeLen := bo.Uint32(b[8:]), bo.Uint32(b[12:])
+	strOff, strLen := bo.Uint32(b[16:]), bo.Uint32(b[20:])
+	if uint64(hdrLen)+uint64(typeOff)+uint64(typeLen) > uint64(len(b)) ||
+		uint64(hdrLen)+uint64(strOff)+uint64(strLen) > uint64(len(b)) {
+		return nil, errors.New("btf: sections out of range")
+	}
+	types := b[hdrLen+typeOff : hdrLen+typeOff+typeLen]
+	strs := b[hdrLen+strOff : hdrLen+strOff+strLen]
+
+	str := func(off uint32) string {
+		if off >= uint32(len(strs)) {
+			return ""
+		}
+		end := off
+		for end < uint32(len(strs)) && strs[end] != 0 {
+			end++
+		}
+		return string(strs[off:end])
+	}
+
+	spec := &btfSpec{types: []btfType{{}}}
+	for len(types) > 0 {
+		if len(types) < 12 {
+			return nil, errors.New("btf: truncated type")
+		}
+		info := bo.Uint32(types[4:])
+		vlen := int(info & 0xffff)
+		kind := uint8(info >> 24 & 0x1f)
+		kindFlag := info>>31 == 1
+		t := btfType{name: str(bo.Uint32(types)), kind: kind}
+		types = types[12:]
+
+		var extra int
+		switch kind {
+		case btfKindInt, btfKindVar, btfKindDeclTag:
+			extra = 4
+		case btfKindArray:
+			extra = 12
+		case btfKindStruct, btfKindUnion:
+			extra = vlen * 12
+			if len(types) < extra {
+				return nil, errors.New("btf: truncated members")
+			}
+			for i := 0; i < vlen; i++ {
+				m := types[i*12:]
+				offset := bo.Uint32(m[8:])
+				if kindFlag {
+					offset &= 0xffffff
+				}
+				t.members = append(t.members, btfMember{name: str(bo.Uint32(m)), typ: bo.Uint32(m[4:]), offset: offset})
+			}
+		case btfKindEnum, btfKindFuncProto:
+			extra = vlen * 8
+		case btfKindDatasec, btfKindEnum64:
+			extra = vlen * 12
+		case btfKindPtr, btfKindFwd, btfKindTypedef, btfKindVolatile, btfKindConst, btfKindRestrict,
+			btfKindFunc, btfKindFloat, btfKindTypeTag:
+		default:
+			return nil, fmt.Errorf("btf: unknown kind %d", kind)
+		}
+
+		if len(types) < extra {
+			return nil, errors.New("btf: truncated type")
+		}
+		types = types[extra:]
+		spec.types = append(spec.types, t)
+	}
+	return spec, nil
+}
+
+// memberOffset returns the offset in bytes of the member of the struct, the members of
+// the anonymous structs and unions are looked into.
+func (s *btfSpec) memberOffset(structName, member string) (uint32, error) {
+	for _, t := range s.types {
+		if t.kind != btfKindStruct || t.name != structName {
+			continue
+		}
+		if off, ok := s.findMember(t, member); ok {
+			if off%8 != 0 {
+				return 0, fmt.Errorf("btf: %s.%s is a bitfield", structName, member)
+			}
+			return off / 8, nil
+		}
+		return 0, fmt.Errorf("btf: %s.%s not found", structName, member)
+	}
+	return 0, fmt.Errorf("btf: struct %s not found", structName)
+}
+
+func (s *btfSpec) findMember(t btfType, member string) (uint32, bool) {
+	for _, m := range t.members {
+		if m.name == member {
+			return m.offset, true
+		}
+		if m.name != "" || int(m.typ) >= len(s.types) {
+			continue
+		}
+
+		inner := s.types[m.typ]
+		if inner.kind != btfKindStruct && inner.kind != btfKindUnion {
+			continue
+		}
+		if off, ok := s.findMember(inner, member); ok {
+			return m.offset + off, true
+		}
+	}
+	return 0, false
+}
@@ -1,17 +1,24 @@
 //go:build freebsd || darwin
 // +build freebsd darwin
 
-package main
+package socket
 
 import (
 	"bytes"
 	"context"
+	"net"
 	"os/exec"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/chenjiandongx/sniffer/pkg/capture"
 )
 
+// lsofTCPStates are the states of the TCP sockets to list, the TIME_WAIT sockets are left
+// out since they are not owned by any process.
+const lsofTCPStates = "ESTABLISHED,SYN_SENT,SYN_RCVD,FIN_WAIT_1,FIN_WAIT_2,CLOSE_WAIT,LAST_ACK,LISTEN,CLOSING"
+
 type lsofConn struct {
 	invoker Invoker
 }
@@ -27,7 +34,7 @@ func (i lsofInvoker) Exec() ([]byte, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx, "lsof", "-n", "-R", "-P", "-iTCP", "-iUDP", "-s", "TCP:ESTABLISHED", "+c", "0")
+	cmd := exec.CommandContext(ctx, "lsof", "-n", "-R", "-P", "-iTCP", "-iUDP", "-s", "TCP:"+lsofTCPStates, "+c", "0")
 
 	var buf bytes.Buffer
 	cmd.Stdout = &buf
@@ -62,39 +69,39 @@ func (lc *lsofConn) GetOpenSockets() (OpenSockets, error) {
 		pid, _ := strconv.Atoi(fields[1])
 		procInfo := ProcessInfo{Pid: pid, Name: procName}
 
+		var proto capture.Protocol
 		switch fields[8] {
 		case "TCP":
-			addr := strings.Split(fields[9], "->")
-			if len(addr) != 2 {
-				continue
-			}
-			ipport := strings.Split(addr[0], ":")
-			if len(ipport) != 2 {
-				continue
-			}
-			port, err := strconv.Atoi(ipport[1])
-			if err != nil {
-				continue
-			}
-			sockets[LocalSocket{IP: ipport[0], Port: uint16(port), Protocol: ProtoTCP}] = procInfo
-
+			proto = capture.ProtoTCP
 		case "UDP":
-			ipport := strings.Split(fields[9], ":")
-			if len(ipport) != 2 {
-				continue
-			}
-
-			port, err := strconv.Atoi(ipport[1])
-			if err != nil {
-				continue
-			}
-			sockets[LocalSocket{IP: ipport[0], Port: uint16(port), Protocol: ProtoUDP}] = procInfo
+			proto = capture.ProtoUDP
+		default:
+			continue
 		}
+
+		// The name is the local address followed by the remote one if connected, and the
+		// state of the TCP socket, eg. 127.0.0.1:53747->127.0.0.1:49152 (ESTABLISHED).
+		local := strings.Split(fields[9], "->")[0]
+		host, port, err := net.SplitHostPort(local)
+		if err != nil {
+			continue
+		}
+		p, err := strconv.Atoi(port)
+		if err != nil {
+			continue
+		}
+
+		listening := len(fields) > 10 && fields[10] == "(LISTEN)"
+		sockets.put(capture.LocalSocket{IP: host, Port: uint16(p), Protocol: proto}, procInfo, listening)
 	}
 
 	return sockets, nil
 }
 
-func GetSocketFetcher() SocketFetcher {
-	return &lsofConn{invoker: lsofInvoker{}}
+func NewFetcher(opt Options) (Fetcher, error) {
+	c := &lsofConn{invoker: lsofInvoker{}}
+	if opt.Backend == BackendEBPF {
+		return c, &FallbackError{Backend: opt.Backend, Err: errEBPFUnsupported}
+	}
+	return c, nil
 }
@@ -0,0 +1,37 @@
+package socket
+
+import (
+	"testing"
+
+	"github.com/chenjiandongx/sniffer/pkg/capture"
+	"github.com/stretchr/testify/assert"
+)
+
+type noopInvoker struct{}
+
+func (noopInvoker) Exec() ([]byte, error) {
+	output := `
+goland                          44546     1 chenjiandongx   14u  IPv4 0x22b93638598dd98d      0t0  UDP *:60203
+goland                          44546     1 chenjiandongx   17u  IPv4 0x22b93638598dfb3d      0t0  UDP *:8976
+wget                            44817 44815 chenjiandongx   19u  IPv4 0x22b9363883c47b35      0t0  TCP 127.0.0.1:53747->127.0.0.1:49152 (ESTABLISHED)
+nginx                           501     1 chenjiandongx    6u  IPv6 0x22b9363883c47b36      0t0  TCP [::1]:8080 (LISTEN)
+nginx                           502   501 chenjiandongx    7u  IPv6 0x22b9363883c47b37      0t0  TCP [::1]:8080->[::1]:53748 (CLOSE_WAIT)
+nginx                           501     1 chenjiandongx    8u  IPv6 0x22b9363883c47b38      0t0  TCP [::1]:8080 (LISTEN)`
+	return []byte(output), nil
+
+}
+
+func TestDarwinGetSockets(t *testing.T) {
+	conn := lsofConn{invoker: noopInvoker{}}
+	sockets, err := conn.GetOpenSockets()
+	assert.NoError(t, err)
+
+	expected := map[capture.LocalSocket]ProcessInfo{
+		{IP: "*", Port: 8976, Protocol: capture.ProtoUDP}:          {Pid: 44546, Name: "goland"},
+		{IP: "*", Port: 60203, Protocol: capture.ProtoUDP}:         {Pid: 44546, Name: "goland"},
+		{IP: "127.0.0.1", Port: 53747, Protocol: capture.ProtoTCP}: {Pid: 44817, Name: "wget"},
+		{IP: "::1", Port: 8080, Protocol: capture.ProtoTCP}:        {Pid: 502, Name: "nginx"},
+	}
+
+	assert.Equal(t, OpenSockets(expected), sockets)
+}
@@ -0,0 +1,711 @@
+//go:build linux
+// +build linux
+
+package socket
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/chenjiandongx/sniffer/pkg/capture"
+	"github.com/chenjiandongx/sniffer/pkg/netns"
+	"golang.org/x/sys/unix"
+)
+
+// The states of the sockets, see include/net/tcp_states.h.
+const (
+	tcpEstablished = uint8(0x01)
+	tcpSynSent     = uint8(0x02)
+	tcpSynRecv     = uint8(0x03)
+	tcpFinWait1    = uint8(0x04)
+	tcpFinWait2    = uint8(0x05)
+	tcpClose       = uint8(0x07)
+	tcpCloseWait   = uint8(0x08)
+	tcpLastAck     = uint8(0x09)
+	tcpListen      = uint8(0x0a)
+	tcpClosing     = uint8(0x0b)
+
+	// udpConnection is the state of the unconnected UDP sockets.
+	udpConnection = tcpClose
+
+	sizeOfInetDiagRequest = 72
+	sockDiagByFamily      = 20
+
+	// fullScanInterval is the least interval between the walks of the fds of all the
+	// processes, which retry the inodes that no process was found for.
+	fullScanInterval = 30 * time.Second
+)
+
+var nativeEndian binary.ByteOrder
+
+// getNativeEndian gets native endianness for the system
+func getNativeEndian() binary.ByteOrder {
+	if nativeEndian == nil {
+		var x uint32 = 0x01020304
+		if *(*byte)(unsafe.Pointer(&x)) == 0x01 {
+			nativeEndian = binary.BigEndian
+		} else {
+			nativeEndian = binary.LittleEndian
+		}
+	}
+	return nativeEndian
+}
+
+type be16 [2]byte
+
+// Int be16 to int
+func (v be16) Int() int {
+	v2 := *(*uint16)(unsafe.Pointer(&v))
+	return int(v.Swap(v2))
+}
+
+// Swap swaps a 16 bit value if we aren't big endian
+func (v be16) Swap(i uint16) uint16 {
+	if getNativeEndian() == binary.BigEndian {
+		return i
+	}
+	return (i&0xff00)>>8 | (i&0xff)<<8
+}
+
+// PortHex parses be16 to hex
+func (v be16) PortHex() string {
+	return hex.EncodeToString(v[0:])
+}
+
+type be32 [4]byte
+
+// inetDiagSockID sock_diag
+/* inet_diag.h
+struct inet_diag_sockid {
+	__be16  idiag_sport;
+	__be16  idiag_dport;
+	__be32  idiag_src[4];
+	__be32  idiag_dst[4];
+	__u32   idiag_if;
+	__u32   idiag_cookie[2];
+#define INET_DIAG_NOCOOKIE (~0U)
+};
+*/
+type inetDiagSockID struct {
+	IdiagSport  be16
+	IdiagDport  be16
+	IdiagSrc    [4]be32
+	IdiagDst    [4]be32
+	IdiagIF     uint32
+	IdiagCookie [2]uint32
+}
+
+// inetDiagReqV2 sock_diag
+/* inet_diag.h
+struct inet_diag_req_v2 {
+        __u8    sdiag_family;
+        __u8    sdiag_protocol;
+        __u8    idiag_ext;
+        __u8    pad;
+        __u32   idiag_states;
+        struct inet_diag_sockid id;
+};
+*/
+type inetDiagReqV2 struct {
+	Family   uint8
+	Protocol uint8
+	Ext      uint8
+	Pad      uint8
+	States   uint32
+	ID       inetDiagSockID
+}
+
+// inetDiagMsg receiv msg
+/* inet_diag.h
+Base info structure. It contains Socket identity (addrs/ports/cookie) and, alas, the information shown by netstat.
+struct inet_diag_msg {
+        __u8    idiag_family;
+        __u8    idiag_state;
+        __u8    idiag_timer;
+        __u8    idiag_retrans;
+
+        struct inet_diag_sockid id;
+
+        __u32   idiag_expires;
+        __u32   idiag_rqueue;
+        __u32   idiag_wqueue;
+        __u32   idiag_uid;
+        __u32   idiag_inode;
+};
+*/
+type inetDiagMsg struct {
+	IDiagFamily  uint8
+	IDiagState   uint8
+	IDiagTimer   uint8
+	IDiagRetrans uint8
+	ID           inetDiagSockID
+	IDiagExpires uint32
+	IDiagRqueue  uint32
+	IDiagWqueue  uint32
+	IDiagUid     uint32
+	IDiagInode   uint32
+}
+
+// inetDiagRequest diag_request
+/* go/src/syscall/ztypes_linux_amd64.go
+type NlMsghdr struct {
+        Len   uint32
+        Type  uint16
+        Flags uint16
+        Seq   uint32
+        Pid   uint32
+}
+*/
+type inetDiagRequest struct {
+	Nlh     syscall.NlMsghdr
+	ReqDiag inetDiagReqV2
+}
+
+// sockEntry is the socket reported by sock_diag, the owner is found by the inode.
+type sockEntry struct {
+	socket    capture.LocalSocket
+	inode     uint32
+	listening bool
+}
+
+// procKey tells apart the processes which reuse the same pid by the start time.
+type procKey struct {
+	pid   int32
+	start uint64
+}
+
+type netlinkConn struct {
+	procRoot   string
+	namespaces []netns.Namespace
+
+	// The owners of the socket inodes are cached between the fetches. The fds of the
+	// processes are walked only if sock_diag reports an unknown inode, and only until
+	// the unknown inodes are found.
+	inodes     map[uint32]procKey
+	procs      map[procKey]ProcessInfo
+	fullScan   time.Time
+	unresolved map[uint32]bool
+
+	// lastSockets is the sockets of the last fetch, the ones closed since then are kept
+	// for another fetch so that their final traffic is still attributed.
+	lastSockets OpenSockets
+}
+
+// ipv4 be32 to string
+func (nl *netlinkConn) ipv4(b be32) string {
+	return net.IPv4(b[0], b[1], b[2], b[3]).String()
+}
+
+// ipv6 be32 to string
+func (nl *netlinkConn) ipv6(b [4]be32) string {
+	ip := make(net.IP, net.IPv6len)
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 4; j++ {
+			ip[4*i+j] = b[i][j]
+		}
+	}
+	return ip.String()
+}
+
+// ipHex2String ip hex to string
+func (nl *netlinkConn) ipHex2String(family uint8, ip [4]be32) (string, error) {
+	switch family {
+	case unix.AF_INET:
+		return nl.ipv4(ip[0]), nil
+	case unix.AF_INET6:
+		return nl.ipv6(ip), nil
+	default:
+		return "", errors.New("family is not unix.AF_INET or unix.AF_INET6")
+	}
+}
+
+// sockdiagSend sends netlinkConn msgs
+// see https://github.com/sivasankariit/iproute2/blob/1179ab033c31d2c67f406be5bcd5e4c0685855fe/misc/ss.c#L1575-L1640
+func (nl *netlinkConn) sockdiagSend(proto, family uint8, states uint32) (skfd int, err error) {
+	if skfd, err = unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW, unix.NETLINK_SOCK_DIAG); err != nil {
+		return -1, err
+	}
+
+	var diagReq inetDiagRequest
+	diagReq.Nlh.Type = sockDiagByFamily
+
+	// man 7 netlinkConn: NLM_F_DUMP Convenience macro; equivalent to (NLM_F_ROOT|NLM_F_MATCH).
+	diagReq.Nlh.Flags = unix.NLM_F_DUMP | unix.NLM_F_REQUEST
+	diagReq.ReqDiag.Family = family
+	diagReq.ReqDiag.Protocol = proto
+	diagReq.ReqDiag.States = states
+	diagReq.Nlh.Len = uint32(unsafe.Sizeof(diagReq))
+
+	buffer := make([]byte, sizeOfInetDiagRequest)
+	*(*inetDiagRequest)(unsafe.Pointer(&buffer[0])) = diagReq
+
+	sockAddrNl := unix.SockaddrNetlink{Family: syscall.AF_NETLINK}
+	timeout := syscall.NsecToTimeval((200 * time.Millisecond).Nanoseconds())
+	if err = syscall.SetsockoptTimeval(skfd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &timeout); err != nil {
+		return 0, err
+	}
+
+	if err = unix.Sendmsg(skfd, buffer, nil, &sockAddrNl, 0); err != nil {
+		return -1, err
+	}
+	return skfd, nil
+}
+
+func (nl *netlinkConn) sockdiagRecv(skfd, proto int) ([]sockEntry, error) {
+	var entries []sockEntry
+	buffer := make([]byte, os.Getpagesize())
+loop:
+	for {
+		n, _, _, _, err := unix.Recvmsg(skfd, buffer, nil, 0)
+		if err != nil {
+			return entries, err
+		}
+
+		if n == 0 {
+			break loop
+		}
+
+		msgs, err := syscall.ParseNetlinkMessage(buffer[:n])
+		if err != nil {
+			return entries, err
+		}
+
+		for _, msg := range msgs {
+			if msg.Header.Type == syscall.NLMSG_DONE {
+				break loop
+			}
+
+			m := (*inetDiagMsg)(unsafe.Pointer(&msg.Data[0]))
+			srcIP, _ := nl.ipHex2String(m.IDiagFamily, m.ID.IdiagSrc)
+
+			// The header pid is the netlink port ID, the owner is found by the inode instead.
+			// The orphaned sockets have no inode.
+			if m.IDiagInode == 0 {
+				continue
+			}
+
+			var p capture.Protocol
+			switch proto {
+			case syscall.IPPROTO_TCP:
+				p = capture.ProtoTCP
+			case syscall.IPPROTO_UDP:
+				p = capture.ProtoUDP
+			}
+			entries = append(entries, sockEntry{
+				socket:    capture.LocalSocket{IP: wildcardIP(srcIP), Port: uint16(m.ID.IdiagSport.Int()), Protocol: p},
+				inode:     m.IDiagInode,
+				listening: m.IDiagState == tcpListen,
+			})
+		}
+	}
+
+	return entries, nil
+}
+
+func (nl *netlinkConn) getSockEntries() ([]sockEntry, error) {
+	var entries []sockEntry
+
+	type Req struct {
+		Protocol int
+		Family   uint8
+		State    uint32
+	}
+
+	// The TIME_WAIT sockets are left out since they are not owned by any process, the
+	// traffic of them falls back to the listening sockets on lookup.
+	tcpStates := uint32(1<<tcpEstablished | 1<<tcpSynSent | 1<<tcpSynRecv | 1<<tcpFinWait1 | 1<<tcpFinWait2 |
+		1<<tcpCloseWait | 1<<tcpLastAck | 1<<tcpListen | 1<<tcpClosing)
+	udpStates := uint32(1<<tcpEstablished | 1<<udpConnection)
+
+	reqs := []Req{
+		{syscall.IPPROTO_TCP, syscall.AF_INET, tcpStates},
+		{syscall.IPPROTO_TCP, syscall.AF_INET6, tcpStates},
+		{syscall.IPPROTO_UDP, syscall.AF_INET, udpStates},
+		{syscall.IPPROTO_UDP, syscall.AF_INET6, udpStates},
+	}
+
+	type Fd struct {
+		fd, proto int
+	}
+	var fds []Fd
+	for _, req := range reqs {
+		fd, err := nl.sockdiagSend(uint8(req.Protocol), req.Family, req.State)
+		if err != nil {
+			return nil, err
+		}
+
+		defer syscall.Close(fd)
+		fds = append(fds, Fd{fd, req.Protocol})
+	}
+
+	for _, fd := range fds {
+		m, err := nl.sockdiagRecv(fd.fd, fd.proto)
+		if err != nil {
+			return entries, err
+		}
+		entries = append(entries, m...)
+	}
+
+	return entries, nil
+}
+
+// invalidate forgets the processes which have exited and the inodes owned by them, the
+// sockets which they pass on to the other processes are looked for again.
+func (nl *netlinkConn) invalidate(pids []int32) {
+	alive := make(map[int32]bool, len(pids))
+	for _, pid := range pids {
+		alive[pid] = true
+	}
+
+	var exited bool
+	for key := range nl.procs {
+		if !alive[key.pid] {
+			delete(nl.procs, key)
+			exited = true
+		}
+	}
+	if !exited {
+		return
+	}
+	for inode, key := range nl.inodes {
+		if _, ok := nl.procs[key]; !ok {
+			delete(nl.inodes, inode)
+		}
+	}
+}
+
+// procStartTime reads the start time of the process in the clock ticks since boot, it is
+// 0 if unreadable.
+func (nl *netlinkConn) procStartTime(pid int32) uint64 {
+	b, err := ioutil.ReadFile(nl.procPath(pid, "stat"))
+	if err != nil {
+		return 0
+	}
+
+	// pid (comm) state ppid ... starttime is the 22nd field, the comm may contain spaces
+	// and parentheses.
+	fields := strings.Fields(string(b[bytes.LastIndexByte(b, ')')+1:]))
+	if len(fields) < 20 {
+		return 0
+	}
+	start, _ := strconv.ParseUint(fields[19], 10, 64)
+	return start
+}
+
+// scanInodes walks the fds of the processes for the wanted socket inodes and maps the
+// found ones to their owners, the walk stops once all of them are found. The found
+// inodes are removed from wanted, and the processes are read from /proc/<pid> only if
+// they are not cached.
+func (nl *netlinkConn) scanInodes(pids []int32, wanted map[uint32]bool) {
+	if nl.inodes == nil {
+		nl.inodes = make(map[uint32]procKey)
+		nl.procs = make(map[procKey]ProcessInfo)
+	}
+
+	for _, pid := range pids {
+		if len(wanted) == 0 {
+			return
+		}
+
+		pidInodes, err := nl.getProcInodes(pid)
+		if err != nil {
+			continue
+		}
+
+		var key *procKey
+		for _, inode := range pidInodes {
+			if !wanted[inode] {
+				continue
+			}
+			if key == nil {
+				key = &procKey{pid: pid, start: nl.procStartTime(pid)}
+				if _, ok := nl.procs[*key]; !ok {
+					nl.procs[*key] = nl.getProcInfo(pid)
+				}
+			}
+			nl.inodes[inode] = *key
+			delete(wanted, inode)
+		}
+	}
+}
+
+// lookupInode returns the process which owns the socket inode.
+func (nl *netlinkConn) lookupInode(inode uint32) (ProcessInfo, bool) {
+	key, ok := nl.inodes[inode]
+	if !ok {
+		return ProcessInfo{}, false
+	}
+	procInfo, ok := nl.procs[key]
+	return procInfo, ok
+}
+
+// prune forgets the inodes which are closed and the processes which own none of the
+// open ones.
+func (nl *netlinkConn) prune(entries []sockEntry) {
+	open := make(map[uint32]bool, len(entries))
+	for _, e := range entries {
+		open[e.inode] = true
+	}
+
+	owners := make(map[procKey]bool)
+	for inode, key := range nl.inodes {
+		if !open[inode] {
+			delete(nl.inodes, inode)
+			continue
+		}
+		owners[key] = true
+	}
+	for key := range nl.procs {
+		if !owners[key] {
+			delete(nl.procs, key)
+		}
+	}
+}
+
+// resolve finds the owners of the sockets. The fds are walked only for the inodes which
+// are neither cached nor left unresolved by the last fetch. The unresolved inodes are
+// retried once per fullScanInterval, they may be owned by the processes invisible under
+// /proc, eg. in the other namespaces.
+func (nl *netlinkConn) resolve(pids []int32, entries []sockEntry) OpenSockets {
+	nl.invalidate(pids)
+
+	retry := time.Since(nl.fullScan) >= fullScanInterval
+	unknown := make(map[uint32]bool)
+	for _, e := range entries {
+		if _, ok := nl.lookupInode(e.inode); !ok && (retry || !nl.unresolved[e.inode]) {
+			unknown[e.inode] = true
+		}
+	}
+	if len(unknown) > 0 {
+		nl.scanInodes(pids, unknown)
+		// The fds of all the processes are walked if any inode is not found.
+		if len(unknown) > 0 {
+			nl.fullScan = time.Now()
+		}
+	}
+	nl.prune(entries)
+
+	sockets := make(OpenSockets)
+	unresolved := make(map[uint32]bool)
+	for _, e := range entries {
+		procInfo, ok := nl.lookupInode(e.inode)
+		if !ok {
+			unresolved[e.inode] = true
+			continue
+		}
+		sockets.put(e.socket, procInfo, e.listening)
+	}
+	nl.unresolved = unresolved
+
+	previous := nl.lastSockets
+	nl.lastSockets = sockets
+
+	result := make(OpenSockets, len(sockets))
+	for k, v := range previous {
+		result[k] = v
+	}
+	for k, v := range sockets {
+		result[k] = v
+	}
+	return result
+}
+
+// getProcInfo reads the process from /proc/<pid>, the name is the base of its executable
+// or the comm if the executable is unreadable, eg. the process of the other users.
+func (nl *netlinkConn) getProcInfo(pid int32) ProcessInfo {
+	procInfo := ProcessInfo{Pid: int(pid), Container: nl.getProcContainer(pid)}
+
+	if exe, err := os.Readlink(nl.procPath(pid, "exe")); err == nil {
+		procInfo.Name = filepath.Base(exe)
+	} else if comm, err := ioutil.ReadFile(nl.procPath(pid, "comm")); err == nil {
+		procInfo.Name = strings.TrimSpace(string(comm))
+	}
+
+	// The arguments are separated by NUL in /proc/<pid>/cmdline.
+	if cmdline, err := ioutil.ReadFile(nl.procPath(pid, "cmdline")); err == nil {
+		procInfo.Cmdline = strings.TrimSpace(strings.ReplaceAll(string(cmdline), "\x00", " "))
+	}
+	return procInfo
+}
+
+func (nl *netlinkConn) procPath(pid int32, elem ...string) string {
+	return filepath.Join(append([]string{nl.procRoot, strconv.Itoa(int(pid))}, elem...)...)
+}
+
+// getProcContainer resolves the container of the process from its cgroup.
+func (nl *netlinkConn) getProcContainer(pid int32) string {
+	b, err := ioutil.ReadFile(nl.procPath(pid, "cgroup"))
+	if err != nil {
+		return ""
+	}
+	return parseCgroupContainer(string(b))
+}
+
+var (
+	// containerIDRegex matches the container ID in the cgroup path, eg. /docker/<id>,
+	// /kubepods/burstable/pod<uid>/<id> or /kubepods.slice/.../cri-containerd-<id>.scope
+	containerIDRegex = regexp.MustCompile(`[0-9a-f]{64}`)
+
+	// podUIDRegex matches the pod UID in the cgroup path, the systemd cgroup driver
+	// replaces the dashes of it with underscores.
+	podUIDRegex = regexp.MustCompile(`pod([0-9a-f]{8}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{12})`)
+)
+
+// parseCgroupContainer parses the content of /proc/<pid>/cgroup, it returns
+// `<pod uid>/<short container id>` for the kubernetes pods, `<short container id>` for
+// the other containers or the cgroup path of the process otherwise.
+func parseCgroupContainer(content string) string {
+	var cgroupPath, unifiedPath string
+	for _, line := range strings.Split(content, "\n") {
+		// hierarchy-ID:controller-list:cgroup-path
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+
+		if containerIDRegex.MatchString(fields[2]) {
+			cgroupPath = fields[2]
+			break
+		}
+		if fields[0] == "0" && fields[1] == "" {
+			unifiedPath = fields[2]
+		}
+		if cgroupPath == "" {
+			cgroupPath = fields[2]
+		}
+	}
+
+	if !containerIDRegex.MatchString(cgroupPath) {
+		if unifiedPath != "" {
+			return unifiedPath
+		}
+		return cgroupPath
+	}
+
+	ids := containerIDRegex.FindAllString(cgroupPath, -1)
+	shortID := ids[len(ids)-1][:12]
+	if m := podUIDRegex.FindStringSubmatch(cgroupPath); m != nil {
+		return strings.ReplaceAll(m[1], "_", "-") + "/" + shortID
+	}
+	return shortID
+}
+
+func (nl *netlinkConn) getProcInodes(pid int32) ([]uint32, error) {
+	var inodeFds []uint32
+	f, err := os.Open(nl.procPath(pid, "fd"))
+	if err != nil {
+		return inodeFds, err
+	}
+	defer f.Close()
+
+	files, err := f.Readdirnames(0)
+	if err != nil {
+		return inodeFds, err
+	}
+
+	for _, file := range files {
+		inode, err := os.Readlink(nl.procPath(pid, "fd", file))
+		if err != nil {
+			continue
+		}
+		// Socket:[1070205860]
+		if !strings.HasPrefix(inode, "socket:[") {
+			continue
+		}
+
+		inodeInt, err := strconv.Atoi(inode[8 : len(inode)-1])
+		if err != nil {
+			continue
+		}
+		inodeFds = append(inodeFds, uint32(inodeInt))
+	}
+	return inodeFds, nil
+}
+
+func (nl *netlinkConn) listPids() ([]int32, error) {
+	var pids []int32
+	d, err := os.Open(nl.procRoot)
+	if err != nil {
+		return pids, err
+	}
+	defer d.Close()
+
+	fnames, err := d.Readdirnames(-1)
+	if err != nil {
+		return pids, err
+	}
+
+	for _, fname := range fnames {
+		pid, err := strconv.ParseInt(fname, 10, 32)
+		if err != nil {
+			continue
+		}
+		pids = append(pids, int32(pid))
+	}
+
+	return pids, nil
+}
+
+func (nl *netlinkConn) GetOpenSockets() (OpenSockets, error) {
+	pids, err := nl.listPids()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(nl.namespaces) == 1 && nl.namespaces[0].Name == "" {
+		entries, err := nl.getSockEntries()
+		if err != nil {
+			return nil, err
+		}
+		return nl.resolve(pids, entries), nil
+	}
+
+	// The inodes are unique across the namespaces, so the processes found under /proc
+	// are shared by the sock_diag queries inside each namespace.
+	var entries []sockEntry
+	for _, ns := range nl.namespaces {
+		var m []sockEntry
+		err := ns.Do(func() (err error) {
+			m, err = nl.getSockEntries()
+			return err
+		})
+		if err != nil && len(nl.namespaces) == 1 {
+			return nil, err
+		}
+
+		for _, e := range m {
+			e.socket.Netns = ns.Name
+			entries = append(entries, e)
+		}
+	}
+	return nl.resolve(pids, entries), nil
+}
+
+func NewFetcher(opt Options) (Fetcher, error) {
+	namespaces := opt.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []netns.Namespace{{}}
+	}
+
+	nl := &netlinkConn{procRoot: "/proc", namespaces: namespaces}
+	if opt.Backend == BackendEBPF {
+		c, err := newEBPFConn(nl, loadBPF)
+		if err != nil {
+			return nl, &FallbackError{Backend: opt.Backend, Err: err}
+		}
+		return c, nil
+	}
+	return nl, nil
+}
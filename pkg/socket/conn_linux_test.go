@@ -0,0 +1,203 @@
+//go:build linux
+// +build linux
+
+package socket
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chenjiandongx/sniffer/pkg/capture"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCgroupContainer(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected string
+	}{
+		{
+			name: "cgroup v2 kubernetes pod with systemd driver",
+			content: `0::/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod0d1c6b5a_8c4d_4e1a_9f0e_2b7d3c9a1e55.slice/cri-containerd-3f2a9c1b7e6d5c4b3a2918f7e6d5c4b3a2918f7e6d5c4b3a2918f7e6d5c4b3a2.scope
+`,
+			expected: "0d1c6b5a-8c4d-4e1a-9f0e-2b7d3c9a1e55/3f2a9c1b7e6d",
+		},
+		{
+			name: "cgroup v1 kubernetes pod",
+			content: `12:pids:/kubepods/besteffort/pod9f8e7d6c-5b4a-3928-1706-f5e4d3c2b1a0/8a7b6c5d4e3f2a1b0c9d8e7f6a5b4c3d2e1f0a9b8c7d6e5f4a3b2c1d0e9f8a7b
+11:memory:/kubepods/besteffort/pod9f8e7d6c-5b4a-3928-1706-f5e4d3c2b1a0/8a7b6c5d4e3f2a1b0c9d8e7f6a5b4c3d2e1f0a9b8c7d6e5f4a3b2c1d0e9f8a7b
+1:name=systemd:/kubepods/besteffort/pod9f8e7d6c-5b4a-3928-1706-f5e4d3c2b1a0/8a7b6c5d4e3f2a1b0c9d8e7f6a5b4c3d2e1f0a9b8c7d6e5f4a3b2c1d0e9f8a7b
+`,
+			expected: "9f8e7d6c-5b4a-3928-1706-f5e4d3c2b1a0/8a7b6c5d4e3f",
+		},
+		{
+			name: "docker container",
+			content: `0::/system.slice/docker-1a2b3c4d5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b1c2d3e4f5a6b7c8d9e0f1a2b.scope
+`,
+			expected: "1a2b3c4d5e6f",
+		},
+		{
+			name: "host process",
+			content: `0::/system.slice/nginx.service
+`,
+			expected: "/system.slice/nginx.service",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, parseCgroupContainer(tt.content))
+		})
+	}
+}
+
+// fakeProc lays out a process under the fake /proc tree, the fds map the fd numbers to
+// the link targets and exe is not created if it is empty.
+func fakeProc(t *testing.T, root, pid, exe, comm, cmdline string, fds map[string]string) {
+	dir := filepath.Join(root, pid)
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "fd"), 0755))
+	if exe != "" {
+		assert.NoError(t, os.Symlink(exe, filepath.Join(dir, "exe")))
+	}
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "comm"), []byte(comm+"\n"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "cmdline"), []byte(cmdline), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "cgroup"), []byte("0::/system.slice/"+comm+".service\n"), 0644))
+	fakeProcStart(t, root, pid, comm, 100)
+	for fd, target := range fds {
+		assert.NoError(t, os.Symlink(target, filepath.Join(dir, "fd", fd)))
+	}
+}
+
+// fakeProcStart writes /proc/<pid>/stat with the start time of the process.
+func fakeProcStart(t *testing.T, root, pid, comm string, start int) {
+	stat := fmt.Sprintf("%s (%s) S%s %d 0 0\n", pid, comm, strings.Repeat(" 0", 18), start)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(root, pid, "stat"), []byte(stat), 0644))
+}
+
+func TestScanInodes(t *testing.T) {
+	root, err := ioutil.TempDir("", "proc")
+	assert.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	fakeProc(t, root, "1234", "/usr/sbin/nginx", "nginx", "nginx: master process\x00-g\x00daemon off;\x00", map[string]string{
+		"0": "/dev/null",
+		"6": "socket:[1000]",
+		"7": "socket:[1001]",
+	})
+	fakeProc(t, root, "5678", "", "sshd", "sshd: root@pts/0\x00", map[string]string{
+		"3": "socket:[2000]",
+	})
+	fakeProcStart(t, root, "5678", "sshd: (priv)", 200)
+	fakeProc(t, root, "9999", "/usr/bin/cat", "cat", "cat\x00", map[string]string{
+		"1": "pipe:[3000]",
+	})
+	assert.NoError(t, os.MkdirAll(filepath.Join(root, "self"), 0755))
+
+	nl := &netlinkConn{procRoot: root}
+	pids, err := nl.listPids()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []int32{1234, 5678, 9999}, pids)
+
+	nginx := ProcessInfo{
+		Pid:       1234,
+		Name:      "nginx",
+		Cmdline:   "nginx: master process -g daemon off;",
+		Container: "/system.slice/nginx.service",
+	}
+	sshd := ProcessInfo{
+		Pid:       5678,
+		Name:      "sshd",
+		Cmdline:   "sshd: root@pts/0",
+		Container: "/system.slice/sshd.service",
+	}
+	wanted := map[uint32]bool{1000: true, 1001: true, 2000: true, 4000: true}
+	nl.scanInodes(pids, wanted)
+	nginxKey, sshdKey := procKey{pid: 1234, start: 100}, procKey{pid: 5678, start: 200}
+	assert.Equal(t, map[uint32]procKey{1000: nginxKey, 1001: nginxKey, 2000: sshdKey}, nl.inodes)
+	assert.Equal(t, map[procKey]ProcessInfo{nginxKey: nginx, sshdKey: sshd}, nl.procs)
+	assert.Equal(t, map[uint32]bool{4000: true}, wanted)
+}
+
+func TestResolveCache(t *testing.T) {
+	root, err := ioutil.TempDir("", "proc")
+	assert.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	fakeProc(t, root, "1234", "/usr/sbin/nginx", "nginx", "nginx\x00", map[string]string{"6": "socket:[1000]"})
+	fakeProc(t, root, "5678", "/usr/bin/curl", "curl", "curl\x00", map[string]string{"3": "socket:[2000]"})
+
+	nginx := capture.LocalSocket{IP: "*", Port: 80, Protocol: capture.ProtoTCP}
+	accepted := capture.LocalSocket{IP: "10.0.0.1", Port: 80, Protocol: capture.ProtoTCP}
+	curl := capture.LocalSocket{IP: "10.0.0.1", Port: 50000, Protocol: capture.ProtoTCP}
+
+	nl := &netlinkConn{procRoot: root}
+	resolve := func(entries ...sockEntry) OpenSockets {
+		pids, err := nl.listPids()
+		assert.NoError(t, err)
+		return nl.resolve(pids, entries)
+	}
+
+	sockets := resolve(sockEntry{socket: nginx, inode: 1000, listening: true}, sockEntry{socket: curl, inode: 2000})
+	assert.Equal(t, "nginx", sockets[nginx].Name)
+	assert.Equal(t, "curl", sockets[curl].Name)
+
+	nginxKey, curlKey := procKey{pid: 1234, start: 100}, procKey{pid: 5678, start: 100}
+
+	// The cached processes are not read again while the inodes are known.
+	assert.NoError(t, os.Remove(filepath.Join(root, "1234", "exe")))
+	assert.NoError(t, os.Symlink("/usr/sbin/other", filepath.Join(root, "1234", "exe")))
+	assert.NoError(t, os.Symlink("socket:[1001]", filepath.Join(root, "1234", "fd", "7")))
+	resolve(sockEntry{socket: nginx, inode: 1000, listening: true}, sockEntry{socket: curl, inode: 2000})
+	assert.Equal(t, map[uint32]procKey{1000: nginxKey, 2000: curlKey}, nl.inodes)
+
+	// The unknown inodes are looked for in the fds at once, even though the process which
+	// replaces a socket keeps its fd count. The cached process is not read again, the
+	// closed inode is forgotten and the unresolved one does not trigger the walk again.
+	curl2 := capture.LocalSocket{IP: "10.0.0.1", Port: 50001, Protocol: capture.ProtoTCP}
+	assert.NoError(t, os.Remove(filepath.Join(root, "5678", "fd", "3")))
+	assert.NoError(t, os.Symlink("socket:[2001]", filepath.Join(root, "5678", "fd", "3")))
+	entries := []sockEntry{
+		{socket: nginx, inode: 1000, listening: true},
+		{socket: accepted, inode: 1001},
+		{socket: curl2, inode: 2001},
+		{socket: capture.LocalSocket{IP: "10.0.0.1", Port: 22, Protocol: capture.ProtoTCP}, inode: 3000},
+	}
+	sockets = resolve(entries...)
+	assert.Equal(t, "nginx", sockets[accepted].Name)
+	assert.Equal(t, "curl", sockets[curl2].Name)
+	assert.Equal(t, map[uint32]procKey{1000: nginxKey, 1001: nginxKey, 2001: curlKey}, nl.inodes)
+	assert.Equal(t, map[uint32]bool{3000: true}, nl.unresolved)
+
+	// The unresolved inodes are retried once per fullScanInterval.
+	fakeProc(t, root, "4321", "/usr/sbin/sshd", "sshd", "sshd\x00", map[string]string{"3": "socket:[3000]"})
+	resolve(entries...)
+	assert.Equal(t, map[uint32]bool{3000: true}, nl.unresolved)
+	nl.fullScan = time.Now().Add(-fullScanInterval)
+	sockets = resolve(entries...)
+	assert.Equal(t, "sshd", sockets[entries[3].socket].Name)
+	assert.Empty(t, nl.unresolved)
+
+	// The reused pid is read again for its new socket, the process which exited before is
+	// forgotten along with its sockets.
+	fakeProcStart(t, root, "1234", "other", 300)
+	assert.NoError(t, os.Symlink("socket:[1002]", filepath.Join(root, "1234", "fd", "8")))
+	sockets = resolve(sockEntry{socket: curl2, inode: 2001}, sockEntry{socket: accepted, inode: 1002})
+	assert.Equal(t, "other", sockets[accepted].Name)
+	assert.NotContains(t, nl.procs, nginxKey)
+
+	// The exited process is invalidated, its closed socket is kept for another fetch.
+	assert.NoError(t, os.RemoveAll(filepath.Join(root, "5678")))
+	sockets = resolve(sockEntry{socket: nginx, inode: 1000, listening: true})
+	assert.Equal(t, "curl", sockets[curl2].Name)
+	assert.NotContains(t, nl.procs, curlKey)
+	assert.NotContains(t, nl.inodes, uint32(2001))
+
+	sockets = resolve(sockEntry{socket: nginx, inode: 1000, listening: true})
+	assert.Equal(t, OpenSockets{nginx: nl.procs[procKey{pid: 1234, start: 300}]}, sockets)
+}
@@ -0,0 +1,74 @@
+//go:build windows
+// +build windows
+
+package socket
+
+import (
+	"path/filepath"
+
+	"github.com/chenjiandongx/sniffer/pkg/capture"
+	"github.com/shirou/gopsutil/net"
+	"github.com/shirou/gopsutil/process"
+)
+
+type psutilConn struct{}
+
+func (ps *psutilConn) GetOpenSockets() (OpenSockets, error) {
+	openSockets := make(OpenSockets)
+	if err := ps.getConnections(capture.ProtoTCP, openSockets); err != nil {
+		return nil, err
+	}
+	if err := ps.getConnections(capture.ProtoUDP, openSockets); err != nil {
+		return nil, err
+	}
+
+	return openSockets, nil
+}
+
+func (ps *psutilConn) getProcName(pid int32) ProcessInfo {
+	procInfo := ProcessInfo{Name: UnknownProcessName}
+
+	proc, err := process.NewProcess(pid)
+	if err != nil {
+		return procInfo
+	}
+	exe, err := proc.Exe()
+	if err != nil {
+		return procInfo
+	}
+
+	procInfo.Pid = int(pid)
+	procInfo.Name = filepath.Base(exe)
+	procInfo.Cmdline, _ = proc.Cmdline()
+	return procInfo
+}
+
+func (ps *psutilConn) getConnections(proto capture.Protocol, openSockets OpenSockets) error {
+	connections, err := net.Connections(string(proto))
+	if err != nil {
+		return err
+	}
+
+	for _, conn := range connections {
+		// The TIME_WAIT sockets are left out since they are not owned by any process.
+		if proto == capture.ProtoTCP && (conn.Status == "TIME_WAIT" || conn.Status == "CLOSE" || conn.Pid == 0) {
+			continue
+		}
+
+		localSocket := capture.LocalSocket{
+			IP:       wildcardIP(conn.Laddr.IP),
+			Port:     uint16(conn.Laddr.Port),
+			Protocol: proto,
+		}
+		openSockets.put(localSocket, ps.getProcName(conn.Pid), conn.Status == "LISTEN")
+	}
+	return nil
+}
+
+func NewFetcher(opt Options) (Fetcher, error) {
+	c := &psutilConn{}
+	if opt.Backend == BackendEBPF {
+		return c, &FallbackError{Backend: opt.Backend, Err: errEBPFUnsupported}
+	}
+	return c, nil
+}
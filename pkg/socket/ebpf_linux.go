@@ -0,0 +1,172 @@
+//go:build linux
+// +build linux
+
+package socket
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"time"
+
+	"github.com/chenjiandongx/sniffer/pkg/capture"
+	"golang.org/x/sys/unix"
+)
+
+// reseedInterval is the interval to fetch the seed again, so that the sockets closed
+// without being recorded by the programs are not attributed to their owners forever.
+const reseedInterval = 30 * time.Second
+
+// bpfSocketMap is the map which the eBPF programs record the owners of the sockets into.
+type bpfSocketMap interface {
+	Entries() (map[bpfSockKey]bpfSockValue, error)
+	Delete(key bpfSockKey) error
+	Close() error
+}
+
+// bpfLoader loads the eBPF programs and returns the map they write into.
+type bpfLoader func() (bpfSocketMap, error)
+
+// ebpfConn fetches the sockets recorded by the eBPF programs when the processes send to
+// or receive from them, so no fds are walked under /proc on every refresh. The sockets
+// opened before the programs are attached are seeded by a sock_diag fetch, which is
+// repeated every reseedInterval. Only the owners are recorded, the bytes are still counted
+// from the captured packets.
+type ebpfConn struct {
+	nl      *netlinkConn
+	sockets bpfSocketMap
+	procs   map[int32]ProcessInfo
+	seed    OpenSockets
+	seeded  time.Time
+}
+
+func newEBPFConn(nl *netlinkConn, load bpfLoader) (*ebpfConn, error) {
+	sockets, err := load()
+	if err != nil {
+		return nil, err
+	}
+
+	seed, err := nl.GetOpenSockets()
+	if err != nil {
+		sockets.Close()
+		return nil, err
+	}
+
+	return &ebpfConn{
+		nl:      nl,
+		sockets: sockets,
+		procs:   make(map[int32]ProcessInfo),
+		seed:    seed,
+		seeded:  time.Now(),
+	}, nil
+}
+
+func (c *ebpfConn) alive(pid int32) bool {
+	_, err := os.Stat(c.nl.procPath(pid))
+	return err == nil
+}
+
+// procInfo returns the process by the pid, the comm recorded by the programs is used if
+// the process has exited.
+func (c *ebpfConn) procInfo(pid int32, comm [16]byte) ProcessInfo {
+	if procInfo, ok := c.procs[pid]; ok {
+		return procInfo
+	}
+
+	procInfo := c.nl.getProcInfo(pid)
+	if procInfo.Name == "" {
+		procInfo.Name = string(bytes.TrimRight(comm[:], "\x00"))
+	}
+	c.procs[pid] = procInfo
+	return procInfo
+}
+
+// localSocket converts the key recorded by the programs.
+func (c *ebpfConn) localSocket(key bpfSockKey) (capture.LocalSocket, bool) {
+	var ip net.IP
+	switch key.Family {
+	case unix.AF_INET:
+		ip = net.IP(key.Addr[:net.IPv4len])
+	case unix.AF_INET6:
+		ip = net.IP(key.Addr[:])
+	default:
+		return capture.LocalSocket{}, false
+	}
+
+	var protocol capture.Protocol
+	switch key.Protocol {
+	case unix.IPPROTO_TCP:
+		protocol = capture.ProtoTCP
+	case unix.IPPROTO_UDP:
+		protocol = capture.ProtoUDP
+	default:
+		return capture.LocalSocket{}, false
+	}
+
+	return capture.LocalSocket{IP: wildcardIP(ip.String()), Port: key.Port, Protocol: protocol}, true
+}
+
+// GetOpenSockets returns the seeded and the recorded sockets. The closed connections and
+// the ones of the exited processes are removed from the map after being returned once so
+// that their final traffic is still attributed, the local address stays as long as any
+// other connection on it is recorded.
+func (c *ebpfConn) GetOpenSockets() (OpenSockets, error) {
+	if time.Since(c.seeded) >= reseedInterval {
+		seed, err := c.nl.GetOpenSockets()
+		if err != nil {
+			return nil, err
+		}
+		c.seed, c.seeded = seed, time.Now()
+	}
+
+	entries, err := c.sockets.Entries()
+	if err != nil {
+		return nil, err
+	}
+
+	alive := make(map[int32]bool)
+	isAlive := func(pid int32) bool {
+		v, ok := alive[pid]
+		if !ok {
+			v = c.alive(pid)
+			alive[pid] = v
+		}
+		return v
+	}
+
+	sockets := make(OpenSockets, len(c.seed)+len(entries))
+	for socket, procInfo := range c.seed {
+		if !isAlive(int32(procInfo.Pid)) {
+			delete(c.seed, socket)
+		}
+		sockets[socket] = procInfo
+	}
+
+	for key, value := range entries {
+		socket, ok := c.localSocket(key)
+		if !ok {
+			continue
+		}
+
+		pid := int32(value.Pid)
+		sockets.put(socket, c.procInfo(pid, value.Comm), false)
+		delete(c.seed, socket)
+
+		if exited := !isAlive(pid); exited || value.Closed != 0 {
+			if err := c.sockets.Delete(key); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for pid := range c.procs {
+		if v, ok := alive[pid]; ok && !v {
+			delete(c.procs, pid)
+		}
+	}
+	return sockets, nil
+}
+
+func (c *ebpfConn) Close() error {
+	return c.sockets.Close()
+}
@@ -0,0 +1,255 @@
+//go:build linux
+// +build linux
+
+package socket
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/chenjiandongx/sniffer/pkg/capture"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+type stubSocketMap struct {
+	entries map[bpfSockKey]bpfSockValue
+	closed  bool
+}
+
+func (m *stubSocketMap) Entries() (map[bpfSockKey]bpfSockValue, error) {
+	entries := make(map[bpfSockKey]bpfSockValue, len(m.entries))
+	for k, v := range m.entries {
+		entries[k] = v
+	}
+	return entries, nil
+}
+
+func (m *stubSocketMap) Delete(key bpfSockKey) error {
+	delete(m.entries, key)
+	return nil
+}
+
+func (m *stubSocketMap) Close() error {
+	m.closed = true
+	return nil
+}
+
+func bpfKey(family, protocol uint16, ip []byte, port uint16) bpfSockKey {
+	key := bpfSockKey{Family: family, Port: port, Protocol: protocol}
+	copy(key.Addr[:], ip)
+	return key
+}
+
+// bpfConnKey is the key of the connection to the remote end.
+func bpfConnKey(family, protocol uint16, ip []byte, port uint16, remoteIP []byte, remotePort uint16) bpfSockKey {
+	key := bpfKey(family, protocol, ip, port)
+	copy(key.RemoteAddr[:], remoteIP)
+	key.RemotePort = remotePort
+	return key
+}
+
+func bpfValue(pid uint32, comm string, closed bool) bpfSockValue {
+	value := bpfSockValue{Pid: pid}
+	copy(value.Comm[:], comm)
+	if closed {
+		value.Closed = 1
+	}
+	return value
+}
+
+func TestEBPFConn(t *testing.T) {
+	root, err := ioutil.TempDir("", "proc")
+	assert.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	fakeProc(t, root, "1234", "/usr/sbin/nginx", "nginx", "nginx\x00", nil)
+	fakeProc(t, root, "5678", "/usr/bin/curl", "curl", "curl\x00", nil)
+
+	nginxKey := bpfKey(unix.AF_INET6, unix.IPPROTO_TCP, make([]byte, 16), 80)
+	curlKey := bpfKey(unix.AF_INET, unix.IPPROTO_TCP, []byte{10, 0, 0, 1}, 50000)
+	digKey := bpfKey(unix.AF_INET, unix.IPPROTO_UDP, []byte{10, 0, 0, 1}, 40000)
+	// The connections accepted by nginx share the local address.
+	closedKey := bpfConnKey(unix.AF_INET, unix.IPPROTO_TCP, []byte{10, 0, 0, 1}, 80, []byte{10, 0, 0, 2}, 50001)
+	acceptedKey := bpfConnKey(unix.AF_INET, unix.IPPROTO_TCP, []byte{10, 0, 0, 1}, 80, []byte{10, 0, 0, 3}, 50002)
+	stub := &stubSocketMap{entries: map[bpfSockKey]bpfSockValue{
+		nginxKey:    bpfValue(1234, "nginx", false),
+		curlKey:     bpfValue(5678, "curl", true),
+		digKey:      bpfValue(4242, "dig", false),
+		closedKey:   bpfValue(1234, "nginx", true),
+		acceptedKey: bpfValue(1234, "nginx", false),
+		bpfKey(unix.AF_UNIX, unix.IPPROTO_TCP, nil, 1): bpfValue(1234, "nginx", false),
+	}}
+
+	c, err := newEBPFConn(&netlinkConn{procRoot: root}, func() (bpfSocketMap, error) {
+		return stub, nil
+	})
+	assert.NoError(t, err)
+
+	nginx := capture.LocalSocket{IP: "*", Port: 80, Protocol: capture.ProtoTCP}
+	curl := capture.LocalSocket{IP: "10.0.0.1", Port: 50000, Protocol: capture.ProtoTCP}
+	dig := capture.LocalSocket{IP: "10.0.0.1", Port: 40000, Protocol: capture.ProtoUDP}
+	accepted := capture.LocalSocket{IP: "10.0.0.1", Port: 80, Protocol: capture.ProtoTCP}
+
+	// The closed socket and the one of the exited process are returned once.
+	sockets, err := c.GetOpenSockets()
+	assert.NoError(t, err)
+	assert.Len(t, sockets, 4)
+	assert.Equal(t, "nginx", sockets[nginx].Name)
+	assert.Equal(t, "curl", sockets[curl].Name)
+	assert.Equal(t, "nginx", sockets[accepted].Name)
+	assert.Equal(t, ProcessInfo{Pid: 4242, Name: "dig"}, sockets[dig])
+	assert.NotContains(t, stub.entries, curlKey)
+	assert.NotContains(t, stub.entries, digKey)
+	assert.NotContains(t, stub.entries, closedKey)
+
+	// The connection closed does not take the local address of the open one with it.
+	sockets, err = c.GetOpenSockets()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []capture.LocalSocket{nginx, accepted}, keys(sockets))
+
+	assert.NoError(t, c.Close())
+	assert.True(t, stub.closed)
+}
+
+func TestEBPFConnReseed(t *testing.T) {
+	root, err := ioutil.TempDir("", "proc")
+	assert.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	fakeProc(t, root, "1234", "/usr/sbin/nginx", "nginx", "nginx\x00", nil)
+
+	// The socket closed before being recorded is dropped on the next seed although its
+	// owner is still alive.
+	stale := capture.LocalSocket{IP: "10.0.0.1", Port: 50000, Protocol: capture.ProtoTCP}
+	c := &ebpfConn{
+		nl:      &netlinkConn{procRoot: root},
+		sockets: &stubSocketMap{},
+		procs:   make(map[int32]ProcessInfo),
+		seed:    OpenSockets{stale: {Pid: 1234, Name: "nginx"}},
+		seeded:  time.Now(),
+	}
+
+	sockets, err := c.GetOpenSockets()
+	assert.NoError(t, err)
+	assert.Contains(t, sockets, stale)
+
+	c.seeded = time.Now().Add(-reseedInterval)
+	sockets, err = c.GetOpenSockets()
+	assert.NoError(t, err)
+	assert.NotContains(t, sockets, stale)
+	assert.WithinDuration(t, time.Now(), c.seeded, time.Second)
+}
+
+func keys(sockets OpenSockets) []capture.LocalSocket {
+	var result []capture.LocalSocket
+	for k := range sockets {
+		result = append(result, k)
+	}
+	return result
+}
+
+func TestNewEBPFConnLoadError(t *testing.T) {
+	_, err := newEBPFConn(&netlinkConn{procRoot: t.TempDir()}, func() (bpfSocketMap, error) {
+		return nil, errors.New("operation not permitted")
+	})
+	assert.EqualError(t, err, "operation not permitted")
+}
+
+func TestParseKernelVersion(t *testing.T) {
+	tests := []struct {
+		release string
+		want    uint32
+	}{
+		{"4.19.181-1", 4<<16 | 19<<8 | 181},
+		{"4.15.18", 4<<16 | 15<<8 | 18},
+		{"5.10.0-8-amd64", 5<<16 | 10<<8},
+		{"4.9.337", 4<<16 | 9<<8 | 255},
+		{"6.1", 6<<16 | 1<<8},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, parseKernelVersion(tt.release), tt.release)
+	}
+}
+
+func TestParseTraceFormat(t *testing.T) {
+	format := `name: inet_sock_set_state
+ID: 2187
+format:
+	field:unsigned short common_type;	offset:0;	size:2;	signed:0;
+	field:const void * skaddr;	offset:8;	size:8;	signed:0;
+	field:int newstate;	offset:20;	size:4;	signed:1;
+	field:__u16 sport;	offset:24;	size:2;	signed:0;
+	field:__u16 dport;	offset:26;	size:2;	signed:0;
+	field:__u8 saddr_v6[16];	offset:40;	size:16;	signed:0;
+
+print fmt: "family=%s"`
+
+	fields := parseTraceFormat(format)
+	assert.Equal(t, map[string]traceField{
+		"common_type": {offset: 0, size: 2},
+		"skaddr":      {offset: 8, size: 8},
+		"newstate":    {offset: 20, size: 4},
+		"sport":       {offset: 24, size: 2},
+		"dport":       {offset: 26, size: 2},
+		"saddr_v6":    {offset: 40, size: 16},
+	}, fields)
+
+	_, err := stateProgram(fields, 3)
+	assert.EqualError(t, err, "bpf: inet_sock_set_state.family not found")
+}
+
+func TestLoadBPF(t *testing.T) {
+	m, err := loadBPF()
+	if err != nil {
+		t.Skipf("eBPF is unavailable: %v", err)
+	}
+	defer m.Close()
+
+	conn, err := net.Dial("udp", "127.0.0.1:53")
+	assert.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("sniffer"))
+	assert.NoError(t, err)
+
+	loopback := []byte{127, 0, 0, 1}
+	entries, err := m.Entries()
+	assert.NoError(t, err)
+	key := bpfConnKey(unix.AF_INET, unix.IPPROTO_UDP, loopback, uint16(conn.LocalAddr().(*net.UDPAddr).Port), loopback, 53)
+	assert.Equal(t, uint32(os.Getpid()), entries[key].Pid)
+
+	// The connection closed is marked by both ends, the listener on the same local address
+	// is not.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+	client, err := net.Dial("tcp", ln.Addr().String())
+	assert.NoError(t, err)
+	server, err := ln.Accept()
+	assert.NoError(t, err)
+	_, err = client.Write([]byte("sniffer"))
+	assert.NoError(t, err)
+	_, err = server.Read(make([]byte, 16))
+	assert.NoError(t, err)
+	assert.NoError(t, client.Close())
+	assert.NoError(t, server.Close())
+
+	listenPort := uint16(ln.Addr().(*net.TCPAddr).Port)
+	clientPort := uint16(client.LocalAddr().(*net.TCPAddr).Port)
+	listenerKey := bpfKey(unix.AF_INET, unix.IPPROTO_TCP, loopback, listenPort)
+	clientKey := bpfConnKey(unix.AF_INET, unix.IPPROTO_TCP, loopback, clientPort, loopback, listenPort)
+	serverKey := bpfConnKey(unix.AF_INET, unix.IPPROTO_TCP, loopback, listenPort, loopback, clientPort)
+	assert.Eventually(t, func() bool {
+		entries, err := m.Entries()
+		return err == nil && entries[clientKey].Closed == 1 && entries[serverKey].Closed == 1
+	}, time.Second, 10*time.Millisecond)
+
+	entries, err = m.Entries()
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(os.Getpid()), entries[listenerKey].Pid)
+	assert.Zero(t, entries[listenerKey].Closed)
+}
@@ -0,0 +1,103 @@
+package socket
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/chenjiandongx/sniffer/pkg/capture"
+)
+
+// staticFetcher always returns the same sockets, it is used to attribute the replayed
+// packets to the processes.
+type staticFetcher OpenSockets
+
+func (s staticFetcher) GetOpenSockets() (OpenSockets, error) {
+	return OpenSockets(s), nil
+}
+
+// NewStaticFetcher creates the Fetcher which always returns the sockets.
+func NewStaticFetcher(sockets OpenSockets) Fetcher {
+	return staticFetcher(sockets)
+}
+
+// ssUsersRegex matches the first process of the `users:(("nginx",pid=1234,fd=6),...)` column.
+var ssUsersRegex = regexp.MustCompile(`\("([^"]*)",pid=(\d+)`)
+
+// LoadSnapshot loads the OpenSockets from the output of `ss -tunap` which is taken on
+// the host where the capture file was recorded.
+func LoadSnapshot(name string) (OpenSockets, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sockets := make(OpenSockets)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Netid State Recv-Q Send-Q Local-Address:Port Peer-Address:Port Process
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 7 {
+			continue
+		}
+
+		var protocol capture.Protocol
+		switch fields[0] {
+		case "tcp":
+			protocol = capture.ProtoTCP
+		case "udp":
+			protocol = capture.ProtoUDP
+		default:
+			continue
+		}
+
+		ip, port, ok := parseSSAddr(fields[4])
+		if !ok {
+			continue
+		}
+
+		users := ssUsersRegex.FindStringSubmatch(strings.Join(fields[6:], " "))
+		if users == nil {
+			continue
+		}
+		pid, _ := strconv.Atoi(users[2])
+
+		sockets.put(capture.LocalSocket{IP: ip, Port: port, Protocol: protocol}, ProcessInfo{Pid: pid, Name: users[1]}, fields[1] == "LISTEN")
+	}
+
+	return sockets, scanner.Err()
+}
+
+// parseSSAddr parses the address like `10.0.0.1:80`, `[::1]:631` or `0.0.0.0%eth0:68`.
+func parseSSAddr(s string) (string, uint16, bool) {
+	idx := strings.LastIndex(s, ":")
+	if idx == -1 {
+		return "", 0, false
+	}
+
+	port, err := strconv.ParseUint(s[idx+1:], 10, 16)
+	if err != nil {
+		return "", 0, false
+	}
+
+	host := s[:idx]
+	if i := strings.Index(host, "%"); i != -1 {
+		host = host[:i]
+	}
+	host = strings.Trim(host, "[]")
+
+	switch host {
+	case "*", "0.0.0.0", "::":
+		return "*", uint16(port), true
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return "", 0, false
+	}
+	return ip.String(), uint16(port), true
+}
@@ -0,0 +1,29 @@
+package socket
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/chenjiandongx/sniffer/pkg/capture"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadSnapshot(t *testing.T) {
+	sockets := filepath.Join(t.TempDir(), "ss.txt")
+	ss := `Netid State  Recv-Q Send-Q Local Address:Port Peer Address:Port Process
+udp   ESTAB  0      0      10.0.0.1:50000     10.0.0.2:53        users:(("dig",pid=42,fd=6))
+tcp   LISTEN 0      128    [::]:22            [::]:*             users:(("sshd",pid=1,fd=3))
+tcp   LISTEN 0      128    10.0.0.1:80        0.0.0.0:*          users:(("nginx",pid=7,fd=6))`
+	assert.NoError(t, ioutil.WriteFile(sockets, []byte(ss), 0644))
+
+	openSockets, err := LoadSnapshot(sockets)
+	assert.NoError(t, err)
+	assert.Equal(t, OpenSockets{
+		{IP: "10.0.0.1", Port: 50000, Protocol: capture.ProtoUDP}: {Pid: 42, Name: "dig"},
+		{IP: "*", Port: 22, Protocol: capture.ProtoTCP}:           {Pid: 1, Name: "sshd"},
+		{IP: "10.0.0.1", Port: 80, Protocol: capture.ProtoTCP}:    {Pid: 7, Name: "nginx"},
+		{IP: listenerIP, Port: 80, Protocol: capture.ProtoTCP}:    {Pid: 7, Name: "nginx"},
+	}, openSockets)
+	assert.Equal(t, []string{"10.0.0.1"}, openSockets.LocalIPs())
+}
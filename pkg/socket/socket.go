@@ -0,0 +1,142 @@
+// Package socket fetches the open sockets with the processes which own them.
+package socket
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/chenjiandongx/sniffer/pkg/capture"
+	"github.com/chenjiandongx/sniffer/pkg/netns"
+)
+
+// UnknownProcessName is the name of the traffic which no process is found for.
+const UnknownProcessName = "<UNKNOWN>"
+
+type ProcessInfo struct {
+	Pid  int
+	Name string
+
+	// Cmdline is the full command line of the process, it may be empty if unavailable.
+	Cmdline string
+
+	// Container is the container or the cgroup which the process belongs to, it is
+	// only available on Linux.
+	Container string
+}
+
+func (p ProcessInfo) String() string {
+	return fmt.Sprintf("<%d>:%s", p.Pid, p.Name)
+}
+
+type OpenSockets map[capture.LocalSocket]ProcessInfo
+
+// listenerIP is the address which the listening sockets are indexed under by the port,
+// the protocol and the namespace besides the address they are bound to.
+const listenerIP = ""
+
+// Lookup finds the process which owns the local socket, the wildcard address is tried
+// if no socket is bound to the exact one. At last the listening socket on the same port
+// is matched if the connection is inbound, since its accepted socket may be closed or not
+// fetched yet, eg. to the address which is translated to the one of the listener.
+func (s OpenSockets) Lookup(localSocket capture.LocalSocket, inbound bool) (ProcessInfo, bool) {
+	ips := []string{localSocket.IP, "*"}
+	if inbound {
+		ips = append(ips, listenerIP)
+	}
+	for _, ip := range ips {
+		cloned := localSocket
+		cloned.IP = ip
+
+		v, ok := s[cloned]
+		if ok {
+			return v, true
+		}
+	}
+	return ProcessInfo{}, false
+}
+
+// put adds the socket, the listening socket does not replace the connected one bound to
+// the same address since the connection may be accepted by another process. The first
+// listening socket bound to a specific address on the port is indexed for the fallback of
+// Lookup, the ones on the wildcard address are matched by it already.
+func (s OpenSockets) put(localSocket capture.LocalSocket, procInfo ProcessInfo, listening bool) {
+	if listening && localSocket.IP != "*" {
+		listener := localSocket
+		listener.IP = listenerIP
+		if _, ok := s[listener]; !ok {
+			s[listener] = procInfo
+		}
+	}
+
+	if _, ok := s[localSocket]; ok && listening {
+		return
+	}
+	s[localSocket] = procInfo
+}
+
+// wildcardIP returns "*" for the unspecified addresses which the sockets listening on all
+// the interfaces are bound to.
+func wildcardIP(ip string) string {
+	if ip == "0.0.0.0" || ip == "::" {
+		return "*"
+	}
+	return ip
+}
+
+// LocalIPs returns the addresses which the sockets are bound to.
+func (s OpenSockets) LocalIPs() []string {
+	var ips []string
+	visited := map[string]bool{}
+	for socket := range s {
+		if socket.IP != "*" && socket.IP != listenerIP && !visited[socket.IP] {
+			ips = append(ips, socket.IP)
+			visited[socket.IP] = true
+		}
+	}
+	return ips
+}
+
+// Fetcher fetches the open sockets with the processes which own them.
+type Fetcher interface {
+	GetOpenSockets() (OpenSockets, error)
+}
+
+// The backends of the Fetcher.
+const (
+	// BackendNative fetches the sockets by sock_diag on Linux, lsof on macOS and the
+	// system APIs on Windows.
+	BackendNative = "native"
+
+	// BackendEBPF records the owners of the sockets by the eBPF programs attached to the
+	// kernel, it falls back to BackendNative if eBPF is unavailable, Linux only. The bytes
+	// are still counted from the captured packets, the programs only record the owners.
+	BackendEBPF = "ebpf"
+)
+
+// FallbackError is returned by NewFetcher along with the Fetcher of BackendNative when the
+// requested backend is unavailable, so the caller may go on with it.
+type FallbackError struct {
+	Backend string
+	Err     error
+}
+
+func (e *FallbackError) Error() string {
+	return fmt.Sprintf("socket backend %s is unavailable, fell back to %s: %v", e.Backend, BackendNative, e.Err)
+}
+
+func (e *FallbackError) Unwrap() error {
+	return e.Err
+}
+
+var errEBPFUnsupported = errors.New("eBPF is only available on Linux")
+
+// Options is the options of the Fetcher.
+type Options struct {
+	// Backend is the backend to fetch the sockets by, BackendNative if empty
+	Backend string
+
+	// Namespaces are the network namespaces listed by netns.List to fetch the sockets in,
+	// the namespace of sniffer itself if empty. They are closed by the caller after the
+	// Fetcher, Linux only
+	Namespaces []netns.Namespace
+}
@@ -0,0 +1,42 @@
+package socket
+
+import (
+	"testing"
+
+	"github.com/chenjiandongx/sniffer/pkg/capture"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenSocketsLookup(t *testing.T) {
+	sockets := make(OpenSockets)
+	sockets.put(capture.LocalSocket{IP: "10.0.0.1", Port: 80, Protocol: capture.ProtoTCP}, ProcessInfo{Pid: 2, Name: "worker"}, false)
+	sockets.put(capture.LocalSocket{IP: "10.0.0.1", Port: 80, Protocol: capture.ProtoTCP}, ProcessInfo{Pid: 1, Name: "master"}, true)
+	sockets.put(capture.LocalSocket{IP: wildcardIP("::"), Port: 53, Protocol: capture.ProtoUDP}, ProcessInfo{Pid: 3, Name: "dnsmasq"}, false)
+	sockets.put(capture.LocalSocket{IP: "10.0.0.1", Port: 50000, Protocol: capture.ProtoTCP}, ProcessInfo{Pid: 4, Name: "curl"}, false)
+
+	tests := []struct {
+		socket  capture.LocalSocket
+		inbound bool
+		name    string
+		ok      bool
+	}{
+		{capture.LocalSocket{IP: "10.0.0.1", Port: 80, Protocol: capture.ProtoTCP}, false, "worker", true},
+		{capture.LocalSocket{IP: "10.0.0.2", Port: 53, Protocol: capture.ProtoUDP}, false, "dnsmasq", true},
+		// The accepted socket on the other address falls back to the listener on the same
+		// port, the connected sockets are never matched by the port.
+		{capture.LocalSocket{IP: "172.17.0.1", Port: 80, Protocol: capture.ProtoTCP}, true, "master", true},
+		{capture.LocalSocket{IP: "172.17.0.1", Port: 50000, Protocol: capture.ProtoTCP}, true, "", false},
+		{capture.LocalSocket{IP: "10.0.0.1", Port: 80, Protocol: capture.ProtoUDP}, true, "", false},
+		{capture.LocalSocket{IP: "10.0.0.1", Port: 80, Protocol: capture.ProtoTCP, Netns: "netns:1"}, true, "", false},
+		// The outbound connection from the port which a listener happens to use on the
+		// other address is not the listener's.
+		{capture.LocalSocket{IP: "172.17.0.1", Port: 80, Protocol: capture.ProtoTCP}, false, "", false},
+	}
+
+	for _, tt := range tests {
+		procInfo, ok := sockets.Lookup(tt.socket, tt.inbound)
+		assert.Equal(t, tt.ok, ok)
+		assert.Equal(t, tt.name, procInfo.Name)
+	}
+	assert.Equal(t, []string{"10.0.0.1"}, sockets.LocalIPs())
+}
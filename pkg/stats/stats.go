@@ -0,0 +1,939 @@
+// Package stats aggregates the utilization of the connections by the processes, the
+// remote addresses, the containers and the interfaces.
+package stats
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/chenjiandongx/sniffer/pkg/capture"
+	"github.com/chenjiandongx/sniffer/pkg/socket"
+)
+
+// HostContainerName is the container of the processes outside of containers.
+const HostContainerName = "<HOST>"
+
+// connectionIdleTimeout is how long the connections are kept in the accumulated snapshots
+// after their last traffic, the traffic of the dropped ones is kept in the keys.
+const connectionIdleTimeout = 5 * time.Minute
+
+// AverageWindows are the windows of the moving averages in Snapshot.Averages.
+var AverageWindows = []time.Duration{time.Second, 10 * time.Second, time.Minute}
+
+// Lookup resolves the remote IP to the name to display.
+type Lookup func(string) string
+
+// Order decides how the TopN results are ranked.
+type Order uint8
+
+const (
+	OrderBytes Order = iota
+	OrderPackets
+)
+
+type Stat struct {
+	Time        time.Time
+	OpenSockets socket.OpenSockets
+	Utilization capture.Utilization
+}
+
+type ConnectionData struct {
+	DownloadBytes   int
+	UploadBytes     int
+	UploadPackets   int
+	DownloadPackets int
+	ProcessName     string
+	ContainerName   string
+	InterfaceName   string
+	RemoteName      string
+}
+
+type NetworkData struct {
+	UploadBytes     int
+	DownloadBytes   int
+	UploadPackets   int
+	DownloadPackets int
+	ConnCount       int
+}
+
+func (d *NetworkData) DivideBy(n int) {
+	d.UploadBytes /= n
+	d.DownloadBytes /= n
+	d.UploadPackets /= n
+	d.DownloadPackets /= n
+}
+
+func (d *ConnectionData) DivideBy(n int) {
+	d.UploadBytes /= n
+	d.DownloadBytes /= n
+	d.UploadPackets /= n
+	d.DownloadPackets /= n
+}
+
+type ProcessesResult struct {
+	ProcessName string
+	Data        *NetworkData
+}
+
+type RemoteAddrsResult struct {
+	Addr string
+	Data *NetworkData
+}
+
+type ContainersResult struct {
+	Container string
+	Data      *NetworkData
+}
+
+type InterfacesResult struct {
+	Interface string
+	Data      *NetworkData
+}
+
+type ConnectionsResult struct {
+	Conn capture.Connection
+	Data *ConnectionData
+}
+
+type Snapshot struct {
+	Time                 time.Time
+	Processes            map[string]*NetworkData
+	RemoteAddrs          map[string]*NetworkData
+	Containers           map[string]*NetworkData
+	Interfaces           map[string]*NetworkData
+	Connections          map[capture.Connection]*ConnectionData
+	TotalUploadBytes     int
+	TotalDownloadBytes   int
+	TotalUploadPackets   int
+	TotalDownloadPackets int
+	TotalConnections     int
+
+	// ProcessesTotal is the traffic of the processes, the traffic which is not
+	// attributed to any process is excluded.
+	ProcessesTotal *NetworkData
+
+	// Stat is the raw utilization which the snapshot is taken from, it is not divided
+	// by the interval.
+	Stat Stat
+
+	// Cumulative is the traffic accumulated since its Time, which is the start of the
+	// first Stat since Manager.SetCumulative or the last Manager.Reset, it is not divided
+	// by the interval and it is nil unless enabled. The connections are counted once no
+	// matter how many intervals they are active in, the ones idle for 5 minutes are left
+	// out of its Connections and counted again if they become active.
+	Cumulative *Snapshot
+
+	// Averages are the rates averaged over the AverageWindows respectively, the window
+	// shorter than the interval is the rate of the latest interval. The connections are
+	// counted if they are active in the window. They are nil unless enabled by
+	// Manager.SetAverages, and cover the intervals since enabled.
+	Averages []*Snapshot
+
+	// Peaks is the highest rates in a single interval since Manager.SetAverages or the
+	// last Manager.Reset, each field of the keys peaks independently. It is nil unless
+	// enabled, the connections idle for 5 minutes are left out of its Connections.
+	Peaks *Snapshot
+}
+
+// SortKey decides which field of the results the TopN ones are ranked by, the traffic
+// and the connection counts descend while the names ascend.
+type SortKey uint8
+
+const (
+	SortTotal SortKey = iota
+	SortUpload
+	SortDownload
+	SortConnections
+	SortName
+)
+
+// SortKeys are all the SortKey in the order of their values.
+var SortKeys = []SortKey{SortTotal, SortUpload, SortDownload, SortConnections, SortName}
+
+func (k SortKey) String() string {
+	switch k {
+	case SortTotal:
+		return "total"
+	case SortUpload:
+		return "upload"
+	case SortDownload:
+		return "download"
+	case SortConnections:
+		return "connections"
+	case SortName:
+		return "name"
+	}
+	return fmt.Sprintf("SortKey(%d)", k)
+}
+
+// ParseSortKey parses the name of the SortKey.
+func ParseSortKey(name string) (SortKey, error) {
+	for _, k := range SortKeys {
+		if k.String() == name {
+			return k, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid sort key %s", name)
+}
+
+// Matcher reports whether a result is kept by the fields of it, such as the process name,
+// the address and the ports. The nil Matcher keeps all the results.
+type Matcher func(fields ...string) bool
+
+func (m Matcher) match(fields ...string) bool {
+	return m == nil || m(fields...)
+}
+
+// rankItem is the fields of a result which the results are ranked by.
+type rankItem struct {
+	name      string
+	up, down  int
+	connCount int
+}
+
+func networkRankItem(name string, order Order, data *NetworkData) rankItem {
+	item := rankItem{name: name, connCount: data.ConnCount}
+	if order == OrderPackets {
+		item.up, item.down = data.UploadPackets, data.DownloadPackets
+	} else {
+		item.up, item.down = data.UploadBytes, data.DownloadBytes
+	}
+	return item
+}
+
+func connectionRankItem(conn capture.Connection, order Order, data *ConnectionData) rankItem {
+	name := fmt.Sprintf("%s:%d %s %d", data.RemoteName, conn.Remote.Port, conn.Local.Protocol, conn.Local.Port)
+	return networkRankItem(name, order, &NetworkData{
+		UploadBytes:     data.UploadBytes,
+		DownloadBytes:   data.DownloadBytes,
+		UploadPackets:   data.UploadPackets,
+		DownloadPackets: data.DownloadPackets,
+		ConnCount:       1,
+	})
+}
+
+// less reports whether a is ranked before b, the ties are broken by the names so that the
+// rows keep their places between the refreshes.
+func (k SortKey) less(a, b rankItem) bool {
+	var x, y int
+	switch k {
+	case SortName:
+		return a.name < b.name
+	case SortUpload:
+		x, y = a.up, b.up
+	case SortDownload:
+		x, y = a.down, b.down
+	case SortConnections:
+		x, y = a.connCount, b.connCount
+	default:
+		x, y = a.up+a.down, b.up+b.down
+	}
+	if x != y {
+		return x > y
+	}
+	return a.name < b.name
+}
+
+func (s *Snapshot) TopNProcesses(n int, order Order) []ProcessesResult {
+	return s.TopNProcessesBy(n, order, SortTotal, nil)
+}
+
+// TopNProcessesBy ranks the processes matched by the process name.
+func (s *Snapshot) TopNProcessesBy(n int, order Order, key SortKey, matcher Matcher) []ProcessesResult {
+	var items []ProcessesResult
+	for k, v := range s.Processes {
+		if matcher.match(k) {
+			items = append(items, ProcessesResult{ProcessName: k, Data: v})
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return key.less(networkRankItem(items[i].ProcessName, order, items[i].Data), networkRankItem(items[j].ProcessName, order, items[j].Data))
+	})
+
+	if len(items) < n {
+		n = len(items)
+	}
+	return items[:n]
+}
+
+func (s *Snapshot) TopNRemoteAddrs(n int, order Order) []RemoteAddrsResult {
+	return s.TopNRemoteAddrsBy(n, order, SortTotal, nil)
+}
+
+// TopNRemoteAddrsBy ranks the remote addresses matched by the address.
+func (s *Snapshot) TopNRemoteAddrsBy(n int, order Order, key SortKey, matcher Matcher) []RemoteAddrsResult {
+	var items []RemoteAddrsResult
+	for k, v := range s.RemoteAddrs {
+		if matcher.match(k) {
+			items = append(items, RemoteAddrsResult{Addr: k, Data: v})
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return key.less(networkRankItem(items[i].Addr, order, items[i].Data), networkRankItem(items[j].Addr, order, items[j].Data))
+	})
+
+	if len(items) < n {
+		n = len(items)
+	}
+	return items[:n]
+}
+
+func (s *Snapshot) TopNContainers(n int, order Order) []ContainersResult {
+	return s.TopNContainersBy(n, order, SortTotal, nil)
+}
+
+// TopNContainersBy ranks the containers matched by the container name.
+func (s *Snapshot) TopNContainersBy(n int, order Order, key SortKey, matcher Matcher) []ContainersResult {
+	var items []ContainersResult
+	for k, v := range s.Containers {
+		if matcher.match(k) {
+			items = append(items, ContainersResult{Container: k, Data: v})
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return key.less(networkRankItem(items[i].Container, order, items[i].Data), networkRankItem(items[j].Container, order, items[j].Data))
+	})
+
+	if len(items) < n {
+		n = len(items)
+	}
+	return items[:n]
+}
+
+func (s *Snapshot) TopNInterfaces(n int, order Order) []InterfacesResult {
+	return s.TopNInterfacesBy(n, order, SortTotal, nil)
+}
+
+// TopNInterfacesBy ranks the interfaces matched by the interface name.
+func (s *Snapshot) TopNInterfacesBy(n int, order Order, key SortKey, matcher Matcher) []InterfacesResult {
+	var items []InterfacesResult
+	for k, v := range s.Interfaces {
+		if matcher.match(k) {
+			items = append(items, InterfacesResult{Interface: k, Data: v})
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return key.less(networkRankItem(items[i].Interface, order, items[i].Data), networkRankItem(items[j].Interface, order, items[j].Data))
+	})
+
+	if len(items) < n {
+		n = len(items)
+	}
+	return items[:n]
+}
+
+func (s *Snapshot) TopNConnections(n int, order Order) []ConnectionsResult {
+	return s.TopNConnectionsBy(n, order, SortTotal, nil)
+}
+
+// TopNConnectionsBy ranks the connections matched by the process name, the remote name and
+// address, the ports, the protocol and the interface. The connections are named by the
+// remote name and the ports.
+func (s *Snapshot) TopNConnectionsBy(n int, order Order, key SortKey, matcher Matcher) []ConnectionsResult {
+	var items []ConnectionsResult
+	for k, v := range s.Connections {
+		fields := []string{
+			v.ProcessName,
+			v.RemoteName,
+			k.Remote.IP,
+			strconv.Itoa(int(k.Remote.Port)),
+			k.Local.IP,
+			strconv.Itoa(int(k.Local.Port)),
+			string(k.Local.Protocol),
+			v.InterfaceName,
+		}
+		if matcher.match(fields...) {
+			items = append(items, ConnectionsResult{Conn: k, Data: v})
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return key.less(connectionRankItem(items[i].Conn, order, items[i].Data), connectionRankItem(items[j].Conn, order, items[j].Data))
+	})
+
+	if len(items) < n {
+		n = len(items)
+	}
+	return items[:n]
+}
+
+// Manager takes the snapshots of the latest Stat, the traffic is divided by the interval
+// in seconds so that the rates per second are reported. The rates are averaged over the
+// AverageWindows with the peak rates kept once enabled by SetAverages, and the traffic is
+// accumulated once enabled by SetCumulative.
+type Manager struct {
+	ratio       int
+	stat        Stat
+	connections map[capture.Connection]*ConnectionData
+	lookup      Lookup
+
+	mut        sync.Mutex
+	cumulative *Snapshot
+	active     lastActive
+
+	// history is the connections of the latest intervals covering the longest window of
+	// the moving averages, the latest is the last. It is kept with the peaks only if the
+	// averages are enabled, otherwise peaks is nil.
+	history    []map[capture.Connection]*ConnectionData
+	peaks      *Snapshot
+	peakActive lastActive
+	peakKeys   []lastKeyActive
+}
+
+// NewManager creates the Manager, the remote IPs are resolved to the domains by lookup
+// when the snapshots are taken if it is not nil.
+func NewManager(interval int, lookup Lookup) *Manager {
+	return &Manager{
+		ratio:  interval,
+		lookup: lookup,
+	}
+}
+
+// SetCumulative enables or disables accumulating the traffic in Snapshot.Cumulative, the
+// accumulation starts over from the next Stat when enabled again.
+func (s *Manager) SetCumulative(enabled bool) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	switch {
+	case !enabled:
+		s.cumulative, s.active = nil, nil
+	case s.cumulative == nil:
+		s.resetCumulative()
+	}
+}
+
+func (s *Manager) resetCumulative() {
+	s.cumulative = newSnapshot(time.Time{}, nil)
+	s.active = make(lastActive)
+}
+
+// SetAverages enables or disables the moving averages and the peak rates, they start over
+// from the next Stat when enabled again.
+func (s *Manager) SetAverages(enabled bool) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	switch {
+	case !enabled:
+		s.history, s.peaks, s.peakActive, s.peakKeys = nil, nil, nil, nil
+	case s.peaks == nil:
+		s.resetPeaks()
+	}
+}
+
+func (s *Manager) resetPeaks() {
+	s.peaks = newSnapshot(time.Time{}, nil)
+	s.peakActive = make(lastActive)
+	s.peakKeys = make([]lastKeyActive, len(s.peaks.keyMaps()))
+	for i := range s.peakKeys {
+		s.peakKeys[i] = make(lastKeyActive)
+	}
+}
+
+// getRemoteName prefers the server name asked by the client to the resolved domain, only
+// the remote addresses of the TCP connections are resolved.
+func (s *Manager) getRemoteName(conn capture.Connection, serverName string) string {
+	if serverName != "" {
+		return serverName
+	}
+	if s.lookup == nil || conn.Local.Protocol != capture.ProtoTCP {
+		return conn.Remote.IP
+	}
+	return s.lookup(conn.Remote.IP)
+}
+
+// historySize returns the number of the intervals covering the longest average window.
+func (s *Manager) historySize() int {
+	longest := int(AverageWindows[len(AverageWindows)-1] / time.Second)
+	return (longest + s.ratio - 1) / s.ratio
+}
+
+// Put sets the latest Stat, it is kept in the history of the moving averages with the
+// peaks updated and its traffic is accumulated if they are enabled.
+func (s *Manager) Put(stat Stat) {
+	s.stat = stat
+	s.connections = s.getConnections(stat)
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if s.peaks != nil {
+		s.history = append(s.history, s.connections)
+		if n := s.historySize(); len(s.history) > n {
+			s.history = s.history[len(s.history)-n:]
+		}
+
+		rates := newSnapshot(stat.Time, cloneConnections(s.connections))
+		rates.divideBy(s.ratio)
+		s.peaks.peak(rates)
+		s.peakActive.update(stat.Time, s.connections, s.peaks.Connections)
+		active, keys := rates.keyMaps(), s.peaks.keyMaps()
+		for i := range s.peakKeys {
+			s.peakKeys[i].update(stat.Time, active[i], keys[i])
+		}
+	}
+
+	if s.cumulative == nil {
+		return
+	}
+	if s.cumulative.Time.IsZero() {
+		s.cumulative.Time = stat.Time.Add(-time.Duration(s.ratio) * time.Second)
+	}
+	s.cumulative.accumulate(s.connections)
+	s.active.update(stat.Time, s.connections, s.cumulative.Connections)
+}
+
+// accumulate adds the traffic of the connections to dst, the process of the connection
+// is kept once known so that the connection closed later is still attributed.
+func accumulate(dst, connections map[capture.Connection]*ConnectionData) {
+	for conn, data := range connections {
+		acc, ok := dst[conn]
+		if !ok {
+			acc = &ConnectionData{}
+			dst[conn] = acc
+		}
+		acc.attribute(data, !ok)
+		acc.add(data)
+	}
+}
+
+// attribute takes the names of the connection from data, the process is kept once known
+// unless all is set.
+func (d *ConnectionData) attribute(data *ConnectionData, all bool) {
+	if all || data.ProcessName != socket.UnknownProcessName {
+		d.ProcessName = data.ProcessName
+		d.ContainerName = data.ContainerName
+	}
+	d.InterfaceName = data.InterfaceName
+	d.RemoteName = data.RemoteName
+}
+
+func (d *ConnectionData) add(data *ConnectionData) {
+	d.UploadBytes += data.UploadBytes
+	d.DownloadBytes += data.DownloadBytes
+	d.UploadPackets += data.UploadPackets
+	d.DownloadPackets += data.DownloadPackets
+}
+
+// accumulate adds the traffic of the connections to the snapshot like the free function,
+// the keys and the totals are updated along so that the snapshot is not aggregated again
+// on every interval. The connection whose names change is moved to its new keys.
+func (s *Snapshot) accumulate(connections map[capture.Connection]*ConnectionData) {
+	for conn, data := range connections {
+		acc, ok := s.Connections[conn]
+		if !ok {
+			acc = &ConnectionData{}
+			acc.attribute(data, true)
+			s.Connections[conn] = acc
+			s.count(acc, 1)
+		} else {
+			moved := *acc
+			moved.attribute(data, false)
+			if moved != *acc {
+				s.count(acc, -1)
+				*acc = moved
+				s.count(acc, 1)
+			}
+		}
+
+		acc.add(data)
+		for _, d := range s.keysOf(acc) {
+			d.addTraffic(data, 1)
+		}
+		s.addTotals(data, 1)
+	}
+}
+
+// keysOf returns the traffic of the keys which the connection is aggregated into, the
+// missing keys are created.
+func (s *Snapshot) keysOf(data *ConnectionData) []*NetworkData {
+	get := func(m map[string]*NetworkData, key string) *NetworkData {
+		if _, ok := m[key]; !ok {
+			m[key] = &NetworkData{}
+		}
+		return m[key]
+	}
+
+	keys := []*NetworkData{
+		get(s.Processes, data.ProcessName),
+		get(s.RemoteAddrs, data.RemoteName),
+		get(s.Containers, data.ContainerName),
+		get(s.Interfaces, data.InterfaceName),
+	}
+	if data.ProcessName != socket.UnknownProcessName {
+		keys = append(keys, s.ProcessesTotal)
+	}
+	return keys
+}
+
+// count adds the connection with its traffic to the keys and the totals if sign is 1, or
+// removes it if sign is -1. The keys left without any connection are dropped.
+func (s *Snapshot) count(data *ConnectionData, sign int) {
+	for _, d := range s.keysOf(data) {
+		d.addTraffic(data, sign)
+		d.ConnCount += sign
+	}
+	s.addTotals(data, sign)
+	s.TotalConnections += sign
+
+	drop := func(m map[string]*NetworkData, key string) {
+		if m[key].ConnCount == 0 {
+			delete(m, key)
+		}
+	}
+	drop(s.Processes, data.ProcessName)
+	drop(s.RemoteAddrs, data.RemoteName)
+	drop(s.Containers, data.ContainerName)
+	drop(s.Interfaces, data.InterfaceName)
+}
+
+func (d *NetworkData) addTraffic(data *ConnectionData, sign int) {
+	d.UploadBytes += sign * data.UploadBytes
+	d.DownloadBytes += sign * data.DownloadBytes
+	d.UploadPackets += sign * data.UploadPackets
+	d.DownloadPackets += sign * data.DownloadPackets
+}
+
+func (s *Snapshot) addTotals(data *ConnectionData, sign int) {
+	s.TotalUploadBytes += sign * data.UploadBytes
+	s.TotalDownloadBytes += sign * data.DownloadBytes
+	s.TotalUploadPackets += sign * data.UploadPackets
+	s.TotalDownloadPackets += sign * data.DownloadPackets
+}
+
+// lastActive is the last time which the connections of an accumulated snapshot have
+// traffic at.
+type lastActive map[capture.Connection]time.Time
+
+// update records the connections active at t, the ones idle longer than
+// connectionIdleTimeout are dropped from the connections of the snapshot.
+func (a lastActive) update(t time.Time, active, connections map[capture.Connection]*ConnectionData) {
+	for conn := range active {
+		a[conn] = t
+	}
+	for conn, last := range a {
+		if t.Sub(last) > connectionIdleTimeout {
+			delete(a, conn)
+			delete(connections, conn)
+		}
+	}
+}
+
+// lastKeyActive is the last time which the keys of a peak snapshot have traffic.
+type lastKeyActive map[string]time.Time
+
+// update records the keys active at t, the ones idle longer than connectionIdleTimeout
+// are dropped from the keys of the snapshot.
+func (a lastKeyActive) update(t time.Time, active, keys map[string]*NetworkData) {
+	for key := range active {
+		a[key] = t
+	}
+	for key, last := range a {
+		if t.Sub(last) > connectionIdleTimeout {
+			delete(a, key)
+			delete(keys, key)
+		}
+	}
+}
+
+// Reset discards the accumulated traffic and the peak rates, the accumulation starts over
+// from the next Stat.
+func (s *Manager) Reset() {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if s.cumulative != nil {
+		s.resetCumulative()
+	}
+	if s.peaks != nil {
+		s.resetPeaks()
+	}
+}
+
+func (s *Manager) getProcName(openSockets socket.OpenSockets, localSocket capture.LocalSocket, inbound bool) string {
+	if procInfo, ok := openSockets.Lookup(localSocket, inbound); ok {
+		return procInfo.String()
+	}
+	return socket.UnknownProcessName
+}
+
+func (s *Manager) getContainerName(openSockets socket.OpenSockets, localSocket capture.LocalSocket, inbound bool) string {
+	procInfo, ok := openSockets.Lookup(localSocket, inbound)
+	if !ok {
+		return socket.UnknownProcessName
+	}
+	if procInfo.Container == "" {
+		return HostContainerName
+	}
+	return procInfo.Container
+}
+
+// getConnections attributes the utilization of the Stat to the processes, the traffic
+// is not divided.
+func (s *Manager) getConnections(stat Stat) map[capture.Connection]*ConnectionData {
+	connections := make(map[capture.Connection]*ConnectionData, len(stat.Utilization))
+	for conn, info := range stat.Utilization {
+		connections[conn] = &ConnectionData{
+			UploadBytes:     info.UploadBytes,
+			DownloadBytes:   info.DownloadBytes,
+			UploadPackets:   info.UploadPackets,
+			DownloadPackets: info.DownloadPackets,
+			ProcessName:     s.getProcName(stat.OpenSockets, conn.Local, info.Inbound),
+			ContainerName:   s.getContainerName(stat.OpenSockets, conn.Local, info.Inbound),
+			InterfaceName:   info.Interface,
+			RemoteName:      s.getRemoteName(conn, info.ServerName),
+		}
+	}
+	return connections
+}
+
+func (d *NetworkData) add(data *ConnectionData) {
+	d.UploadBytes += data.UploadBytes
+	d.DownloadBytes += data.DownloadBytes
+	d.UploadPackets += data.UploadPackets
+	d.DownloadPackets += data.DownloadPackets
+	d.ConnCount++
+}
+
+// newSnapshot aggregates the connections by the processes, the remote addresses and the
+// containers, the traffic is not divided.
+func newSnapshot(t time.Time, connections map[capture.Connection]*ConnectionData) *Snapshot {
+	if connections == nil {
+		connections = map[capture.Connection]*ConnectionData{}
+	}
+	snapshot := &Snapshot{
+		Time:           t,
+		Processes:      map[string]*NetworkData{},
+		RemoteAddrs:    map[string]*NetworkData{},
+		Containers:     map[string]*NetworkData{},
+		Interfaces:     map[string]*NetworkData{},
+		Connections:    connections,
+		ProcessesTotal: &NetworkData{},
+	}
+
+	get := func(m map[string]*NetworkData, key string) *NetworkData {
+		if _, ok := m[key]; !ok {
+			m[key] = &NetworkData{}
+		}
+		return m[key]
+	}
+
+	for _, data := range connections {
+		get(snapshot.Processes, data.ProcessName).add(data)
+		get(snapshot.RemoteAddrs, data.RemoteName).add(data)
+		get(snapshot.Containers, data.ContainerName).add(data)
+		get(snapshot.Interfaces, data.InterfaceName).add(data)
+		if data.ProcessName != socket.UnknownProcessName {
+			snapshot.ProcessesTotal.add(data)
+		}
+
+		snapshot.TotalUploadBytes += data.UploadBytes
+		snapshot.TotalDownloadBytes += data.DownloadBytes
+		snapshot.TotalUploadPackets += data.UploadPackets
+		snapshot.TotalDownloadPackets += data.DownloadPackets
+		snapshot.TotalConnections++
+	}
+	return snapshot
+}
+
+// ProcessSnapshot takes the snapshot of the connections of the process, the cumulative
+// one is taken as well. The averages and the peaks are left out since the peaks of the
+// keys are not the sums of the ones of the connections.
+func (s *Snapshot) ProcessSnapshot(name string) *Snapshot {
+	snapshot := newSnapshot(s.Time, processConnections(s.Connections, name))
+	if s.Cumulative != nil {
+		snapshot.Cumulative = newSnapshot(s.Cumulative.Time, processConnections(s.Cumulative.Connections, name))
+	}
+	return snapshot
+}
+
+func processConnections(connections map[capture.Connection]*ConnectionData, name string) map[capture.Connection]*ConnectionData {
+	filtered := make(map[capture.Connection]*ConnectionData)
+	for conn, data := range connections {
+		if data.ProcessName == name {
+			v := *data
+			filtered[conn] = &v
+		}
+	}
+	return filtered
+}
+
+// divideBy divides the traffic of the keys by n, the totals are kept.
+func (s *Snapshot) divideBy(n int) {
+	for _, v := range s.Processes {
+		v.DivideBy(n)
+	}
+	for _, v := range s.RemoteAddrs {
+		v.DivideBy(n)
+	}
+	for _, v := range s.Containers {
+		v.DivideBy(n)
+	}
+	for _, v := range s.Interfaces {
+		v.DivideBy(n)
+	}
+	for _, v := range s.Connections {
+		v.DivideBy(n)
+	}
+	s.ProcessesTotal.DivideBy(n)
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func (d *NetworkData) peak(data *NetworkData) {
+	d.UploadBytes = maxInt(d.UploadBytes, data.UploadBytes)
+	d.DownloadBytes = maxInt(d.DownloadBytes, data.DownloadBytes)
+	d.UploadPackets = maxInt(d.UploadPackets, data.UploadPackets)
+	d.DownloadPackets = maxInt(d.DownloadPackets, data.DownloadPackets)
+	d.ConnCount = maxInt(d.ConnCount, data.ConnCount)
+}
+
+func peakNetworkData(dst, src map[string]*NetworkData) {
+	for k, v := range src {
+		if _, ok := dst[k]; !ok {
+			dst[k] = &NetworkData{}
+		}
+		dst[k].peak(v)
+	}
+}
+
+// keyMaps returns the traffic aggregated by the processes, the remote addresses, the
+// containers and the interfaces.
+func (s *Snapshot) keyMaps() []map[string]*NetworkData {
+	return []map[string]*NetworkData{s.Processes, s.RemoteAddrs, s.Containers, s.Interfaces}
+}
+
+// peak raises the traffic of the keys to the ones of the rates.
+func (s *Snapshot) peak(rates *Snapshot) {
+	peakNetworkData(s.Processes, rates.Processes)
+	peakNetworkData(s.RemoteAddrs, rates.RemoteAddrs)
+	peakNetworkData(s.Containers, rates.Containers)
+	peakNetworkData(s.Interfaces, rates.Interfaces)
+	s.ProcessesTotal.peak(rates.ProcessesTotal)
+
+	for conn, data := range rates.Connections {
+		v, ok := s.Connections[conn]
+		if !ok {
+			v = &ConnectionData{}
+			s.Connections[conn] = v
+		}
+		v.ProcessName = data.ProcessName
+		v.ContainerName = data.ContainerName
+		v.InterfaceName = data.InterfaceName
+		v.RemoteName = data.RemoteName
+		v.UploadBytes = maxInt(v.UploadBytes, data.UploadBytes)
+		v.DownloadBytes = maxInt(v.DownloadBytes, data.DownloadBytes)
+		v.UploadPackets = maxInt(v.UploadPackets, data.UploadPackets)
+		v.DownloadPackets = maxInt(v.DownloadPackets, data.DownloadPackets)
+	}
+}
+
+func cloneNetworkData(m map[string]*NetworkData) map[string]*NetworkData {
+	cloned := make(map[string]*NetworkData, len(m))
+	for k, v := range m {
+		data := *v
+		cloned[k] = &data
+	}
+	return cloned
+}
+
+// clone copies the traffic of the keys and the totals, the nested snapshots are not
+// copied.
+func (s *Snapshot) clone() *Snapshot {
+	total := *s.ProcessesTotal
+	return &Snapshot{
+		Time:                 s.Time,
+		Processes:            cloneNetworkData(s.Processes),
+		RemoteAddrs:          cloneNetworkData(s.RemoteAddrs),
+		Containers:           cloneNetworkData(s.Containers),
+		Interfaces:           cloneNetworkData(s.Interfaces),
+		Connections:          cloneConnections(s.Connections),
+		TotalUploadBytes:     s.TotalUploadBytes,
+		TotalDownloadBytes:   s.TotalDownloadBytes,
+		TotalUploadPackets:   s.TotalUploadPackets,
+		TotalDownloadPackets: s.TotalDownloadPackets,
+		TotalConnections:     s.TotalConnections,
+		ProcessesTotal:       &total,
+	}
+}
+
+func cloneConnections(connections map[capture.Connection]*ConnectionData) map[capture.Connection]*ConnectionData {
+	cloned := make(map[capture.Connection]*ConnectionData, len(connections))
+	for conn, data := range connections {
+		v := *data
+		cloned[conn] = &v
+	}
+	return cloned
+}
+
+// getCumulative takes the snapshot of the accumulated traffic, it is nil if disabled.
+func (s *Manager) getCumulative() *Snapshot {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if s.cumulative == nil {
+		return nil
+	}
+	return s.cumulative.clone()
+}
+
+// getAverages takes the snapshots of the moving averages and the peaks, they are nil if
+// disabled.
+func (s *Manager) getAverages(t time.Time) ([]*Snapshot, *Snapshot) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if s.peaks == nil {
+		return nil, nil
+	}
+
+	averages := make([]*Snapshot, 0, len(AverageWindows))
+	for _, window := range AverageWindows {
+		averages = append(averages, s.getAverage(t, window))
+	}
+	return averages, s.peaks.clone()
+}
+
+// getAverage averages the rates of the latest intervals in the window.
+func (s *Manager) getAverage(t time.Time, window time.Duration) *Snapshot {
+	n := (int(window/time.Second) + s.ratio - 1) / s.ratio
+	if n > len(s.history) {
+		n = len(s.history)
+	}
+
+	connections := make(map[capture.Connection]*ConnectionData)
+	for _, m := range s.history[len(s.history)-n:] {
+		accumulate(connections, m)
+	}
+
+	average := newSnapshot(t, connections)
+	if n > 0 {
+		average.divideBy(n * s.ratio)
+	}
+	return average
+}
+
+// GetSnapshot aggregates the latest Stat.
+func (s *Manager) GetSnapshot() *Snapshot {
+	stat := s.stat
+	snapshot := newSnapshot(stat.Time, cloneConnections(s.connections))
+	snapshot.divideBy(s.ratio)
+
+	snapshot.Stat = stat
+	snapshot.Cumulative = s.getCumulative()
+	snapshot.Averages, snapshot.Peaks = s.getAverages(stat.Time)
+	return snapshot
+}
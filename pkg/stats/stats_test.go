@@ -0,0 +1,281 @@
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/chenjiandongx/sniffer/pkg/capture"
+	"github.com/chenjiandongx/sniffer/pkg/socket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManagerCumulative(t *testing.T) {
+	curl := capture.Connection{
+		Local:  capture.LocalSocket{IP: "10.0.0.1", Port: 50000, Protocol: capture.ProtoTCP},
+		Remote: capture.RemoteSocket{IP: "10.0.0.2", Port: 443},
+	}
+	dig := capture.Connection{
+		Local:  capture.LocalSocket{IP: "10.0.0.1", Port: 40000, Protocol: capture.ProtoUDP},
+		Remote: capture.RemoteSocket{IP: "10.0.0.3", Port: 53},
+	}
+	openSockets := socket.OpenSockets{
+		curl.Local: {Pid: 1, Name: "curl"},
+		dig.Local:  {Pid: 2, Name: "dig"},
+	}
+
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	m := NewManager(2, nil)
+	m.SetCumulative(true)
+	m.Put(Stat{
+		Time:        start,
+		OpenSockets: openSockets,
+		Utilization: capture.Utilization{
+			curl: {Interface: "eth0", UploadBytes: 200, DownloadBytes: 4000, UploadPackets: 2, DownloadPackets: 4},
+			dig:  {Interface: "eth0", UploadBytes: 60, DownloadBytes: 120, UploadPackets: 2, DownloadPackets: 2},
+		},
+	})
+	snapshot := m.GetSnapshot()
+	assert.Equal(t, &NetworkData{UploadBytes: 100, DownloadBytes: 2000, UploadPackets: 1, DownloadPackets: 2, ConnCount: 1}, snapshot.Processes["<1>:curl"])
+	assert.Equal(t, &NetworkData{UploadBytes: 30, DownloadBytes: 60, UploadPackets: 1, DownloadPackets: 1, ConnCount: 1}, snapshot.RemoteAddrs["10.0.0.3"])
+	assert.Equal(t, &NetworkData{UploadBytes: 130, DownloadBytes: 2060, UploadPackets: 2, DownloadPackets: 3, ConnCount: 2}, snapshot.ProcessesTotal)
+	assert.Equal(t, start.Add(-2*time.Second), snapshot.Cumulative.Time)
+
+	// The socket of dig is closed, its connection keeps the process in the cumulative
+	// snapshot.
+	m.Put(Stat{
+		Time:        start.Add(2 * time.Second),
+		OpenSockets: socket.OpenSockets{curl.Local: {Pid: 1, Name: "curl"}},
+		Utilization: capture.Utilization{
+			curl: {Interface: "eth0", UploadBytes: 100, DownloadBytes: 1000, UploadPackets: 1, DownloadPackets: 1},
+			dig:  {Interface: "eth0", UploadBytes: 30, DownloadBytes: 0, UploadPackets: 1, DownloadPackets: 0},
+		},
+	})
+	snapshot = m.GetSnapshot()
+	assert.Equal(t, "<UNKNOWN>", snapshot.Connections[dig].ProcessName)
+	assert.Equal(t, &NetworkData{UploadBytes: 300, DownloadBytes: 5000, UploadPackets: 3, DownloadPackets: 5, ConnCount: 1}, snapshot.Cumulative.Processes["<1>:curl"])
+	assert.Equal(t, &NetworkData{UploadBytes: 90, DownloadBytes: 120, UploadPackets: 3, DownloadPackets: 2, ConnCount: 1}, snapshot.Cumulative.Processes["<2>:dig"])
+	assert.Equal(t, 390, snapshot.Cumulative.TotalUploadBytes)
+	assert.Equal(t, 2, snapshot.Cumulative.TotalConnections)
+
+	// The snapshots taken before are not affected by the later accumulation.
+	m.Reset()
+	m.Put(Stat{
+		Time:        start.Add(4 * time.Second),
+		OpenSockets: openSockets,
+		Utilization: capture.Utilization{curl: {Interface: "eth0", UploadBytes: 10, UploadPackets: 1}},
+	})
+	assert.Equal(t, 300, snapshot.Cumulative.Processes["<1>:curl"].UploadBytes)
+
+	snapshot = m.GetSnapshot()
+	assert.Equal(t, start.Add(2*time.Second), snapshot.Cumulative.Time)
+	assert.Equal(t, map[string]*NetworkData{
+		"<1>:curl": {UploadBytes: 10, UploadPackets: 1, ConnCount: 1},
+	}, snapshot.Cumulative.Processes)
+}
+
+func TestManagerCumulativeBounded(t *testing.T) {
+	curl := capture.Connection{
+		Local:  capture.LocalSocket{IP: "10.0.0.1", Port: 50000, Protocol: capture.ProtoTCP},
+		Remote: capture.RemoteSocket{IP: "10.0.0.2", Port: 443},
+	}
+	dig := capture.Connection{
+		Local:  capture.LocalSocket{IP: "10.0.0.1", Port: 40000, Protocol: capture.ProtoUDP},
+		Remote: capture.RemoteSocket{IP: "10.0.0.3", Port: 53},
+	}
+
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	names := map[string]string{}
+	m := NewManager(1, func(ip string) string {
+		if name, ok := names[ip]; ok {
+			return name
+		}
+		return ip
+	})
+	put := func(t time.Time, utilization capture.Utilization) *Snapshot {
+		m.Put(Stat{Time: t, OpenSockets: socket.OpenSockets{curl.Local: {Pid: 1, Name: "curl"}}, Utilization: utilization})
+		return m.GetSnapshot()
+	}
+
+	// Nothing is accumulated until enabled.
+	assert.Nil(t, put(start, capture.Utilization{curl: {Interface: "eth0", UploadBytes: 100}}).Cumulative)
+	m.SetCumulative(true)
+	put(start.Add(time.Second), capture.Utilization{
+		curl: {Interface: "eth0", UploadBytes: 10},
+		dig:  {Interface: "eth0", UploadBytes: 20},
+	})
+
+	// The connection whose remote is resolved later moves to the new remote address, the
+	// remote of the UDP connection is not resolved.
+	names["10.0.0.2"] = "example.com"
+	names["10.0.0.3"] = "dns.example.com"
+	snapshot := put(start.Add(2*time.Second), capture.Utilization{curl: {Interface: "eth0", UploadBytes: 5}})
+	assert.Equal(t, map[string]*NetworkData{
+		"example.com": {UploadBytes: 15, ConnCount: 1},
+		"10.0.0.3":    {UploadBytes: 20, ConnCount: 1},
+	}, snapshot.Cumulative.RemoteAddrs)
+	assert.Equal(t, "10.0.0.3", m.getRemoteName(dig, ""))
+	assert.Equal(t, newSnapshot(snapshot.Cumulative.Time, snapshot.Cumulative.Connections), snapshot.Cumulative)
+
+	// The idle connection is dropped from the connections while its traffic is kept.
+	snapshot = put(start.Add(2*time.Second+connectionIdleTimeout), capture.Utilization{curl: {Interface: "eth0", UploadBytes: 5}})
+	assert.Len(t, snapshot.Cumulative.Connections, 1)
+	assert.Contains(t, snapshot.Cumulative.Connections, curl)
+	assert.Equal(t, &NetworkData{UploadBytes: 20, ConnCount: 1}, snapshot.Cumulative.Processes["<UNKNOWN>"])
+	assert.Equal(t, 40, snapshot.Cumulative.TotalUploadBytes)
+	assert.Equal(t, 2, snapshot.Cumulative.TotalConnections)
+
+	m.SetCumulative(false)
+	assert.Nil(t, put(start.Add(3*time.Second+connectionIdleTimeout), nil).Cumulative)
+}
+
+func TestManagerAveragesAndPeaks(t *testing.T) {
+	curl := capture.Connection{
+		Local:  capture.LocalSocket{IP: "10.0.0.1", Port: 50000, Protocol: capture.ProtoTCP},
+		Remote: capture.RemoteSocket{IP: "10.0.0.2", Port: 443},
+	}
+	openSockets := socket.OpenSockets{curl.Local: {Pid: 1, Name: "curl"}}
+
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	m := NewManager(1, nil)
+	put := func(i, upload int) {
+		m.Put(Stat{
+			Time:        start.Add(time.Duration(i) * time.Second),
+			OpenSockets: openSockets,
+			Utilization: capture.Utilization{curl: {Interface: "eth0", UploadBytes: upload, UploadPackets: 1}},
+		})
+	}
+
+	// Nothing is averaged until enabled.
+	put(-1, 1000)
+	assert.Nil(t, m.GetSnapshot().Averages)
+	assert.Nil(t, m.GetSnapshot().Peaks)
+
+	m.SetAverages(true)
+	for i, upload := range []int{100, 400, 100} {
+		put(i, upload)
+	}
+	snapshot := m.GetSnapshot()
+	assert.Len(t, snapshot.Averages, len(AverageWindows))
+	assert.Equal(t, 100, snapshot.Averages[0].Processes["<1>:curl"].UploadBytes)
+	assert.Equal(t, 200, snapshot.Averages[1].Processes["<1>:curl"].UploadBytes)
+	assert.Equal(t, 200, snapshot.Averages[2].ProcessesTotal.UploadBytes)
+	assert.Equal(t, 400, snapshot.Peaks.Processes["<1>:curl"].UploadBytes)
+	assert.Equal(t, 400, snapshot.Peaks.Connections[curl].UploadBytes)
+
+	// The intervals out of the windows are dropped, the peaks are kept until reset.
+	for i := 3; i < 63; i++ {
+		put(i, 10)
+	}
+	snapshot = m.GetSnapshot()
+	assert.Equal(t, 10, snapshot.Averages[1].Processes["<1>:curl"].UploadBytes)
+	assert.Equal(t, 10, snapshot.Averages[2].Processes["<1>:curl"].UploadBytes)
+	assert.Equal(t, 400, snapshot.Peaks.Processes["<1>:curl"].UploadBytes)
+
+	m.Reset()
+	put(63, 20)
+	assert.Equal(t, 20, m.GetSnapshot().Peaks.Processes["<1>:curl"].UploadBytes)
+
+	// The peaks of the idle connection and the idle keys are dropped alike.
+	m.Put(Stat{Time: start.Add(63*time.Second + connectionIdleTimeout), OpenSockets: openSockets})
+	assert.Equal(t, 20, m.GetSnapshot().Peaks.Processes["<1>:curl"].UploadBytes)
+	m.Put(Stat{Time: start.Add(63*time.Second + connectionIdleTimeout + time.Second), OpenSockets: openSockets})
+	snapshot = m.GetSnapshot()
+	assert.Empty(t, snapshot.Peaks.Connections)
+	assert.Empty(t, snapshot.Peaks.Processes)
+	assert.Empty(t, snapshot.Peaks.RemoteAddrs)
+	assert.Empty(t, snapshot.Peaks.Containers)
+	assert.Empty(t, snapshot.Peaks.Interfaces)
+	assert.Equal(t, 20, snapshot.Peaks.ProcessesTotal.UploadBytes)
+
+	m.SetAverages(false)
+	assert.Nil(t, m.GetSnapshot().Peaks)
+}
+
+func TestTopNBy(t *testing.T) {
+	snapshot := &Snapshot{
+		Processes: map[string]*NetworkData{
+			"<1>:curl":  {UploadBytes: 100, DownloadBytes: 4000, ConnCount: 1},
+			"<2>:nginx": {UploadBytes: 3000, DownloadBytes: 200, ConnCount: 8},
+			"<3>:dig":   {UploadBytes: 60, DownloadBytes: 120, ConnCount: 2},
+		},
+	}
+	names := func(key SortKey, matcher Matcher) []string {
+		var names []string
+		for _, r := range snapshot.TopNProcessesBy(3, OrderBytes, key, matcher) {
+			names = append(names, r.ProcessName)
+		}
+		return names
+	}
+
+	assert.Equal(t, []string{"<1>:curl", "<2>:nginx", "<3>:dig"}, names(SortTotal, nil))
+	assert.Equal(t, []string{"<2>:nginx", "<1>:curl", "<3>:dig"}, names(SortUpload, nil))
+	assert.Equal(t, []string{"<1>:curl", "<2>:nginx", "<3>:dig"}, names(SortDownload, nil))
+	assert.Equal(t, []string{"<2>:nginx", "<3>:dig", "<1>:curl"}, names(SortConnections, nil))
+	assert.Equal(t, []string{"<1>:curl", "<2>:nginx", "<3>:dig"}, names(SortName, nil))
+
+	notCurl := func(fields ...string) bool { return fields[0] != "<1>:curl" }
+	assert.Equal(t, []string{"<2>:nginx", "<3>:dig"}, names(SortTotal, notCurl))
+
+	for _, key := range SortKeys {
+		parsed, err := ParseSortKey(key.String())
+		assert.NoError(t, err)
+		assert.Equal(t, key, parsed)
+	}
+	_, err := ParseSortKey("bytes")
+	assert.Error(t, err)
+}
+
+func TestProcessSnapshot(t *testing.T) {
+	curl := capture.Connection{
+		Local:  capture.LocalSocket{IP: "10.0.0.1", Port: 50000, Protocol: capture.ProtoTCP},
+		Remote: capture.RemoteSocket{IP: "10.0.0.2", Port: 443},
+	}
+	curl2 := capture.Connection{
+		Local:  capture.LocalSocket{IP: "10.0.0.1", Port: 50001, Protocol: capture.ProtoTCP},
+		Remote: capture.RemoteSocket{IP: "10.0.0.2", Port: 443},
+	}
+	dig := capture.Connection{
+		Local:  capture.LocalSocket{IP: "10.0.0.1", Port: 40000, Protocol: capture.ProtoUDP},
+		Remote: capture.RemoteSocket{IP: "10.0.0.3", Port: 53},
+	}
+	openSockets := socket.OpenSockets{
+		curl.Local:  {Pid: 1, Name: "curl"},
+		curl2.Local: {Pid: 1, Name: "curl"},
+		dig.Local:   {Pid: 2, Name: "dig"},
+	}
+
+	m := NewManager(1, nil)
+	m.SetCumulative(true)
+	m.Put(Stat{
+		Time:        time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+		OpenSockets: openSockets,
+		Utilization: capture.Utilization{
+			curl:  {Interface: "eth0", UploadBytes: 100, DownloadBytes: 1000},
+			curl2: {Interface: "eth1", UploadBytes: 50, DownloadBytes: 500},
+			dig:   {Interface: "eth0", UploadBytes: 60, DownloadBytes: 120},
+		},
+	})
+	m.Put(Stat{
+		Time:        time.Date(2021, 1, 1, 0, 0, 1, 0, time.UTC),
+		OpenSockets: openSockets,
+		Utilization: capture.Utilization{curl: {Interface: "eth0", UploadBytes: 10, DownloadBytes: 20}},
+	})
+
+	snapshot := m.GetSnapshot().ProcessSnapshot("<1>:curl")
+	assert.Len(t, snapshot.Connections, 1)
+	assert.Equal(t, &NetworkData{UploadBytes: 10, DownloadBytes: 20, ConnCount: 1}, snapshot.RemoteAddrs["10.0.0.2"])
+	assert.Equal(t, 1, snapshot.TotalConnections)
+
+	assert.Len(t, snapshot.Cumulative.Connections, 2)
+	assert.Equal(t, &NetworkData{UploadBytes: 160, DownloadBytes: 1520, ConnCount: 2}, snapshot.Cumulative.Processes["<1>:curl"])
+	assert.NotContains(t, snapshot.Cumulative.Processes, "<2>:dig")
+	assert.Equal(t, map[string]*NetworkData{
+		"eth0": {UploadBytes: 110, DownloadBytes: 1020, ConnCount: 1},
+		"eth1": {UploadBytes: 50, DownloadBytes: 500, ConnCount: 1},
+	}, snapshot.Cumulative.Interfaces)
+	assert.Equal(t, "eth1", snapshot.Cumulative.TopNInterfacesBy(1, OrderBytes, SortName, Matcher(func(fields ...string) bool {
+		return fields[0] != "eth0"
+	}))[0].Interface)
+	assert.Nil(t, snapshot.Averages)
+	assert.Nil(t, snapshot.Peaks)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/chenjiandongx/sniffer/pkg/stats"
+)
+
+// reporterTopN is the number of the processes reported by the StatsD and syslog
+// reporters on every interval.
+const reporterTopN = 10
+
+// Reporter receives the snapshot of every interval. Several reporters run at once so
+// that the terminal UI, the files and the metrics backends share the same capture.
+type Reporter interface {
+	Report(snapshot *stats.Snapshot) error
+	Close() error
+}
+
+// newReporters creates the reporters other than the terminal UI from the options.
+func newReporters(opts Options) ([]Reporter, error) {
+	var reporters []Reporter
+	closeAll := func() {
+		for _, r := range reporters {
+			r.Close()
+		}
+	}
+
+	if opts.JSONOutput != "" {
+		r, err := NewJSONWriter(opts.JSONOutput, opts)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		reporters = append(reporters, r)
+	}
+	if opts.CSVOutput != "" {
+		r, err := NewCSVWriter(opts.CSVOutput, opts)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		reporters = append(reporters, r)
+	}
+	if opts.ExporterAddr != "" {
+		r, err := NewExporter(opts)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		reporters = append(reporters, r)
+	}
+	if opts.StatsDAddr != "" {
+		r, err := NewStatsDReporter(opts)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		reporters = append(reporters, r)
+	}
+	if opts.SyslogAddr != "" {
+		r, err := NewSyslogReporter(opts)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		reporters = append(reporters, r)
+	}
+	return reporters, nil
+}
+
+// formatLogLines formats the totals and the top processes of the snapshot as logfmt
+// lines, the traffic is the rates per second in the interval.
+func formatLogLines(snapshot *stats.Snapshot) []string {
+	lines := []string{fmt.Sprintf("totals connections=%d upload_bytes=%d download_bytes=%d upload_packets=%d download_packets=%d",
+		snapshot.TotalConnections,
+		snapshot.TotalUploadBytes,
+		snapshot.TotalDownloadBytes,
+		snapshot.TotalUploadPackets,
+		snapshot.TotalDownloadPackets,
+	)}
+
+	for _, r := range snapshot.TopNProcesses(reporterTopN, stats.OrderBytes) {
+		lines = append(lines, fmt.Sprintf("process name=%q connections=%d upload_bytes=%d download_bytes=%d upload_packets=%d download_packets=%d",
+			r.ProcessName,
+			r.Data.ConnCount,
+			r.Data.UploadBytes,
+			r.Data.DownloadBytes,
+			r.Data.UploadPackets,
+			r.Data.DownloadPackets,
+		))
+	}
+	return lines
+}
+
+// sanitizeMetricName replaces the characters which are not allowed in the StatsD
+// metric names, the runs of the replaced characters are collapsed into one underscore.
+func sanitizeMetricName(s string) string {
+	var b strings.Builder
+	var replaced bool
+	for _, c := range s {
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' {
+			b.WriteRune(c)
+			replaced = false
+			continue
+		}
+		if !replaced && b.Len() > 0 {
+			b.WriteByte('_')
+		}
+		replaced = true
+	}
+	return strings.TrimSuffix(b.String(), "_")
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chenjiandongx/sniffer/pkg/socket"
+	"github.com/chenjiandongx/sniffer/pkg/stats"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSnapshot() *stats.Snapshot {
+	return &stats.Snapshot{
+		Processes: map[string]*stats.NetworkData{
+			"<42>:curl": {UploadBytes: 100, DownloadBytes: 2000, UploadPackets: 1, DownloadPackets: 2, ConnCount: 1},
+		},
+		TotalUploadBytes:     100,
+		TotalDownloadBytes:   2000,
+		TotalUploadPackets:   1,
+		TotalDownloadPackets: 2,
+		TotalConnections:     1,
+	}
+}
+
+func TestStatsDReporter(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer pc.Close()
+
+	r, err := NewStatsDReporter(Options{StatsDAddr: pc.LocalAddr().String(), StatsDPrefix: "sniffer"})
+	assert.NoError(t, err)
+	defer r.Close()
+
+	// The processes sharing the name are summed into the same gauges.
+	snapshot := newTestSnapshot()
+	snapshot.Processes["<43>:curl"] = &stats.NetworkData{UploadBytes: 10, UploadPackets: 1, ConnCount: 1}
+	snapshot.Processes[socket.UnknownProcessName] = &stats.NetworkData{DownloadBytes: 5, DownloadPackets: 1, ConnCount: 1}
+	assert.NoError(t, r.Report(snapshot))
+
+	buf := make([]byte, statsdMaxPacketSize)
+	pc.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	assert.NoError(t, err)
+
+	lines := strings.Split(string(buf[:n]), "\n")
+	assert.Len(t, lines, 15)
+	assert.Contains(t, lines, "sniffer.total.download_bytes:2000|g")
+	assert.Contains(t, lines, "sniffer.process.curl.upload_packets:2|g")
+	assert.Contains(t, lines, "sniffer.process.curl.connections:2|g")
+	assert.Contains(t, lines, "sniffer.process.UNKNOWN.download_bytes:5|g")
+}
+
+func TestFormatLogLines(t *testing.T) {
+	assert.Equal(t, []string{
+		"totals connections=1 upload_bytes=100 download_bytes=2000 upload_packets=1 download_packets=2",
+		`process name="<42>:curl" connections=1 upload_bytes=100 download_bytes=2000 upload_packets=1 download_packets=2`,
+	}, formatLogLines(newTestSnapshot()))
+}
+
+type stubReporter struct {
+	err     error
+	reports int
+}
+
+func (r *stubReporter) Report(snapshot *stats.Snapshot) error {
+	r.reports++
+	return r.err
+}
+
+func (r *stubReporter) Close() error {
+	return nil
+}
+
+func TestSnifferRefreshReportErrors(t *testing.T) {
+	broken := &stubReporter{err: errors.New("write /var/log/sniffer.csv: no space left on device")}
+	working := &stubReporter{}
+	s := &Sniffer{reporters: []Reporter{broken, working}}
+
+	// A broken reporter does not hold the others, even while the terminal UI is paused.
+	s.refresh(newTestSnapshot(), true)
+	s.refresh(newTestSnapshot(), false)
+	assert.Equal(t, 2, broken.reports)
+	assert.Equal(t, 2, working.reports)
+	assert.Equal(t, "Report failed: write /var/log/sniffer.csv: no space left on device", s.statusFooter())
+
+	broken.err = nil
+	s.refresh(newTestSnapshot(), false)
+	assert.Equal(t, "", s.statusFooter())
+
+	s.warning = "socket backend ebpf is unavailable, fell back to native: operation not permitted"
+	assert.Equal(t, "Warning: socket backend ebpf is unavailable, fell back to native: operation not permitted", s.statusFooter())
+}
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
-	"time"
+	"os/signal"
+	"strings"
+	"syscall"
 
+	"github.com/chenjiandongx/sniffer/pkg/sniffer"
+	"github.com/chenjiandongx/sniffer/pkg/stats"
 	"github.com/gizak/termui/v3"
 )
 
@@ -13,29 +18,64 @@ func exit(s string) {
 	os.Exit(1)
 }
 
-// Options is the options set for the sniffer instance.
+// Options is the options set for the sniffer instance, the capture options are embedded
+// from the library.
 type Options struct {
-	// BPFFilter is the string pcap filter with the BPF syntax
-	// eg. "tcp and port 80"
-	BPFFilter string
-
-	// Interval is the interval for refresh rate in seconds
-	Interval int
+	sniffer.Options
 
 	// ViewMode represents the sniffer view mode, optional: bytes, packets, processes
 	ViewMode ViewMode
 
-	// DevicesPrefix represents prefixed devices to monitor
-	DevicesPrefix []string
-
 	// Unit of stats in processes mode, optional: B, Kb, KB, Mb, MB, Gb, GB
 	Unit Unit
 
-	// DisableDNSResolve decides whether if disable the DNS resolution
-	DisableDNSResolve bool
+	// Cumulative decides whether to show the traffic accumulated since shown or the last
+	// reset along with the rates in the tables
+	Cumulative bool
+
+	// Averages decides whether to show the moving averages and the peaks of the rates in
+	// the tables
+	Averages bool
+
+	// SortKey is the field which the rows of the tables are ranked by
+	SortKey stats.SortKey
+
+	// Filter keeps the rows of the tables whose process, address or port match it, which is
+	// a regular expression or a plain substring
+	Filter string
+
+	// Pins are the processes and the remote addresses which are always plotted in the plot
+	// mode besides the top processes, the processes may be pinned by the names without pids
+	Pins []string
+
+	// JSONOutput is the file to write the snapshots as JSON lines in the headless mode,
+	// "-" means the stdout
+	JSONOutput string
+
+	// CSVOutput is the file to write a row per connection of the snapshots into, it is
+	// rotated daily, "-" means the stdout and the .tsv extension separates by tabs
+	CSVOutput string
 
-	// AllDevices specifies whether to listen all devices or not
-	AllDevices bool
+	// ExporterAddr is the address to serve the Prometheus metrics on in the headless mode
+	ExporterAddr string
+
+	// ExporterTopN is the number of the series exported for processes, remote addresses
+	// and interfaces respectively, the rest are rolled into the "other" series
+	ExporterTopN int
+
+	// StatsDAddr is the UDP address of the StatsD server to send the gauges to
+	StatsDAddr string
+
+	// StatsDPrefix is prepended to the names of the StatsD gauges
+	StatsDPrefix string
+
+	// SyslogAddr is the syslog server to write the stats to, "local" means the local
+	// syslog daemon, or the remote one addressed like udp://host:514
+	SyslogAddr string
+
+	// TUI decides whether to keep the terminal UI along with the other reporters, which
+	// run in the headless mode otherwise
+	TUI bool
 }
 
 func (o Options) Validate() error {
@@ -45,64 +85,301 @@ func (o Options) Validate() error {
 	if err := o.Unit.Validate(); err != nil {
 		return err
 	}
-	return nil
+	if o.ExporterTopN < 0 {
+		return fmt.Errorf("invalid exporter top n %d", o.ExporterTopN)
+	}
+	if o.TUI && (o.JSONOutput == "-" || o.CSVOutput == "-") {
+		return errors.New("the terminal UI can not share the stdout with the JSON or CSV output")
+	}
+	if o.JSONOutput == "-" && o.CSVOutput == "-" {
+		return errors.New("the JSON and CSV outputs can not share the stdout")
+	}
+	return o.Options.Validate()
 }
 
 func DefaultOptions() Options {
-	return Options{
-		BPFFilter:         "tcp or udp",
-		Interval:          1,
-		ViewMode:          ModeTableBytes,
-		Unit:              UnitKB,
-		DevicesPrefix:     []string{"en", "lo", "eth", "em", "bond"},
-		DisableDNSResolve: false,
-		AllDevices:        false,
+	opts := Options{
+		Options:      sniffer.DefaultOptions(),
+		ViewMode:     ModeTableBytes,
+		Unit:         UnitKB,
+		ExporterTopN: 20,
+		StatsDPrefix: "sniffer",
 	}
+	opts.Application = "sniffer " + version
+	return opts
 }
 
 type Sniffer struct {
-	opts          Options
-	dnsResolver   *DNSResolver
-	pcapClient    *PcapClient
-	statsManager  *StatsManager
-	ui            *UIComponent
-	socketFetcher SocketFetcher
+	opts      Options
+	sniffer   *sniffer.Sniffer
+	ui        *UIComponent
+	reporters []Reporter
+
+	// reportErrs are the last errors of the reporters, an error is logged once until it
+	// changes or the reporter recovers, so that a broken output does not flood the log.
+	reportErrs []string
+
+	// warning is the error which the sniffer has worked around, it is shown once until it
+	// changes.
+	warning string
+
+	// input is the input line which the typed keys go to.
+	input inputMode
+
+	// bpfInput is the BPF filter being edited in the input line.
+	bpfInput string
+
+	// pinInput is the process or the remote address being typed to pin.
+	pinInput string
 }
 
 func NewSniffer(opts Options) (*Sniffer, error) {
-	dnsResolver := NewDnsResolver()
-	pcapClient, err := NewPcapClient(dnsResolver.Lookup, opts)
-	if err != nil {
+	s := &Sniffer{opts: opts}
+
+	var err error
+	if s.sniffer, err = sniffer.NewSniffer(opts.Options); err != nil {
+		return nil, err
+	}
+	if s.reporters, err = newReporters(opts); err != nil {
+		s.Close()
 		return nil, err
 	}
 
-	return &Sniffer{
-		opts:          opts,
-		dnsResolver:   dnsResolver,
-		pcapClient:    pcapClient,
-		statsManager:  NewStatsManager(opts),
-		ui:            NewUIComponent(opts),
-		socketFetcher: GetSocketFetcher(),
-	}, nil
+	// The other reporters run in the headless mode without initializing the terminal UI
+	// unless it is asked for explicitly.
+	if len(s.reporters) == 0 || opts.TUI {
+		s.ui = NewUIComponent(opts)
+		s.reporters = append(s.reporters, s.ui)
+		s.sniffer.SetCumulative(opts.Cumulative)
+		s.sniffer.SetAverages(opts.Averages)
+	}
+	s.checkWarning()
+	return s, nil
+}
+
+// checkWarning shows the warning of the sniffer if it has changed since the last check.
+func (s *Sniffer) checkWarning() {
+	var msg string
+	if err := s.sniffer.Warning(); err != nil {
+		msg = err.Error()
+	}
+	if msg != s.warning {
+		s.warn(msg)
+	}
+}
+
+// warn shows the error which the sniffer has worked around in the footer, or on stderr in
+// the headless mode. An empty message clears the warning.
+func (s *Sniffer) warn(msg string) {
+	s.warning = msg
+	if s.ui == nil {
+		if msg != "" {
+			fmt.Fprintln(os.Stderr, "Warning:", msg)
+		}
+		return
+	}
+	if s.input == inputNone {
+		s.ui.SetFooter(s.statusFooter())
+	}
+}
+
+// closeInput closes the input line and restores the footer, or shows the last error of
+// the reporters or the warning if any.
+func (s *Sniffer) closeInput() {
+	s.input = inputNone
+	s.ui.SetFooter(s.statusFooter())
 }
 
 func (s *Sniffer) SwitchViewMode() {
 	s.opts.ViewMode = (s.opts.ViewMode + 1) % 3
-	s.statsManager = NewStatsManager(s.opts)
 
-	s.ui.Close()
-	s.ui = NewUIComponent(s.opts)
+	s.ui.SwitchViewMode(s.opts)
+	s.restoreFooter()
+}
+
+// SwitchCumulative toggles the cumulative columns of the tables, the traffic is only
+// accumulated while they are shown.
+func (s *Sniffer) SwitchCumulative() {
+	s.opts.Cumulative = !s.opts.Cumulative
+
+	s.sniffer.SetCumulative(s.opts.Cumulative)
+	s.ui.SetColumns(s.opts)
+}
+
+// SwitchAverages toggles the averaged columns of the tables, the averages and the peaks
+// are only computed while they are shown.
+func (s *Sniffer) SwitchAverages() {
+	s.opts.Averages = !s.opts.Averages
+
+	s.sniffer.SetAverages(s.opts.Averages)
+	s.ui.SetColumns(s.opts)
+}
+
+// SwitchSortKey ranks the tables by the next sort key.
+func (s *Sniffer) SwitchSortKey() {
+	s.opts.SortKey = (s.opts.SortKey + 1) % stats.SortKey(len(stats.SortKeys))
+
+	s.ui.SetQuery(s.opts)
+}
+
+// inputMode is the input line which the typed keys go to rather than the hotkeys.
+type inputMode uint8
+
+const (
+	inputNone inputMode = iota
+	inputSearch
+	inputBPF
+	inputPin
+)
+
+// editLine edits the text of the input line by the typed key, <enter> submits the input
+// and <esc> cancels it.
+func editLine(text string, e termui.Event) (edited string, submit, cancel bool) {
+	switch e.ID {
+	case "<Enter>":
+		return text, true, false
+	case "<Escape>":
+		return text, false, true
+	case "<Backspace>", "<C-<Backspace>>":
+		if runes := []rune(text); len(runes) > 0 {
+			text = string(runes[:len(runes)-1])
+		}
+	case "<Space>":
+		text += " "
+	default:
+		if len([]rune(e.ID)) == 1 {
+			text += e.ID
+		}
+	}
+	return text, false, false
+}
+
+func searchPrompt(filter string) string {
+	return fmt.Sprintf("Search: %s_  <enter> Apply. <esc> Clear", filter)
+}
+
+// editFilter edits the filter of the tables by the key typed in the search, the rows are
+// filtered as typing.
+func (s *Sniffer) editFilter(e termui.Event) {
+	filter, submit, cancel := editLine(s.opts.Filter, e)
+	if cancel {
+		filter = ""
+	}
+	s.opts.Filter = filter
+	s.ui.SetQuery(s.opts)
+
+	if submit || cancel {
+		s.closeInput()
+		return
+	}
+	s.ui.SetFooter(searchPrompt(filter))
+}
+
+func bpfPrompt(filter string, err error) string {
+	if err != nil {
+		return fmt.Sprintf("BPF: %s_  Error: %v", filter, err)
+	}
+	return fmt.Sprintf("BPF: %s_  <enter> Apply. <esc> Cancel", filter)
+}
+
+// editBPFFilter edits the BPF filter by the typed key, the filter is applied to the capture
+// once submitted. The input stays open with the error if it fails to compile or apply.
+func (s *Sniffer) editBPFFilter(e termui.Event) {
+	var submit, cancel bool
+	s.bpfInput, submit, cancel = editLine(s.bpfInput, e)
+
+	switch {
+	case cancel:
+		s.closeInput()
+	case submit:
+		if err := s.sniffer.SetBPFFilter(s.bpfInput); err != nil {
+			s.ui.SetFooter(bpfPrompt(s.bpfInput, err))
+			return
+		}
+		s.opts.BPFFilter = s.bpfInput
+		s.closeInput()
+	default:
+		s.ui.SetFooter(bpfPrompt(s.bpfInput, nil))
+	}
+}
+
+func pinPrompt(pin string) string {
+	return fmt.Sprintf("Pin process or remote address: %s_  <enter> Pin or unpin. <esc> Cancel", pin)
+}
+
+// editPin edits the process or the remote address to pin by the typed key, it is pinned
+// to the plots once submitted, or unpinned if pinned already.
+func (s *Sniffer) editPin(e termui.Event) {
+	var submit, cancel bool
+	s.pinInput, submit, cancel = editLine(s.pinInput, e)
+
+	if !submit && !cancel {
+		s.ui.SetFooter(pinPrompt(s.pinInput))
+		return
+	}
+	if submit && s.pinInput != "" {
+		s.togglePin(s.pinInput)
+	}
+	s.closeInput()
+}
+
+// togglePin pins the process or the remote address to the plots, or unpins it if pinned.
+func (s *Sniffer) togglePin(pin string) {
+	pins := make([]string, 0, len(s.opts.Pins)+1)
+	var pinned bool
+	for _, p := range s.opts.Pins {
+		if p == pin {
+			pinned = true
+			continue
+		}
+		pins = append(pins, p)
+	}
+	if !pinned {
+		pins = append(pins, pin)
+	}
+
+	s.opts.Pins = pins
+	s.ui.SetPins(pins)
 }
 
 func (s *Sniffer) Start() {
-	events := termui.PollEvents()
-	s.Refresh()
+	var events <-chan termui.Event
+	if s.ui != nil {
+		events = termui.PollEvents()
+	}
 	var paused bool
 
-	ticker := time.Tick(time.Duration(s.opts.Interval) * time.Second)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	snapshots := s.sniffer.Subscribe()
 	for {
+		// The replay is held on while paused, nothing is lost as it is bucketed by the
+		// packet timestamps. The live snapshots are kept receiving so that the capture
+		// and the other reporters go on, only the terminal UI is frozen.
+		snapshotsCh := snapshots
+		if paused && s.opts.ReadFile != "" {
+			snapshotsCh = nil
+		}
+
 		select {
+		case <-signals:
+			return
+
 		case e := <-events:
+			if s.input != inputNone && e.Type == termui.KeyboardEvent {
+				switch s.input {
+				case inputSearch:
+					s.editFilter(e)
+				case inputBPF:
+					s.editBPFFilter(e)
+				case inputPin:
+					s.editPin(e)
+				}
+				continue
+			}
+
 			switch e.ID {
 			case "<Tab>":
 				s.ui.viewer.Shift()
@@ -113,31 +390,120 @@ func (s *Sniffer) Start() {
 				s.ui.viewer.Resize(payload.Width, payload.Height)
 			case "s", "S":
 				s.SwitchViewMode()
+			case "c", "C":
+				s.SwitchCumulative()
+			case "a", "A":
+				s.SwitchAverages()
+			case "r", "R":
+				s.sniffer.ResetCumulative()
+			case "<Up>":
+				s.ui.Select(-1)
+			case "<Down>":
+				s.ui.Select(1)
+			case "<Enter>":
+				s.ui.OpenDetail()
+				s.restoreFooter()
+			case "<Escape>", "<Backspace>", "<C-<Backspace>>":
+				s.ui.CloseDetail()
+				s.restoreFooter()
+			case "o", "O":
+				s.SwitchSortKey()
+			case "/":
+				if _, ok := s.ui.viewer.(*TableViewer); ok {
+					s.input = inputSearch
+					s.ui.SetFooter(searchPrompt(s.opts.Filter))
+				}
+			case "b", "B":
+				s.input = inputBPF
+				s.bpfInput = s.opts.BPFFilter
+				s.ui.SetFooter(bpfPrompt(s.bpfInput, nil))
+			case "p", "P":
+				s.input = inputPin
+				s.pinInput = s.ui.SelectedProcess()
+				s.ui.SetFooter(pinPrompt(s.pinInput))
 			case "q", "Q", "<C-c>":
 				return
 			}
 
-		case <-ticker:
-			if !paused {
-				s.Refresh()
+		case snapshot, ok := <-snapshotsCh:
+			if !ok {
+				if err := s.sniffer.Err(); err != nil {
+					s.warn(err.Error())
+				}
+				// Keep the last stats on the screen until quit.
+				if s.ui == nil {
+					return
+				}
+				snapshots = nil
+				continue
 			}
+			s.refresh(snapshot, paused)
+			s.checkWarning()
 		}
 	}
 }
 
 func (s *Sniffer) Close() {
-	s.ui.Close()
-	s.pcapClient.Close()
-	s.dnsResolver.Close()
+	for _, r := range s.reporters {
+		r.Close()
+	}
+	s.sniffer.Close()
 }
 
-func (s *Sniffer) Refresh() {
-	utilization := s.pcapClient.sinker.GetUtilization()
-	openSockets, err := s.socketFetcher.GetOpenSockets()
-	if err != nil {
-		return
+// refresh reports the snapshot to all the reporters, the terminal UI keeps the last stats
+// on the screen while paused.
+func (s *Sniffer) refresh(snapshot *stats.Snapshot, paused bool) {
+	if len(s.reportErrs) != len(s.reporters) {
+		s.reportErrs = make([]string, len(s.reporters))
+	}
+
+	var changed bool
+	for i, r := range s.reporters {
+		if paused && r == s.ui {
+			continue
+		}
+
+		var msg string
+		if err := r.Report(snapshot); err != nil {
+			msg = err.Error()
+		}
+		if msg == s.reportErrs[i] {
+			continue
+		}
+		s.reportErrs[i] = msg
+		changed = true
+		if msg != "" && s.ui == nil {
+			fmt.Fprintln(os.Stderr, "Report failed:", msg)
+		}
+	}
+
+	if changed && s.ui != nil && s.input == inputNone {
+		s.ui.SetFooter(s.statusFooter())
+	}
+}
+
+// restoreFooter shows the errors of the reporters or the warning again in the footer of
+// the new viewer.
+func (s *Sniffer) restoreFooter() {
+	if footer := s.statusFooter(); footer != "" {
+		s.ui.SetFooter(footer)
 	}
+}
 
-	s.statsManager.Put(Stat{OpenSockets: openSockets, Utilization: utilization})
-	s.ui.viewer.Render(s.statsManager.GetStats())
+// statusFooter returns the footer showing the errors of the reporters, or the warning on
+// start if all of them work. It is empty for the default footer if there is neither.
+func (s *Sniffer) statusFooter() string {
+	var errs []string
+	for _, msg := range s.reportErrs {
+		if msg != "" {
+			errs = append(errs, msg)
+		}
+	}
+	if len(errs) == 0 {
+		if s.warning != "" {
+			return "Warning: " + s.warning
+		}
+		return ""
+	}
+	return "Report failed: " + strings.Join(errs, "; ")
 }
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/gizak/termui/v3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEditLine(t *testing.T) {
+	tests := []struct {
+		text, key      string
+		edited         string
+		submit, cancel bool
+	}{
+		{text: "cur", key: "l", edited: "curl"},
+		{text: "cur", key: "é", edited: "curé"},
+		{text: "curl", key: "<Space>", edited: "curl "},
+		{text: "curl", key: "<Backspace>", edited: "cur"},
+		{text: "名字", key: "<Backspace>", edited: "名"},
+		{text: "café", key: "<C-<Backspace>>", edited: "caf"},
+		{text: "", key: "<Backspace>", edited: ""},
+		{text: "curl", key: "<Tab>", edited: "curl"},
+		{text: "curl", key: "<Enter>", edited: "curl", submit: true},
+		{text: "curl", key: "<Escape>", edited: "curl", cancel: true},
+	}
+	for _, tt := range tests {
+		edited, submit, cancel := editLine(tt.text, termui.Event{Type: termui.KeyboardEvent, ID: tt.key})
+		assert.Equal(t, tt.edited, edited, tt.key)
+		assert.Equal(t, tt.submit, submit, tt.key)
+		assert.Equal(t, tt.cancel, cancel, tt.key)
+	}
+}
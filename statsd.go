@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/chenjiandongx/sniffer/pkg/stats"
+)
+
+// statsdMaxPacketSize keeps the datagrams within the common MTU of the networks.
+const statsdMaxPacketSize = 1432
+
+// StatsDReporter sends the totals and the top processes of the snapshots as StatsD
+// gauges over UDP, the gauges are the rates per second in the interval. The processes
+// are keyed by the name without the pid, so that the gauges of the short-lived processes
+// do not pile up in the StatsD backend.
+type StatsDReporter struct {
+	conn   net.Conn
+	prefix string
+}
+
+func NewStatsDReporter(opt Options) (*StatsDReporter, error) {
+	conn, err := net.Dial("udp", opt.StatsDAddr)
+	if err != nil {
+		return nil, err
+	}
+	return &StatsDReporter{conn: conn, prefix: opt.StatsDPrefix}, nil
+}
+
+func (r *StatsDReporter) metricName(elem ...string) string {
+	name := r.prefix
+	for _, e := range elem {
+		if name != "" {
+			name += "."
+		}
+		name += e
+	}
+	return name
+}
+
+func (r *StatsDReporter) gauges(snapshot *stats.Snapshot) []string {
+	var lines []string
+	add := func(name string, data *stats.NetworkData) {
+		lines = append(lines,
+			fmt.Sprintf("%s:%d|g", r.metricName(name, "connections"), data.ConnCount),
+			fmt.Sprintf("%s:%d|g", r.metricName(name, "upload_bytes"), data.UploadBytes),
+			fmt.Sprintf("%s:%d|g", r.metricName(name, "download_bytes"), data.DownloadBytes),
+			fmt.Sprintf("%s:%d|g", r.metricName(name, "upload_packets"), data.UploadPackets),
+			fmt.Sprintf("%s:%d|g", r.metricName(name, "download_packets"), data.DownloadPackets),
+		)
+	}
+
+	add("total", &stats.NetworkData{
+		UploadBytes:     snapshot.TotalUploadBytes,
+		DownloadBytes:   snapshot.TotalDownloadBytes,
+		UploadPackets:   snapshot.TotalUploadPackets,
+		DownloadPackets: snapshot.TotalDownloadPackets,
+		ConnCount:       snapshot.TotalConnections,
+	})
+	byName := &stats.Snapshot{Processes: processesByName(snapshot.Processes)}
+	for _, p := range byName.TopNProcesses(reporterTopN, stats.OrderBytes) {
+		add("process."+sanitizeMetricName(p.ProcessName), p.Data)
+	}
+	return lines
+}
+
+// processesByName sums the traffic of the processes sharing the same name.
+func processesByName(processes map[string]*stats.NetworkData) map[string]*stats.NetworkData {
+	byName := make(map[string]*stats.NetworkData, len(processes))
+	for key, data := range processes {
+		name := key
+		if strings.HasPrefix(key, "<") {
+			if idx := strings.Index(key, ">:"); idx > 0 {
+				name = key[idx+2:]
+			}
+		}
+
+		sum, ok := byName[name]
+		if !ok {
+			sum = &stats.NetworkData{}
+			byName[name] = sum
+		}
+		sum.UploadBytes += data.UploadBytes
+		sum.DownloadBytes += data.DownloadBytes
+		sum.UploadPackets += data.UploadPackets
+		sum.DownloadPackets += data.DownloadPackets
+		sum.ConnCount += data.ConnCount
+	}
+	return byName
+}
+
+// Report sends the gauges batched into the datagrams of statsdMaxPacketSize.
+func (r *StatsDReporter) Report(snapshot *stats.Snapshot) error {
+	var buf bytes.Buffer
+	for _, line := range r.gauges(snapshot) {
+		if buf.Len() > 0 && buf.Len()+len(line)+1 > statsdMaxPacketSize {
+			if _, err := r.conn.Write(buf.Bytes()); err != nil {
+				return err
+			}
+			buf.Reset()
+		}
+		if buf.Len() > 0 {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(line)
+	}
+
+	if buf.Len() == 0 {
+		return nil
+	}
+	_, err := r.conn.Write(buf.Bytes())
+	return err
+}
+
+func (r *StatsDReporter) Close() error {
+	return r.conn.Close()
+}
@@ -0,0 +1,49 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"log/syslog"
+	"net/url"
+
+	"github.com/chenjiandongx/sniffer/pkg/stats"
+)
+
+// SyslogReporter writes the totals and the top processes of the snapshots to syslog as
+// logfmt lines.
+type SyslogReporter struct {
+	w *syslog.Writer
+}
+
+// NewSyslogReporter connects to the local syslog daemon if the address is "local", or
+// the remote one addressed like udp://host:514.
+func NewSyslogReporter(opt Options) (*SyslogReporter, error) {
+	var network, raddr string
+	if opt.SyslogAddr != "local" {
+		u, err := url.Parse(opt.SyslogAddr)
+		if err != nil {
+			return nil, err
+		}
+		network, raddr = u.Scheme, u.Host
+	}
+
+	w, err := syslog.Dial(network, raddr, syslog.LOG_INFO|syslog.LOG_DAEMON, "sniffer")
+	if err != nil {
+		return nil, err
+	}
+	return &SyslogReporter{w: w}, nil
+}
+
+func (r *SyslogReporter) Report(snapshot *stats.Snapshot) error {
+	for _, line := range formatLogLines(snapshot) {
+		if err := r.w.Info(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *SyslogReporter) Close() error {
+	return r.w.Close()
+}
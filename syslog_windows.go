@@ -0,0 +1,24 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"errors"
+
+	"github.com/chenjiandongx/sniffer/pkg/stats"
+)
+
+type SyslogReporter struct{}
+
+func NewSyslogReporter(opt Options) (*SyslogReporter, error) {
+	return nil, errors.New("syslog is not available on Windows")
+}
+
+func (r *SyslogReporter) Report(snapshot *stats.Snapshot) error {
+	return nil
+}
+
+func (r *SyslogReporter) Close() error {
+	return nil
+}
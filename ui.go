@@ -2,9 +2,13 @@ package main
 
 import (
 	"fmt"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/chenjiandongx/sniffer/pkg/stats"
 	"github.com/chenjiandongx/termui/v3"
 	"github.com/chenjiandongx/termui/v3/widgets"
 	"github.com/dustin/go-humanize"
@@ -19,6 +23,9 @@ const (
 
 type UIComponent struct {
 	viewer Viewer
+
+	// tables is the viewer which the opened detail returns to.
+	tables *TableViewer
 }
 
 type ViewMode uint8
@@ -82,8 +89,30 @@ func (u Unit) Ratio() float64 {
 	return ratio
 }
 
+const footerText = "<space> Pause. <q> Exit. <s> Switch mode. <tab> Rearrange tables. <c> Cumulative. <a> Averages. <r> Reset totals. <o> Sort. </> Search. <up>/<down> <enter> Process detail. <b> BPF filter. <p> Pin to plot"
+
 func newFooter() *widgets.Paragraph {
-	return newParagraph("<space> Pause. <q> Exit. <s> Switch mode. <tab> Rearrange tables")
+	return newParagraph(footerText)
+}
+
+// newMatcher matches the rows whose fields contain the pattern, which is a regular
+// expression or a plain substring if it does not compile. The empty pattern matches all.
+func newMatcher(pattern string) stats.Matcher {
+	if pattern == "" {
+		return nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		re = regexp.MustCompile(regexp.QuoteMeta(pattern))
+	}
+	return func(fields ...string) bool {
+		for _, field := range fields {
+			if re.MatchString(field) {
+				return true
+			}
+		}
+		return false
+	}
 }
 
 func newParagraph(text string) *widgets.Paragraph {
@@ -119,28 +148,41 @@ func newPlot(title string, num int) *widgets.Plot {
 	return plot
 }
 
-func NewUIComponent(opt Options) *UIComponent {
-	ui := &UIComponent{}
+func newViewer(opt Options) Viewer {
 	switch opt.ViewMode {
 	case ModeTableBytes, ModeTablePackets:
-		ui.viewer = &TableViewer{
+		return &TableViewer{
 			footer:      newFooter(),
 			processes:   newTable("Process Name"),
 			remoteAddrs: newTable("Remote Address"),
+			containers:  newTable("Containers"),
+			interfaces:  newTable("Interfaces"),
 			connections: newTable("Connections"),
 			mode:        opt.ViewMode,
 			unit:        opt.Unit,
+			cumulative:  opt.Cumulative,
+			averages:    opt.Averages,
+			sortKey:     opt.SortKey,
+			filter:      opt.Filter,
+			matcher:     newMatcher(opt.Filter),
 		}
 	default:
-		ui.viewer = &PlotViewer{
-			footer:      newFooter(),
-			packetsPlot: newPlot("Packets: Blue Up / Green Down", 2),
-			bytesPlot:   newPlot(fmt.Sprintf("Bytes: <Unit %sps> Blue Up / Green Down", opt.Unit.String()), 2),
-			connsPlot:   newPlot("Connections", 1),
-			unit:        opt.Unit,
+		return &PlotViewer{
+			footer:           newFooter(),
+			packetsPlot:      newPlot("", 0),
+			bytesPlot:        newPlot("", 0),
+			totalPacketsPlot: newPlot("Total Packets: Blue Up / Green Down", 2),
+			totalBytesPlot:   newPlot(fmt.Sprintf("Total Bytes: <Unit %sps> Blue Up / Green Down", opt.Unit.String()), 2),
+			connsPlot:        newPlot("Connections", 1),
+			ifacesPlot:       newPlot("", 0),
+			pins:             opt.Pins,
+			unit:             opt.Unit,
 		}
 	}
+}
 
+func NewUIComponent(opt Options) *UIComponent {
+	ui := &UIComponent{viewer: newViewer(opt)}
 	if err := termui.Init(); err != nil {
 		exit(err.Error())
 	}
@@ -148,8 +190,112 @@ func NewUIComponent(opt Options) *UIComponent {
 	return ui
 }
 
-func (ui *UIComponent) Close() {
+// SwitchViewMode replaces the viewer with the one of the view mode in the options.
+func (ui *UIComponent) SwitchViewMode(opt Options) {
+	termui.Close()
+	if err := termui.Init(); err != nil {
+		exit(err.Error())
+	}
+	ui.viewer = newViewer(opt)
+	ui.tables = nil
+	ui.viewer.Setup()
+}
+
+// Select moves the selection of the Process table by delta rows.
+func (ui *UIComponent) Select(delta int) {
+	if tv, ok := ui.viewer.(*TableViewer); ok {
+		tv.selectProcess(delta)
+	}
+}
+
+// OpenDetail drills down into the process selected in the Process table.
+func (ui *UIComponent) OpenDetail() {
+	tv, ok := ui.viewer.(*TableViewer)
+	if !ok || tv.selected == "" {
+		return
+	}
+	dv := newDetailViewer(tv.selected, tv)
+	dv.Setup()
+	ui.viewer = dv
+	ui.tables = tv
+	dv.Render(tv.last)
+}
+
+// CloseDetail returns to the tables which the detail is opened from.
+func (ui *UIComponent) CloseDetail() {
+	dv, ok := ui.viewer.(*DetailViewer)
+	if !ok {
+		return
+	}
+	tv := ui.tables
+	ui.viewer = tv
+	ui.tables = nil
+
+	// The rows are filled before drawing since the columns may be changed in the detail.
+	width, height := termui.TerminalDimensions()
+	tv.grid = tv.newGrid(width, height)
+	tv.Render(dv.last)
+}
+
+// SetQuery applies the sort key and the filter in the options to the tables.
+func (ui *UIComponent) SetQuery(opt Options) {
+	switch v := ui.viewer.(type) {
+	case *TableViewer:
+		v.setQuery(opt.SortKey, opt.Filter)
+	case *DetailViewer:
+		v.tables.applyQuery(opt.SortKey, opt.Filter)
+		v.refresh()
+	}
+}
+
+// SetColumns shows or hides the cumulative and the averaged columns in the options in
+// place, the selection, the opened detail and the plots are kept.
+func (ui *UIComponent) SetColumns(opt Options) {
+	switch v := ui.viewer.(type) {
+	case *TableViewer:
+		v.setColumns(opt.Cumulative, opt.Averages)
+	case *DetailViewer:
+		v.tables.applyColumns(opt.Cumulative, opt.Averages)
+		width, height := termui.TerminalDimensions()
+		v.grid = v.newGrid(width, height)
+		v.refresh()
+	}
+}
+
+// SetPins pins the processes and the remote addresses to the plots.
+func (ui *UIComponent) SetPins(pins []string) {
+	if pv, ok := ui.viewer.(*PlotViewer); ok {
+		pv.setPins(pins)
+	}
+}
+
+// SelectedProcess returns the process selected in the Process table or the one of the
+// opened detail.
+func (ui *UIComponent) SelectedProcess() string {
+	switch v := ui.viewer.(type) {
+	case *TableViewer:
+		return v.selected
+	case *DetailViewer:
+		return v.process
+	}
+	return ""
+}
+
+// SetFooter replaces the footer of the viewer with the text, such as the input line opened
+// by a hotkey, the empty text restores the list of the hotkeys.
+func (ui *UIComponent) SetFooter(text string) {
+	ui.viewer.SetFooter(text)
+}
+
+// Report renders the snapshot with the current viewer.
+func (ui *UIComponent) Report(snapshot *stats.Snapshot) error {
+	ui.viewer.Render(snapshot)
+	return nil
+}
+
+func (ui *UIComponent) Close() error {
 	termui.Close()
+	return nil
 }
 
 type queue struct {
@@ -173,6 +319,15 @@ func (q *queue) Get(ratio float64) []float64 {
 	return nums
 }
 
+// Sum adds up the values in the queue.
+func (q *queue) Sum() float64 {
+	var sum float64
+	for i := 0; i < q.deque.Len(); i++ {
+		sum += q.deque.At(i).(float64)
+	}
+	return sum
+}
+
 func (q *queue) Resize(size int) {
 	for q.deque.Len() >= size {
 		q.deque.PopFront()
@@ -184,43 +339,79 @@ type Viewer interface {
 	Setup()
 	Shift()
 	Resize(width, height int)
-	Render(stats interface{})
+	Render(snapshot *stats.Snapshot)
+	SetFooter(text string)
+}
+
+// plotTopN is the number of the processes plotted besides the pinned ones.
+const plotTopN = 5
+
+// series is the history of the traffic of a process or a remote address in the plots, the
+// color is kept since it is shown the first time.
+type series struct {
+	name    string
+	bytes   *queue
+	packets *queue
+	color   termui.Color
+	colored bool
 }
 
 type PlotViewer struct {
 	header *widgets.Paragraph
 	footer *widgets.Paragraph
 
-	packetsPlot     *widgets.Plot
-	packetsUpList   *queue
-	packetsDownList *queue
-	bytesPlot       *widgets.Plot
-	bytesUpList     *queue
-	bytesDownList   *queue
-	connsPlot       *widgets.Plot
-	connsList       *queue
-	plotRef         []*widgets.Plot
+	packetsPlot *widgets.Plot
+	bytesPlot   *widgets.Plot
+	connsPlot   *widgets.Plot
+	connsList   *queue
+	ifacesPlot  *widgets.Plot
+	ifaceLists  map[string]*queue
+	plotRef     []*widgets.Plot
+
+	// totalPacketsPlot and totalBytesPlot draw the upload and the download of all the
+	// traffic besides the lines of the processes.
+	totalPacketsPlot *widgets.Plot
+	totalBytesPlot   *widgets.Plot
+	packetsUpList    *queue
+	packetsDownList  *queue
+	bytesUpList      *queue
+	bytesDownList    *queue
 
-	dataRef  [][]*queue
-	grid     *termui.Grid
-	shiftIdx int
-	count    int
-	unit     Unit
+	// processes are the series of the processes with the traffic in the window, addrs are
+	// the ones of the pinned remote addresses.
+	processes map[string]*series
+	addrs     map[string]*series
+	pins      []string
+
+	// resolvedPins are the remote addresses pinned by the IPs of their connections, since
+	// the addresses are keyed by the resolved names.
+	resolvedPins map[string]bool
+
+	grid      *termui.Grid
+	queueSize int
+	shiftIdx  int
+	count     int
+	unit      Unit
 }
 
 func (pv *PlotViewer) Setup() {
 	pv.header = newParagraph(pv.getHeaderText())
-	pv.plotRef = []*widgets.Plot{pv.bytesPlot, pv.packetsPlot, pv.connsPlot}
+	pv.plotRef = []*widgets.Plot{
+		pv.bytesPlot, pv.packetsPlot, pv.totalBytesPlot, pv.totalPacketsPlot, pv.connsPlot, pv.ifacesPlot,
+	}
 	width, height := termui.TerminalDimensions()
 
-	pv.bytesUpList = pv.newQueue(width/2 - padding)
-	pv.bytesDownList = pv.newQueue(width/2 - padding)
-	pv.packetsUpList = pv.newQueue(width/2 - padding)
-	pv.packetsDownList = pv.newQueue(width/2 - padding)
-	pv.connsList = pv.newQueue(width/2 - padding)
+	pv.queueSize = width/2 - padding
+	pv.connsList = pv.newQueue(pv.queueSize)
+	pv.bytesUpList = pv.newQueue(pv.queueSize)
+	pv.bytesDownList = pv.newQueue(pv.queueSize)
+	pv.packetsUpList = pv.newQueue(pv.queueSize)
+	pv.packetsDownList = pv.newQueue(pv.queueSize)
+	pv.ifaceLists = make(map[string]*queue)
+	pv.processes = make(map[string]*series)
+	pv.addrs = make(map[string]*series)
 	pv.shiftIdx = -1
 
-	pv.dataRef = [][]*queue{{pv.bytesUpList, pv.bytesDownList}, {pv.packetsUpList, pv.packetsDownList}, {pv.connsList}}
 	pv.grid = pv.newGrid(width, height)
 }
 
@@ -228,51 +419,273 @@ func (pv *PlotViewer) newQueue(size int) *queue {
 	return &queue{size: size, deque: deque.New()}
 }
 
+// newAlignedQueue creates the queue of the line seen later, it is aligned with the others
+// in time by the zeros of the intervals before.
+func (pv *PlotViewer) newAlignedQueue() *queue {
+	lst := pv.newQueue(pv.queueSize)
+	for i := 1; i < pv.count && i < pv.queueSize; i++ {
+		lst.Put(0)
+	}
+	return lst
+}
+
 func (pv *PlotViewer) getHeaderText() string {
-	return fmt.Sprintf("[Plot Mode] Now: %s", time.Now().Format(timeFormat))
+	text := fmt.Sprintf("[Plot Mode] Now: %s", time.Now().Format(timeFormat))
+	if len(pv.pins) > 0 {
+		text += fmt.Sprintf("  [Pinned] %s", strings.Join(pv.pins, ", "))
+	}
+	return text
+}
+
+// pinned reports whether the process or the remote address is pinned, the process is
+// pinned by its name without the pid as well, and the remote address by the IP.
+func (pv *PlotViewer) pinned(name string, process bool) bool {
+	if !process && pv.resolvedPins[name] {
+		return true
+	}
+	for _, pin := range pv.pins {
+		if name == pin || process && strings.HasSuffix(name, ">:"+pin) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolvePins learns the remote addresses whose connections are to the pinned IPs, they
+// are kept pinned until the pins change.
+func (pv *PlotViewer) resolvePins(snapshot *stats.Snapshot) {
+	if pv.resolvedPins == nil {
+		pv.resolvedPins = make(map[string]bool)
+	}
+	for conn, data := range snapshot.Connections {
+		for _, pin := range pv.pins {
+			if conn.Remote.IP == pin {
+				pv.resolvedPins[data.RemoteName] = true
+			}
+		}
+	}
+}
+
+// updateSeries puts the traffic of the keys into their series. The series of all the
+// processes are tracked so that the top ones are plotted with their history, while the
+// remote addresses are tracked once pinned. The series idle in the whole window are dropped
+// unless pinned.
+func (pv *PlotViewer) updateSeries(set map[string]*series, data map[string]*stats.NetworkData, process bool) {
+	for name := range data {
+		if _, ok := set[name]; !ok && (process || pv.pinned(name, false)) {
+			set[name] = &series{name: name, bytes: pv.newAlignedQueue(), packets: pv.newAlignedQueue()}
+		}
+	}
+
+	for name, sr := range set {
+		var bytes, packets float64
+		if v, ok := data[name]; ok {
+			bytes = float64(v.UploadBytes + v.DownloadBytes)
+			packets = float64(v.UploadPackets + v.DownloadPackets)
+		}
+		sr.bytes.Put(bytes)
+		sr.packets.Put(packets)
+
+		pinned := pv.pinned(name, process)
+		idle := sr.bytes.Sum() == 0 && sr.packets.Sum() == 0
+		if !pinned && (!process || idle) {
+			delete(set, name)
+		}
+	}
+}
+
+// shownSeries returns the pinned series followed by the top processes by the bytes in the
+// window, up to the number of the colors of the lines. The colors are assigned to the
+// series shown the first time and kept since, unless taken by another one shown.
+func (pv *PlotViewer) shownSeries() []*series {
+	var pinned, rest []*series
+	for name, sr := range pv.processes {
+		if pv.pinned(name, true) {
+			pinned = append(pinned, sr)
+			continue
+		}
+		rest = append(rest, sr)
+	}
+	for _, sr := range pv.addrs {
+		pinned = append(pinned, sr)
+	}
+
+	sort.Slice(pinned, func(i, j int) bool { return pinned[i].name < pinned[j].name })
+	sort.Slice(rest, func(i, j int) bool {
+		a, b := rest[i].bytes.Sum(), rest[j].bytes.Sum()
+		if a != b {
+			return a > b
+		}
+		return rest[i].name < rest[j].name
+	})
+	if len(rest) > plotTopN {
+		rest = rest[:plotTopN]
+	}
+	shown := append(pinned, rest...)
+	if len(shown) > len(lineColors) {
+		shown = shown[:len(lineColors)]
+	}
+
+	used := make(map[termui.Color]bool)
+	var uncolored []*series
+	for _, sr := range shown {
+		if sr.colored && !used[sr.color] {
+			used[sr.color] = true
+			continue
+		}
+		uncolored = append(uncolored, sr)
+	}
+	for _, sr := range uncolored {
+		sr.color = freeColor(used)
+		sr.colored = true
+		used[sr.color] = true
+	}
+	return shown
 }
 
-func (pv *PlotViewer) updatePackets(data *NetworkData) {
-	pv.packetsUpList.Put(float64(data.UploadPackets))
-	pv.packetsDownList.Put(float64(data.DownloadPackets))
-	pv.packetsPlot.Data[0] = pv.packetsUpList.Get(1)
-	pv.packetsPlot.Data[1] = pv.packetsDownList.Get(1)
+// freeColor returns the first color of the lines which is not used, there is always one
+// since the series shown are no more than the colors.
+func freeColor(used map[termui.Color]bool) termui.Color {
+	for _, color := range lineColors {
+		if !used[color] {
+			return color
+		}
+	}
+	return lineColors[0]
 }
 
-func (pv *PlotViewer) updateBytes(data *NetworkData) {
-	pv.bytesUpList.Put(float64(data.UploadBytes))
-	pv.bytesDownList.Put(float64(data.DownloadBytes))
-	pv.bytesPlot.Data[0] = pv.bytesUpList.Get(pv.unit.Ratio())
-	pv.bytesPlot.Data[1] = pv.bytesDownList.Get(pv.unit.Ratio())
+// legendTitle appends the colors and the names of the lines to the title.
+func legendTitle(title string, legend []string) string {
+	if len(legend) == 0 {
+		return title
+	}
+	return title + ": " + strings.Join(legend, " / ")
 }
 
-func (pv *PlotViewer) updateConnections(data *NetworkData) {
+// updateProcesses draws a line of the upload plus the download of every process shown in
+// the bytes and the packets plots respectively.
+func (pv *PlotViewer) updateProcesses(snapshot *stats.Snapshot) {
+	pv.resolvePins(snapshot)
+	pv.updateSeries(pv.processes, snapshot.Processes, true)
+	pv.updateSeries(pv.addrs, snapshot.RemoteAddrs, false)
+
+	shown := pv.shownSeries()
+	legend := make([]string, 0, len(shown))
+	pv.bytesPlot.Data = make([][]float64, len(shown))
+	pv.packetsPlot.Data = make([][]float64, len(shown))
+	colors := make([]termui.Color, len(shown))
+	for i, sr := range shown {
+		pv.bytesPlot.Data[i] = sr.bytes.Get(pv.unit.Ratio())
+		pv.packetsPlot.Data[i] = sr.packets.Get(1)
+		colors[i] = sr.color
+		legend = append(legend, fmt.Sprintf("%s %s", colorNames[sr.color], sr.name))
+	}
+	pv.bytesPlot.LineColors = colors
+	pv.packetsPlot.LineColors = colors
+	pv.bytesPlot.Title = legendTitle(fmt.Sprintf("Bytes: <Unit %sps> Up + Down", pv.unit.String()), legend)
+	pv.packetsPlot.Title = legendTitle("Packets: Up + Down", legend)
+}
+
+// updateTotals draws the upload and the download of all the traffic in the total plots.
+func (pv *PlotViewer) updateTotals(snapshot *stats.Snapshot) {
+	pv.bytesUpList.Put(float64(snapshot.TotalUploadBytes))
+	pv.bytesDownList.Put(float64(snapshot.TotalDownloadBytes))
+	pv.packetsUpList.Put(float64(snapshot.TotalUploadPackets))
+	pv.packetsDownList.Put(float64(snapshot.TotalDownloadPackets))
+	pv.totalBytesPlot.Data[0] = pv.bytesUpList.Get(pv.unit.Ratio())
+	pv.totalBytesPlot.Data[1] = pv.bytesDownList.Get(pv.unit.Ratio())
+	pv.totalPacketsPlot.Data[0] = pv.packetsUpList.Get(1)
+	pv.totalPacketsPlot.Data[1] = pv.packetsDownList.Get(1)
+}
+
+func (pv *PlotViewer) updateConnections(data *stats.NetworkData) {
 	pv.connsList.Put(float64(data.ConnCount))
 	pv.connsPlot.Data[0] = pv.connsList.Get(1)
 }
 
+// lineColors are the colors of the lines of the plots with more than one line.
+var lineColors = []termui.Color{
+	termui.ColorBlue, termui.ColorGreen, termui.ColorYellow, termui.ColorMagenta,
+	termui.ColorCyan, termui.ColorRed, termui.ColorWhite,
+}
+
+var colorNames = map[termui.Color]string{
+	termui.ColorBlue:    "Blue",
+	termui.ColorGreen:   "Green",
+	termui.ColorYellow:  "Yellow",
+	termui.ColorMagenta: "Magenta",
+	termui.ColorCyan:    "Cyan",
+	termui.ColorRed:     "Red",
+	termui.ColorWhite:   "White",
+}
+
+// updateInterfaces draws a line of the upload plus the download of every interface seen,
+// the interfaces keep their colors by the order of the names.
+func (pv *PlotViewer) updateInterfaces(interfaces map[string]*stats.NetworkData) {
+	for name := range interfaces {
+		if _, ok := pv.ifaceLists[name]; !ok {
+			pv.ifaceLists[name] = pv.newAlignedQueue()
+		}
+	}
+
+	names := make([]string, 0, len(pv.ifaceLists))
+	for name := range pv.ifaceLists {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	legend := make([]string, 0, len(names))
+	pv.ifacesPlot.Data = make([][]float64, len(names))
+	pv.ifacesPlot.LineColors = make([]termui.Color, len(names))
+	for i, name := range names {
+		var v float64
+		if data, ok := interfaces[name]; ok {
+			v = float64(data.UploadBytes + data.DownloadBytes)
+		}
+		pv.ifaceLists[name].Put(v)
+		pv.ifacesPlot.Data[i] = pv.ifaceLists[name].Get(pv.unit.Ratio())
+
+		color := lineColors[i%len(lineColors)]
+		pv.ifacesPlot.LineColors[i] = color
+		legend = append(legend, fmt.Sprintf("%s %s", colorNames[color], name))
+	}
+	pv.ifacesPlot.Title = legendTitle(fmt.Sprintf("Interfaces: <Unit %sps> Up + Down", pv.unit.String()), legend)
+}
+
 func (pv *PlotViewer) newGrid(width, height int) *termui.Grid {
 	grid := termui.NewGrid()
 	grid.SetRect(0, 0, width, height)
 
-	num := len(pv.plotRef)
-	for _, lst := range pv.dataRef[(pv.shiftIdx+1)%num] {
-		lst.Resize(width/2 - padding)
+	// All the plots are half of the width.
+	pv.queueSize = width/2 - padding
+	for _, lst := range []*queue{pv.connsList, pv.bytesUpList, pv.bytesDownList, pv.packetsUpList, pv.packetsDownList} {
+		lst.Resize(pv.queueSize)
 	}
-	for _, lst := range pv.dataRef[(pv.shiftIdx+2)%num] {
-		lst.Resize(width/2 - padding)
+	for _, lst := range pv.ifaceLists {
+		lst.Resize(pv.queueSize)
 	}
-	for _, lst := range pv.dataRef[(pv.shiftIdx+3)%num] {
-		lst.Resize(width - padding)
+	for _, set := range []map[string]*series{pv.processes, pv.addrs} {
+		for _, sr := range set {
+			sr.bytes.Resize(pv.queueSize)
+			sr.packets.Resize(pv.queueSize)
+		}
 	}
 
+	num := len(pv.plotRef)
 	grid.Set(
 		termui.NewRow(0.03, termui.NewCol(1.0, pv.header)),
-		termui.NewRow(0.47,
+		termui.NewRow(0.94/3,
 			termui.NewCol(1.0/2, pv.plotRef[(pv.shiftIdx+1)%num]),
 			termui.NewCol(1.0/2, pv.plotRef[(pv.shiftIdx+2)%num]),
 		),
-		termui.NewRow(0.47, termui.NewCol(1.0, pv.plotRef[(pv.shiftIdx+3)%num])),
+		termui.NewRow(0.94/3,
+			termui.NewCol(1.0/2, pv.plotRef[(pv.shiftIdx+3)%num]),
+			termui.NewCol(1.0/2, pv.plotRef[(pv.shiftIdx+4)%num]),
+		),
+		termui.NewRow(0.94/3,
+			termui.NewCol(1.0/2, pv.plotRef[(pv.shiftIdx+5)%num]),
+			termui.NewCol(1.0/2, pv.plotRef[(pv.shiftIdx+6)%num]),
+		),
 		termui.NewRow(0.03, termui.NewCol(1.0, pv.footer)),
 	)
 	return grid
@@ -290,18 +703,34 @@ func (pv *PlotViewer) Resize(width, height int) {
 	pv.render()
 }
 
-func (pv *PlotViewer) Render(stats interface{}) {
-	if stats == nil {
+func (pv *PlotViewer) Render(snapshot *stats.Snapshot) {
+	if snapshot == nil {
 		return
 	}
 
 	pv.header.Text = pv.getHeaderText()
 	pv.count++
-	data := stats.(*NetworkData)
 
-	pv.updatePackets(data)
-	pv.updateBytes(data)
-	pv.updateConnections(data)
+	pv.updateProcesses(snapshot)
+	pv.updateTotals(snapshot)
+	pv.updateConnections(snapshot.ProcessesTotal)
+	pv.updateInterfaces(snapshot.Interfaces)
+	pv.render()
+}
+
+// setPins plots the pinned processes and remote addresses from the next snapshot on.
+func (pv *PlotViewer) setPins(pins []string) {
+	pv.pins = pins
+	pv.resolvedPins = nil
+	pv.header.Text = pv.getHeaderText()
+	pv.render()
+}
+
+func (pv *PlotViewer) SetFooter(text string) {
+	if text == "" {
+		text = footerText
+	}
+	pv.footer.Text = text
 	pv.render()
 }
 
@@ -317,23 +746,55 @@ type TableViewer struct {
 	footer      *widgets.Paragraph
 	processes   *widgets.Table
 	remoteAddrs *widgets.Table
+	containers  *widgets.Table
+	interfaces  *widgets.Table
 	connections *widgets.Table
 	tableRef    []*widgets.Table
 	grid        *termui.Grid
 	shiftIdx    int
 	mode        ViewMode
 	unit        Unit
+	cumulative  bool
+	averages    bool
+	sortKey     stats.SortKey
+	filter      string
+	matcher     stats.Matcher
+	last        *stats.Snapshot
+
+	// processNames are the processes in the rows of the Process table, the selected one
+	// is drilled down into.
+	processNames []string
+	selected     string
+}
+
+// traffic is the upload and the download of a row in the tables.
+type traffic struct {
+	upBytes, downBytes, upPackets, downPackets int
+}
+
+func networkTraffic(data *stats.NetworkData) traffic {
+	if data == nil {
+		return traffic{}
+	}
+	return traffic{data.UploadBytes, data.DownloadBytes, data.UploadPackets, data.DownloadPackets}
+}
+
+func connectionTraffic(data *stats.ConnectionData) traffic {
+	if data == nil {
+		return traffic{}
+	}
+	return traffic{data.UploadBytes, data.DownloadBytes, data.UploadPackets, data.DownloadPackets}
 }
 
 func (tv *TableViewer) Setup() {
-	tv.header = newParagraph(tv.getHeaderText(0, "", ""))
-	tv.tableRef = []*widgets.Table{tv.processes, tv.remoteAddrs, tv.connections}
+	tv.header = newParagraph(tv.getHeaderText(time.Now(), 0, "", ""))
+	tv.tableRef = []*widgets.Table{tv.processes, tv.remoteAddrs, tv.containers, tv.interfaces, tv.connections}
 	width, height := termui.TerminalDimensions()
 	tv.grid = tv.newGrid(width, height)
 }
 
-func (tv *TableViewer) getHeaderText(conn int, up, down string) string {
-	now := time.Now().Format(timeFormat)
+func (tv *TableViewer) getHeaderText(t time.Time, conn int, up, down string) string {
+	now := t.Format(timeFormat)
 	var text string
 	switch tv.mode {
 	case ModeTableBytes:
@@ -344,6 +805,89 @@ func (tv *TableViewer) getHeaderText(conn int, up, down string) string {
 	return text
 }
 
+// setQuery re-ranks the rows of the last snapshot by the sort key and the filter.
+func (tv *TableViewer) setQuery(sortKey stats.SortKey, filter string) {
+	tv.applyQuery(sortKey, filter)
+	if tv.last != nil {
+		tv.Render(tv.last)
+		return
+	}
+	termui.Render(tv.grid)
+}
+
+// applyQuery sets the sort key and the filter of the rows.
+func (tv *TableViewer) applyQuery(sortKey stats.SortKey, filter string) {
+	tv.sortKey = sortKey
+	tv.filter = filter
+	tv.matcher = newMatcher(filter)
+}
+
+// setColumns shows or hides the cumulative and the averaged columns, the rows of the last
+// snapshot are rendered again.
+func (tv *TableViewer) setColumns(cumulative, averages bool) {
+	tv.applyColumns(cumulative, averages)
+	width, height := termui.TerminalDimensions()
+	tv.grid = tv.newGrid(width, height)
+	if tv.last != nil {
+		tv.Render(tv.last)
+		return
+	}
+	termui.Render(tv.grid)
+}
+
+// applyColumns sets the columns shown, the column widths are recomputed by newGrid.
+func (tv *TableViewer) applyColumns(cumulative, averages bool) {
+	tv.cumulative = cumulative
+	tv.averages = averages
+}
+
+// selectProcess moves the selection of the Process table by delta rows, the selection
+// follows the process rather than the row when the rows are re-ranked.
+func (tv *TableViewer) selectProcess(delta int) {
+	if len(tv.processNames) == 0 {
+		return
+	}
+	idx := -1
+	for i, name := range tv.processNames {
+		if name == tv.selected {
+			idx = i
+		}
+	}
+	if idx < 0 && delta < 0 {
+		idx = len(tv.processNames)
+	}
+	idx += delta
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= len(tv.processNames) {
+		idx = len(tv.processNames) - 1
+	}
+
+	tv.selected = tv.processNames[idx]
+	tv.highlight()
+	termui.Render(tv.grid)
+}
+
+// highlight marks the row of the selected process in the Process table.
+func (tv *TableViewer) highlight() {
+	tv.processes.RowStyles = map[int]termui.Style{0: termui.NewStyle(termui.ColorCyan)}
+	for i, name := range tv.processNames {
+		if name == tv.selected {
+			// The rows of the processes follow the header and the blank row.
+			tv.processes.RowStyles[i+2] = termui.NewStyle(termui.ColorBlack, termui.ColorCyan)
+		}
+	}
+}
+
+// order maps the view mode to the ranking of the tables.
+func (tv *TableViewer) order() stats.Order {
+	if tv.mode == ModeTablePackets {
+		return stats.OrderPackets
+	}
+	return stats.OrderBytes
+}
+
 func (tv *TableViewer) humanizeNum(n int) string {
 	var s string
 	switch tv.mode {
@@ -352,88 +896,182 @@ func (tv *TableViewer) humanizeNum(n int) string {
 	case ModeTablePackets:
 		s = humanize.Comma(int64(n))
 	}
-	return s + "ps"
+	return s
 }
 
-func (tv *TableViewer) updateHeader(snapshot *Snapshot) {
-	var up, down string
-	switch tv.mode {
-	case ModeTableBytes:
-		up = tv.humanizeNum(snapshot.TotalUploadBytes)
-		down = tv.humanizeNum(snapshot.TotalDownloadBytes)
-	case ModeTablePackets:
-		up = tv.humanizeNum(snapshot.TotalUploadPackets)
-		down = tv.humanizeNum(snapshot.TotalDownloadPackets)
+func (tv *TableViewer) humanizeRate(n int) string {
+	return tv.humanizeNum(n) + "ps"
+}
+
+// upDown picks the upload and the download of the traffic by the view mode.
+func (tv *TableViewer) upDown(t traffic) (int, int) {
+	if tv.mode == ModeTablePackets {
+		return t.upPackets, t.downPackets
 	}
-	tv.header.Text = tv.getHeaderText(snapshot.TotalConnections, up, down)
+	return t.upBytes, t.downBytes
 }
 
-func (tv *TableViewer) updateProcesses(snapshot *Snapshot) {
-	rows := make([][]string, 0)
-	for _, r := range snapshot.TopNProcesses(maxRows, tv.mode) {
-		var up, down string
-		switch tv.mode {
-		case ModeTableBytes:
-			up = tv.humanizeNum(r.Data.UploadBytes)
-			down = tv.humanizeNum(r.Data.DownloadBytes)
-		case ModeTablePackets:
-			up = tv.humanizeNum(r.Data.UploadPackets)
-			down = tv.humanizeNum(r.Data.DownloadPackets)
+func (tv *TableViewer) formatRate(t traffic) string {
+	up, down := tv.upDown(t)
+	return tv.humanizeRate(up) + " / " + tv.humanizeRate(down)
+}
+
+func (tv *TableViewer) formatTotal(t traffic) string {
+	up, down := tv.upDown(t)
+	return tv.humanizeNum(up) + " / " + tv.humanizeNum(down)
+}
+
+func (tv *TableViewer) updateHeader(snapshot *stats.Snapshot) {
+	up, down := tv.upDown(traffic{
+		snapshot.TotalUploadBytes, snapshot.TotalDownloadBytes,
+		snapshot.TotalUploadPackets, snapshot.TotalDownloadPackets,
+	})
+	tv.header.Text = tv.getHeaderText(snapshot.Time, snapshot.TotalConnections, tv.humanizeRate(up), tv.humanizeRate(down))
+
+	if tv.cumulative && snapshot.Cumulative != nil {
+		c := snapshot.Cumulative
+		up, down = tv.upDown(traffic{c.TotalUploadBytes, c.TotalDownloadBytes, c.TotalUploadPackets, c.TotalDownloadPackets})
+		tv.header.Text += fmt.Sprintf("  [Since %s] Conn:%d Up:%s Down:%s",
+			c.Time.Format(timeFormat), c.TotalConnections, tv.humanizeNum(up), tv.humanizeNum(down))
+	}
+
+	tv.header.Text += fmt.Sprintf("  [Sort] %s", tv.sortKey)
+	if tv.filter != "" {
+		tv.header.Text += fmt.Sprintf("  [Filter] %s", tv.filter)
+	}
+}
+
+// setRows fills the table, the headers of the total and the averaged columns are appended
+// if they are shown.
+func (tv *TableViewer) setRows(table *widgets.Table, header []string, rows [][]string) {
+	if tv.cumulative {
+		header = append(header, "Total Up / Down")
+	}
+	if tv.averages {
+		for _, window := range stats.AverageWindows {
+			header = append(header, fmt.Sprintf("%ds Avg", window/time.Second))
 		}
-		rows = append(rows, []string{r.ProcessName, strconv.Itoa(r.Data.ConnCount), up + " / " + down})
+		header = append(header, "Peak")
 	}
+	table.Rows = [][]string{header, make([]string, len(header))}
+	table.Rows = append(table.Rows, rows...)
+}
 
-	header := []string{"<Pid>:Process", "Connections", "Up / Down"}
-	tv.processes.Rows = [][]string{header, make([]string, 3)}
-	tv.processes.Rows = append(tv.processes.Rows, rows...)
+// source returns the snapshot which the rows are ranked in, the cumulative one lists the
+// keys without the traffic in the latest interval as well.
+func (tv *TableViewer) source(snapshot *stats.Snapshot) *stats.Snapshot {
+	if tv.cumulative && snapshot.Cumulative != nil {
+		return snapshot.Cumulative
+	}
+	return snapshot
 }
 
-func (tv *TableViewer) updateRemoteAddrs(snapshot *Snapshot) {
-	rows := make([][]string, 0)
-	for _, r := range snapshot.TopNRemoteAddrs(maxRows, tv.mode) {
-		var up, down string
-		switch tv.mode {
-		case ModeTableBytes:
-			up = tv.humanizeNum(r.Data.UploadBytes)
-			down = tv.humanizeNum(r.Data.DownloadBytes)
-		case ModeTablePackets:
-			up = tv.humanizeNum(r.Data.UploadPackets)
-			down = tv.humanizeNum(r.Data.DownloadPackets)
+// row formats the row of the key whose traffic is picked from the snapshots by get, the
+// rate is followed by the total and the averaged columns if they are shown.
+func (tv *TableViewer) row(snapshot *stats.Snapshot, get func(*stats.Snapshot) traffic, cells ...string) []string {
+	pick := func(s *stats.Snapshot) traffic {
+		if s == nil {
+			return traffic{}
 		}
-		rows = append(rows, []string{r.Addr, strconv.Itoa(r.Data.ConnCount), up + " / " + down})
+		return get(s)
 	}
 
-	header := []string{"Remote Address", "Connections", "Up / Down"}
-	tv.remoteAddrs.Rows = [][]string{header, make([]string, 3)}
-	tv.remoteAddrs.Rows = append(tv.remoteAddrs.Rows, rows...)
+	row := append(cells, tv.formatRate(pick(snapshot)))
+	if tv.cumulative {
+		row = append(row, tv.formatTotal(pick(snapshot.Cumulative)))
+	}
+	if tv.averages {
+		for i := range stats.AverageWindows {
+			var average *stats.Snapshot
+			if i < len(snapshot.Averages) {
+				average = snapshot.Averages[i]
+			}
+			row = append(row, tv.formatRate(pick(average)))
+		}
+		row = append(row, tv.formatRate(pick(snapshot.Peaks)))
+	}
+	return row
 }
 
-func (tv *TableViewer) updateConnections(snapshot *Snapshot) {
+func (tv *TableViewer) updateProcesses(snapshot *stats.Snapshot) {
 	rows := make([][]string, 0)
-	for _, r := range snapshot.TopNConnections(maxRows, tv.mode) {
-		var up, down string
-		switch tv.mode {
-		case ModeTableBytes:
-			up = tv.humanizeNum(r.Data.UploadBytes)
-			down = tv.humanizeNum(r.Data.DownloadBytes)
-		case ModeTablePackets:
-			up = tv.humanizeNum(r.Data.UploadPackets)
-			down = tv.humanizeNum(r.Data.DownloadPackets)
-		}
+	tv.processNames = tv.processNames[:0]
+	for _, r := range tv.source(snapshot).TopNProcessesBy(maxRows, tv.order(), tv.sortKey, tv.matcher) {
+		get := func(s *stats.Snapshot) traffic { return networkTraffic(s.Processes[r.ProcessName]) }
+		rows = append(rows, tv.row(snapshot, get, r.ProcessName, strconv.Itoa(r.Data.ConnCount)))
+		tv.processNames = append(tv.processNames, r.ProcessName)
+	}
+	tv.setRows(tv.processes, []string{"<Pid>:Process", "Connections", "Up / Down"}, rows)
+	tv.highlight()
+}
+
+func (tv *TableViewer) updateRemoteAddrs(snapshot *stats.Snapshot) {
+	rows := make([][]string, 0)
+	for _, r := range tv.source(snapshot).TopNRemoteAddrsBy(maxRows, tv.order(), tv.sortKey, tv.matcher) {
+		get := func(s *stats.Snapshot) traffic { return networkTraffic(s.RemoteAddrs[r.Addr]) }
+		rows = append(rows, tv.row(snapshot, get, r.Addr, strconv.Itoa(r.Data.ConnCount)))
+	}
+	tv.setRows(tv.remoteAddrs, []string{"Remote Address", "Connections", "Up / Down"}, rows)
+}
+
+func (tv *TableViewer) updateContainers(snapshot *stats.Snapshot) {
+	rows := make([][]string, 0)
+	for _, r := range tv.source(snapshot).TopNContainersBy(maxRows, tv.order(), tv.sortKey, tv.matcher) {
+		get := func(s *stats.Snapshot) traffic { return networkTraffic(s.Containers[r.Container]) }
+		rows = append(rows, tv.row(snapshot, get, r.Container, strconv.Itoa(r.Data.ConnCount)))
+	}
+	tv.setRows(tv.containers, []string{"Container", "Connections", "Up / Down"}, rows)
+}
+
+func (tv *TableViewer) updateInterfaces(snapshot *stats.Snapshot) {
+	rows := make([][]string, 0)
+	for _, r := range tv.source(snapshot).TopNInterfacesBy(maxRows, tv.order(), tv.sortKey, tv.matcher) {
+		get := func(s *stats.Snapshot) traffic { return networkTraffic(s.Interfaces[r.Interface]) }
+		rows = append(rows, tv.row(snapshot, get, r.Interface, strconv.Itoa(r.Data.ConnCount)))
+	}
+	tv.setRows(tv.interfaces, []string{"Interface", "Connections", "Up / Down"}, rows)
+}
 
+func (tv *TableViewer) updateConnections(snapshot *stats.Snapshot) {
+	rows := make([][]string, 0)
+	for _, r := range tv.source(snapshot).TopNConnectionsBy(maxRows, tv.order(), tv.sortKey, tv.matcher) {
 		conn := fmt.Sprintf("<%s>:%d => %s:%d (%s)",
 			r.Data.InterfaceName,
 			r.Conn.Local.Port,
-			r.Conn.Remote.IP,
+			r.Data.RemoteName,
 			r.Conn.Remote.Port,
 			r.Conn.Local.Protocol,
 		)
-		rows = append(rows, []string{conn, r.Data.ProcessName, up + " / " + down})
+		get := func(s *stats.Snapshot) traffic { return connectionTraffic(s.Connections[r.Conn]) }
+		rows = append(rows, tv.row(snapshot, get, conn, r.Data.ProcessName))
 	}
+	tv.setRows(tv.connections, []string{"Connections", "<Pid>:Process", "Up / Down"}, rows)
+}
 
-	header := []string{"Connections", "<Pid>:Process", "Up / Down"}
-	tv.connections.Rows = [][]string{header, make([]string, 3)}
-	tv.connections.Rows = append(tv.connections.Rows, rows...)
+// columnWidths splits the width of the table by the weights of the columns, the extra
+// columns shown share the weight of the traffic one.
+func (tv *TableViewer) columnWidths(width int, weights ...int) []int {
+	extra := 0
+	if tv.cumulative {
+		extra++
+	}
+	if tv.averages {
+		extra += len(stats.AverageWindows) + 1
+	}
+	for i := 0; i < extra; i++ {
+		weights = append(weights, weights[len(weights)-1])
+	}
+
+	var sum int
+	for _, weight := range weights {
+		sum += weight
+	}
+	widths := make([]int, len(weights))
+	for i, weight := range weights {
+		widths[i] = width * weight / sum
+	}
+	widths[len(widths)-1]--
+	return widths
 }
 
 func (tv *TableViewer) newGrid(width, height int) *termui.Grid {
@@ -442,17 +1080,23 @@ func (tv *TableViewer) newGrid(width, height int) *termui.Grid {
 
 	num := len(tv.tableRef)
 	w := (width) / 12
-	tv.tableRef[(tv.shiftIdx+1)%num].ColumnWidths = []int{w * 2, w * 2, (w * 2) - 1}
-	tv.tableRef[(tv.shiftIdx+2)%num].ColumnWidths = []int{w * 2, w * 2, (w * 2) - 1}
-	tv.tableRef[(tv.shiftIdx+3)%num].ColumnWidths = []int{w * 6, w * 3, (w * 3) - 1}
+	tv.tableRef[(tv.shiftIdx+1)%num].ColumnWidths = tv.columnWidths(w*4, 2, 1, 1)
+	tv.tableRef[(tv.shiftIdx+2)%num].ColumnWidths = tv.columnWidths(w*4, 2, 1, 1)
+	tv.tableRef[(tv.shiftIdx+3)%num].ColumnWidths = tv.columnWidths(w*4, 2, 1, 1)
+	tv.tableRef[(tv.shiftIdx+4)%num].ColumnWidths = tv.columnWidths(w*4, 2, 1, 1)
+	tv.tableRef[(tv.shiftIdx+5)%num].ColumnWidths = tv.columnWidths(w*8, 4, 2, 2)
 
 	grid.Set(
 		termui.NewRow(0.03, termui.NewCol(1.0, tv.header)),
 		termui.NewRow(0.47,
-			termui.NewCol(1.0/2, tv.tableRef[(tv.shiftIdx+1)%num]),
-			termui.NewCol(1.0/2, tv.tableRef[(tv.shiftIdx+2)%num]),
+			termui.NewCol(1.0/3, tv.tableRef[(tv.shiftIdx+1)%num]),
+			termui.NewCol(1.0/3, tv.tableRef[(tv.shiftIdx+2)%num]),
+			termui.NewCol(1.0/3, tv.tableRef[(tv.shiftIdx+3)%num]),
+		),
+		termui.NewRow(0.47,
+			termui.NewCol(1.0/3, tv.tableRef[(tv.shiftIdx+4)%num]),
+			termui.NewCol(2.0/3, tv.tableRef[(tv.shiftIdx+5)%num]),
 		),
-		termui.NewRow(0.47, termui.NewCol(1.0, tv.tableRef[(tv.shiftIdx+3)%num])),
 		termui.NewRow(0.03, termui.NewCol(1.0, tv.footer)),
 	)
 	return grid
@@ -470,14 +1114,24 @@ func (tv *TableViewer) Resize(width, height int) {
 	termui.Render(tv.grid)
 }
 
-func (tv *TableViewer) Render(stats interface{}) {
-	snapshot := stats.(*Snapshot)
+func (tv *TableViewer) SetFooter(text string) {
+	if text == "" {
+		text = footerText
+	}
+	tv.footer.Text = text
+	termui.Render(tv.grid)
+}
+
+func (tv *TableViewer) Render(snapshot *stats.Snapshot) {
 	if snapshot == nil {
 		return
 	}
+	tv.last = snapshot
 	tv.updateHeader(snapshot)
 	tv.updateProcesses(snapshot)
 	tv.updateRemoteAddrs(snapshot)
+	tv.updateContainers(snapshot)
+	tv.updateInterfaces(snapshot)
 	tv.updateConnections(snapshot)
 	termui.Render(tv.grid)
 }
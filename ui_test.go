@@ -0,0 +1,179 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/chenjiandongx/sniffer/pkg/capture"
+	"github.com/chenjiandongx/sniffer/pkg/stats"
+	"github.com/chenjiandongx/termui/v3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlotViewerSeries(t *testing.T) {
+	pv := &PlotViewer{
+		bytesPlot:   newPlot("", 0),
+		packetsPlot: newPlot("", 0),
+		processes:   make(map[string]*series),
+		addrs:       make(map[string]*series),
+		pins:        []string{"dig", "10.0.0.3", "10.0.0.4"},
+		queueSize:   10,
+		unit:        UnitB,
+	}
+	names := func(shown []*series) []string {
+		var names []string
+		for _, sr := range shown {
+			names = append(names, sr.name)
+		}
+		return names
+	}
+
+	processes := map[string]*stats.NetworkData{"<3>:dig": {UploadBytes: 1}}
+	for i, name := range []string{"<10>:a", "<11>:b", "<12>:c", "<13>:d", "<14>:e", "<15>:f"} {
+		processes[name] = &stats.NetworkData{UploadBytes: 100 * (i + 1)}
+	}
+	pv.count++
+	pv.updateProcesses(&stats.Snapshot{
+		Processes: processes,
+		RemoteAddrs: map[string]*stats.NetworkData{
+			"10.0.0.2":    {UploadBytes: 600},
+			"10.0.0.3":    {UploadBytes: 10},
+			"example.com": {UploadBytes: 5},
+		},
+		Connections: map[capture.Connection]*stats.ConnectionData{
+			{Remote: capture.RemoteSocket{IP: "10.0.0.4", Port: 443}}: {RemoteName: "example.com", UploadBytes: 5},
+		},
+	})
+
+	// The pinned ones come first, the address resolved from the pinned IP is pinned too.
+	// The series shown are capped at the colors, so the smallest top processes are out.
+	shown := pv.shownSeries()
+	assert.Equal(t, []string{"10.0.0.3", "<3>:dig", "example.com", "<15>:f", "<14>:e", "<13>:d", "<12>:c"}, names(shown))
+	assert.NotContains(t, pv.addrs, "10.0.0.2")
+	var shownColors []termui.Color
+	colors := map[string]termui.Color{}
+	for _, sr := range shown {
+		shownColors = append(shownColors, sr.color)
+		colors[sr.name] = sr.color
+	}
+	assert.ElementsMatch(t, lineColors, shownColors)
+
+	// The process with the most traffic in the window moves on top and the colors are kept.
+	update := func() {
+		pv.count++
+		pv.updateProcesses(&stats.Snapshot{
+			Processes: map[string]*stats.NetworkData{"<10>:a": {UploadBytes: 2000}, "<3>:dig": {}},
+		})
+	}
+	update()
+	assert.Len(t, pv.bytesPlot.Data[0], 2)
+	assert.Contains(t, pv.processes, "<11>:b")
+	shown = pv.shownSeries()
+	assert.Equal(t, "<10>:a", shown[3].name)
+	for _, sr := range shown {
+		if c, ok := colors[sr.name]; ok {
+			assert.Equal(t, c, sr.color)
+		}
+	}
+
+	// The processes idle through the window are dropped unless pinned.
+	for i := 0; i < pv.queueSize; i++ {
+		update()
+	}
+	assert.NotContains(t, pv.processes, "<11>:b")
+	assert.Contains(t, pv.processes, "<3>:dig")
+	assert.Contains(t, pv.addrs, "example.com")
+}
+
+func TestPlotViewerTotals(t *testing.T) {
+	pv := &PlotViewer{
+		totalBytesPlot:   newPlot("", 2),
+		totalPacketsPlot: newPlot("", 2),
+		unit:             UnitB,
+	}
+	for _, lst := range []**queue{&pv.bytesUpList, &pv.bytesDownList, &pv.packetsUpList, &pv.packetsDownList} {
+		*lst = pv.newQueue(10)
+	}
+
+	pv.updateTotals(&stats.Snapshot{TotalUploadBytes: 100, TotalDownloadBytes: 300, TotalUploadPackets: 1, TotalDownloadPackets: 3})
+	pv.updateTotals(&stats.Snapshot{TotalUploadBytes: 200, TotalDownloadBytes: 400, TotalUploadPackets: 2, TotalDownloadPackets: 4})
+	assert.Equal(t, [][]float64{{100, 200}, {300, 400}}, pv.totalBytesPlot.Data)
+	assert.Equal(t, [][]float64{{1, 2}, {3, 4}}, pv.totalPacketsPlot.Data)
+}
+
+func TestNewMatcher(t *testing.T) {
+	tests := []struct {
+		pattern string
+		fields  []string
+		want    bool
+	}{
+		{pattern: "", fields: []string{"<1>:curl"}, want: true},
+		{pattern: "cu.l", fields: []string{"<1>:curl"}, want: true},
+		{pattern: "^curl", fields: []string{"<1>:curl"}, want: false},
+		{pattern: "443", fields: []string{"<1>:curl", "example.com", "443"}, want: true},
+		// The invalid regexps fall back to the substring match.
+		{pattern: "[curl", fields: []string{"<1>:[curl"}, want: true},
+		{pattern: "[curl", fields: []string{"<1>:curl"}, want: false},
+		{pattern: "curl(", fields: []string{"curl(1)"}, want: true},
+	}
+	for _, tt := range tests {
+		m := newMatcher(tt.pattern)
+		assert.Equal(t, tt.pattern == "", m == nil, tt.pattern)
+		assert.Equal(t, tt.want, m == nil || m(tt.fields...), tt.pattern)
+	}
+}
+
+func TestTableViewerSelection(t *testing.T) {
+	// The grid is left empty since the terminal is not initialized.
+	tv := newViewer(Options{ViewMode: ModeTableBytes, Unit: UnitKB}).(*TableViewer)
+	tv.header = newParagraph("")
+	tv.grid = termui.NewGrid()
+	render := func(processes map[string]int) {
+		snapshot := &stats.Snapshot{Processes: map[string]*stats.NetworkData{}, ProcessesTotal: &stats.NetworkData{}}
+		for name, upload := range processes {
+			snapshot.Processes[name] = &stats.NetworkData{UploadBytes: upload, ConnCount: 1}
+		}
+		tv.Render(snapshot)
+	}
+	// highlighted returns the rows highlighted besides the header.
+	highlighted := func() []int {
+		var rows []int
+		for row := range tv.processes.RowStyles {
+			if row > 0 {
+				rows = append(rows, row)
+			}
+		}
+		return rows
+	}
+
+	render(map[string]int{"<1>:curl": 300, "<2>:dig": 200, "<3>:nginx": 100})
+	tests := []struct {
+		processes map[string]int
+		delta     int
+		selected  string
+		rows      []int
+	}{
+		{delta: 1, selected: "<1>:curl", rows: []int{2}},
+		{delta: 1, selected: "<2>:dig", rows: []int{3}},
+		// The selection follows the process across the re-ranking.
+		{processes: map[string]int{"<1>:curl": 300, "<2>:dig": 500, "<3>:nginx": 100}, selected: "<2>:dig", rows: []int{2}},
+		{delta: 1, selected: "<1>:curl", rows: []int{3}},
+		// The selection of the process gone is not shown, the moves start over from the
+		// edges and are clamped into the rows.
+		{processes: map[string]int{"<2>:dig": 500, "<3>:nginx": 100}, selected: "<1>:curl"},
+		{delta: -1, selected: "<3>:nginx", rows: []int{3}},
+		{delta: 5, selected: "<3>:nginx", rows: []int{3}},
+		{delta: -5, selected: "<2>:dig", rows: []int{2}},
+		{processes: map[string]int{"<3>:nginx": 100}, selected: "<2>:dig"},
+		{delta: 1, selected: "<3>:nginx", rows: []int{2}},
+	}
+	for i, tt := range tests {
+		if tt.processes != nil {
+			render(tt.processes)
+		}
+		if tt.delta != 0 {
+			tv.selectProcess(tt.delta)
+		}
+		assert.Equal(t, tt.selected, tv.selected, i)
+		assert.Equal(t, tt.rows, highlighted(), i)
+	}
+}